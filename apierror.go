@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// APIError is a non-2xx response of a service's REST API. Use errors.As to get the status,
+// or errors.Is with the sentinel errors below for the common cases.
+type APIError struct {
+	// Service is the name the service is logged with, e.g. GitLab or Codeberg
+	Service    string
+	StatusCode int
+	// Body is the response body, usually the service's own error message
+	Body string
+}
+
+var (
+	// errAPIUnauthorized is a rejected token, which fails every request the same way
+	errAPIUnauthorized = errors.New("unauthorized")
+	// errAPIForbidden is a token without the permission for this request or repo
+	errAPIForbidden   = errors.New("forbidden")
+	errAPINotFound    = errors.New("not found")
+	errAPIRateLimited = errors.New("rate limited")
+)
+
+// newAPIError reads the body of the failed resp into an APIError, and logs it in full, as the
+// error message only has the status. The caller closes the body.
+func newAPIError(service string, resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	log.Printf("%s API error %d: %s", service, resp.StatusCode, string(body))
+	return &APIError{Service: service, StatusCode: resp.StatusCode, Body: string(body)}
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s API error %d %s", e.Service, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Is matches the sentinel errors by status. GitHub answers an exhausted rate limit with
+// 403 too, which is forbidden here; waitForRateLimitReset keeps it from getting that far.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case errAPIUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case errAPIForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case errAPINotFound:
+		return e.StatusCode == http.StatusNotFound
+	case errAPIRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// Retryable reports whether the request may succeed when sent again, like doWithRetry
+// decides; by the time a handler returns the error, its retries are used up.
+func (e *APIError) Retryable() bool {
+	return isRetryableStatus(e.StatusCode)
+}
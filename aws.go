@@ -0,0 +1,262 @@
+// AWS request signing and credentials, just enough for the CodeCommit API without the SDK
+// Signature Version 4: https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+// Credential chain: https://docs.aws.amazon.com/sdkref/latest/guide/standardized-credentials.html
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expiration is zero for long-lived keys
+	Expiration time.Time
+	// Source describes where the keys came from, for logging
+	Source string
+}
+
+var (
+	awsCredsMu sync.Mutex
+	awsCreds   *awsCredentials
+)
+
+// awsSecrets are the secret key and session token of awsCreds, for configuredSecrets.
+// They have their own lock, as log lines written while awsCredsMu is held get redacted too.
+var awsSecrets struct {
+	sync.Mutex
+	values []string
+}
+
+// metadata endpoints must answer fast, or we're not running on AWS
+var awsMetadataClient = &http.Client{Timeout: 2 * time.Second}
+
+// awsProfile returns the profile of the shared config files, AWS_PROFILE or "default".
+func awsProfile() string {
+	return getEnv("AWS_PROFILE", "default")
+}
+
+// getAWSCredentials resolves credentials like the AWS CLI does: environment, shared
+// credentials file, then the ECS task or EC2 instance role. They're cached until
+// shortly before they expire.
+func getAWSCredentials() (*awsCredentials, error) {
+	awsCredsMu.Lock()
+	defer awsCredsMu.Unlock()
+	if awsCreds != nil && (awsCreds.Expiration.IsZero() || time.Until(awsCreds.Expiration) > 5*time.Minute) {
+		return awsCreds, nil
+	}
+	creds, err := resolveAWSCredentials()
+	if err != nil {
+		return nil, err
+	}
+	awsCreds = creds
+	awsSecrets.Lock()
+	awsSecrets.values = append(awsSecrets.values, creds.SecretAccessKey, creds.SessionToken)
+	awsSecrets.Unlock()
+	return creds, nil
+}
+
+func resolveAWSCredentials() (*awsCredentials, error) {
+	if id, secret := lookupEnv("AWS_ACCESS_KEY_ID"), lookupEnv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: lookupEnv("AWS_SESSION_TOKEN"), Source: "environment"}, nil
+	}
+	credsFile := lookupEnv("AWS_SHARED_CREDENTIALS_FILE")
+	if credsFile == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			credsFile = filepath.Join(home, ".aws", "credentials")
+		}
+	}
+	if credsFile != "" {
+		section, err := readINISection(credsFile, awsProfile())
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if section["aws_access_key_id"] != "" && section["aws_secret_access_key"] != "" {
+			return &awsCredentials{
+				AccessKeyID:     section["aws_access_key_id"],
+				SecretAccessKey: section["aws_secret_access_key"],
+				SessionToken:    section["aws_session_token"],
+				Source:          fmt.Sprintf("profile %s in %s", awsProfile(), credsFile),
+			}, nil
+		}
+	}
+	// ECS tasks and CodeBuild
+	// Docs: https://docs.aws.amazon.com/AmazonECS/latest/developerguide/task-iam-roles.html
+	if uri := lookupEnv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return fetchAWSRoleCredentials("http://169.254.170.2"+uri, nil, "container role")
+	}
+	// EC2 instance role over IMDSv2
+	// Docs: https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/instancedata-data-retrieval.html
+	req, err := http.NewRequest("PUT", "http://169.254.169.254/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	resp, err := awsMetadataClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials found (set AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY, AWS_PROFILE, or run with an instance role)")
+	}
+	token, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("instance metadata token request failed with %d", resp.StatusCode)
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {string(token)}}
+	role, err := awsMetadataGet("http://169.254.169.254/latest/meta-data/iam/security-credentials/", header)
+	if err != nil {
+		return nil, fmt.Errorf("no instance role attached: %w", err)
+	}
+	role = strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0])
+	return fetchAWSRoleCredentials("http://169.254.169.254/latest/meta-data/iam/security-credentials/"+role, header, "instance role "+role)
+}
+
+func awsMetadataGet(u string, header http.Header) (string, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := awsMetadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %d", u, resp.StatusCode)
+	}
+	return string(b), nil
+}
+
+func fetchAWSRoleCredentials(u string, header http.Header, source string) (*awsCredentials, error) {
+	body, err := awsMetadataGet(u, header)
+	if err != nil {
+		return nil, err
+	}
+	var c struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal([]byte(body), &c); err != nil {
+		return nil, fmt.Errorf("decoding %s credentials: %w", source, err)
+	}
+	return &awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.Token, Expiration: c.Expiration, Source: source}, nil
+}
+
+// awsRegion returns AWS_REGION, AWS_DEFAULT_REGION or the region of the profile in ~/.aws/config.
+func awsRegion() string {
+	if region := getEnv("AWS_REGION", lookupEnv("AWS_DEFAULT_REGION")); region != "" {
+		return region
+	}
+	configFile := lookupEnv("AWS_CONFIG_FILE")
+	if configFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		configFile = filepath.Join(home, ".aws", "config")
+	}
+	name := "profile " + awsProfile()
+	if awsProfile() == "default" {
+		name = "default"
+	}
+	section, _ := readINISection(configFile, name)
+	return section["region"]
+}
+
+// readINISection returns the key/value pairs of [name] in an AWS style INI file.
+func readINISection(path, name string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := map[string]string{}
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = strings.TrimSpace(line[1:len(line)-1]) == name
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && inSection {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values, scanner.Err()
+}
+
+// signAWSRequest adds a Signature Version 4 Authorization header to req, whose body is payload.
+func signAWSRequest(req *http.Request, payload []byte, creds *awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	payloadHash := sha256Hex(payload)
+
+	// the host header isn't part of req.Header, but must be signed
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{req.Method, path, req.URL.Query().Encode(), canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,221 @@
+// Azure DevOps Services REST API
+// Overview: https://learn.microsoft.com/en-us/rest/api/azure/devops/
+// Git repositories: https://learn.microsoft.com/en-us/rest/api/azure/devops/git/repositories
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type AzureTeamProject struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type AzureRepo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	RemoteURL string `json:"remoteUrl"`
+	// DefaultBranch is a full ref like refs/heads/main, empty for repos without any branch yet
+	DefaultBranch string `json:"defaultBranch"`
+}
+
+// doAzureRequest builds a request against https://dev.azure.com/{org} and authenticates
+// with a personal access token as the Basic Auth password (empty username).
+// PATs: https://learn.microsoft.com/en-us/azure/devops/organizations/accounts/use-personal-access-tokens-to-authenticate
+func doAzureRequest(method, path string, queryParams map[string]string, body io.Reader) (*http.Response, error) {
+	u, err := url.Parse("https://dev.azure.com/" + url.PathEscape(config.AzureOrg) + path)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("api-version", "7.1")
+	for k, v := range queryParams {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth("", config.AzureToken)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doWithRetry(azClient, req)
+}
+
+func handleAzureResponse(resp *http.Response, target any) (any, error) {
+	defer resp.Body.Close()
+	// Azure DevOps answers an invalid PAT with 203 and a sign-in page instead of 401
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 && resp.StatusCode != http.StatusNonAuthoritativeInfo {
+		if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	}
+	return nil, newAPIError("Azure DevOps", resp)
+}
+
+// azureProjectPath is the URL path prefix of the project's git API.
+func azureProjectPath(project string) string {
+	return "/" + url.PathEscape(project) + "/_apis/git/repositories"
+}
+
+// Get project
+// Docs: https://learn.microsoft.com/en-us/rest/api/azure/devops/core/projects/get
+func getAzureProject(project string) (*AzureTeamProject, error) {
+	resp, err := doAzureRequest("GET", "/_apis/projects/"+url.PathEscape(project), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Azure DevOps project %s not found in organization %s", project, config.AzureOrg)
+	}
+	var proj AzureTeamProject
+	if _, err := handleAzureResponse(resp, &proj); err != nil {
+		return nil, err
+	}
+	return &proj, nil
+}
+
+// Get repository
+// Docs: https://learn.microsoft.com/en-us/rest/api/azure/devops/git/repositories/get-repository
+func getAzureRepo(project, repoName string) (*AzureRepo, error) {
+	resp, err := doAzureRequest("GET", azureProjectPath(project)+"/"+url.PathEscape(repoName), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil
+	}
+	var repo AzureRepo
+	if _, err := handleAzureResponse(resp, &repo); err != nil {
+		return nil, err
+	}
+	return &repo, nil
+}
+
+// Create repository
+// Docs: https://learn.microsoft.com/en-us/rest/api/azure/devops/git/repositories/create
+func createAzureRepo(project, repoName string) (*AzureRepo, error) {
+	if config.DryRun {
+		log.Printf("[dry-run] Would create Azure DevOps repo %s/%s", project, repoName)
+		return &AzureRepo{Name: repoName}, nil
+	}
+	proj, err := getAzureProject(project)
+	if err != nil {
+		return nil, err
+	}
+	byts, _ := json.Marshal(map[string]any{
+		"name":    repoName,
+		"project": map[string]string{"id": proj.ID},
+	})
+	resp, err := doAzureRequest("POST", azureProjectPath(project), nil, bytes.NewReader(byts))
+	if err != nil {
+		return nil, err
+	}
+	var repo AzureRepo
+	if _, err := handleAzureResponse(resp, &repo); err != nil {
+		return nil, err
+	}
+	log.Printf("Created Azure DevOps repo %s/%s", project, repoName)
+	markAction("created")
+	return &repo, nil
+}
+
+// Update repository
+// Docs: https://learn.microsoft.com/en-us/rest/api/azure/devops/git/repositories/update
+func updateAzureRepo(project, repoID string, fields map[string]any) (*AzureRepo, error) {
+	byts, _ := json.Marshal(fields)
+	if config.DryRun {
+		log.Printf("[dry-run] Would update Azure DevOps repo %s/%s: %s", project, repoID, byts)
+		return &AzureRepo{ID: repoID}, nil
+	}
+	resp, err := doAzureRequest("PATCH", azureProjectPath(project)+"/"+url.PathEscape(repoID), nil, bytes.NewReader(byts))
+	if err != nil {
+		return nil, err
+	}
+	var repo AzureRepo
+	if _, err := handleAzureResponse(resp, &repo); err != nil {
+		return nil, err
+	}
+	markAction("updated")
+	return &repo, nil
+}
+
+// Delete repository; the repo goes to the project's recycle bin
+// Docs: https://learn.microsoft.com/en-us/rest/api/azure/devops/git/repositories/delete
+func deleteAzureRepo(project, repoID string) error {
+	resp, err := doAzureRequest("DELETE", azureProjectPath(project)+"/"+url.PathEscape(repoID), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 && resp.StatusCode != http.StatusNonAuthoritativeInfo {
+		return nil
+	}
+	return newAPIError("Azure DevOps", resp)
+}
+
+// fixAzureDefaultBranch sets the repo's default branch to branch if it differs,
+// and returns the previous default branch when it was changed.
+func fixAzureDefaultBranch(project, repoName, branch string) (string, error) {
+	repo, err := getAzureRepo(project, repoName)
+	if err != nil {
+		return "", err
+	}
+	if repo == nil {
+		return "", nil
+	}
+	current := strings.TrimPrefix(repo.DefaultBranch, "refs/heads/")
+	if current == branch {
+		return "", nil
+	}
+	if _, err := updateAzureRepo(project, repo.ID, map[string]any{"defaultBranch": "refs/heads/" + branch}); err != nil {
+		return "", err
+	}
+	log.Printf("Updated Azure DevOps repo %s default branch %q -> %q", repoName, current, branch)
+	return current, nil
+}
+
+// checkAndValidateAzureRepo creates the repo in project if it's missing.
+// Azure DevOps repos have neither a description nor their own visibility (it's the project's).
+func checkAndValidateAzureRepo(project, repoName string) error {
+	repo, err := getAzureRepo(project, repoName)
+	if err != nil {
+		return err
+	}
+	if repo == nil {
+		_, err := createAzureRepo(project, repoName)
+		return err
+	}
+	log.Printf("Azure DevOps repo %s/%s exists", project, repoName)
+	return nil
+}
+
+// azureRepoURL returns the (unauthenticated) HTTPS git URL of the repo.
+func azureRepoURL(project, repoName string) string {
+	return fmt.Sprintf("https://dev.azure.com/%s/%s/_git/%s", url.PathEscape(config.AzureOrg), url.PathEscape(project), url.PathEscape(repoName))
+}
+
+// Push a mirrored repository to Azure Repos over HTTPS, with the PAT as password.
+func syncToAzure(token, project, repoName, localPath string) error {
+	pushURL, err := withCredentials(azureRepoURL(project, repoName), "", token)
+	if err != nil {
+		return err
+	}
+	log.Printf("Pushing %s -> Azure DevOps (%s/%s) ...", repoName, config.AzureOrg, project)
+	return pushMirror(localPath, pushURL)
+}
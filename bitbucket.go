@@ -1,4 +1,4 @@
-// Bitbucket Cloud REST API
+// Bitbucket Cloud REST API, see bitbucket_server.go for Bitbucket Data Center
 // Overview (v1): https://support.atlassian.com/bitbucket-cloud/docs/use-bitbucket-rest-api-version-1/
 // Overview (v2): https://support.atlassian.com/bitbucket-cloud/docs/use-the-bitbucket-cloud-rest-apis/
 // Repositories (v2 group): https://developer.atlassian.com/cloud/bitbucket/rest/api-group-repositories/
@@ -19,7 +19,16 @@ type BitbucketRepo struct {
 	UUID      string `json:"uuid"`
 	Slug      string `json:"slug"`
 	IsPrivate bool   `json:"is_private"`
-	Links     struct {
+	// Description is empty when not set
+	Description string `json:"description"`
+	// Mainbranch is null for repos without any branch yet
+	Mainbranch *struct {
+		Name string `json:"name"`
+	} `json:"mainbranch"`
+	Project *struct {
+		Key string `json:"key"`
+	} `json:"project"`
+	Links struct {
 		HTML struct {
 			Href string `json:"href"`
 		} `json:"html"`
@@ -59,7 +68,7 @@ func doBitbucketRequest(method, path string, queryParams map[string]string, body
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
-	return bbClient.Do(req)
+	return doWithRetry(bbClient, req)
 }
 
 func handleBitbucketResponse(resp *http.Response, target any) (any, error) {
@@ -73,14 +82,19 @@ func handleBitbucketResponse(resp *http.Response, target any) (any, error) {
 		}
 		return target, nil
 	}
-	b, _ := io.ReadAll(resp.Body)
-	log.Printf("Bitbucket API error %d: %s", resp.StatusCode, string(b))
-	return nil, fmt.Errorf("API error")
+	return nil, newAPIError("Bitbucket", resp)
 }
 
 // GET repository
 // Docs: https://developer.atlassian.com/cloud/bitbucket/rest/api-group-repositories/#api-repositories-workspace-repo-slug-get
 func getBitbucketRepo(workspace, repoSlug string) (*BitbucketRepo, error) {
+	if bitbucketServer() {
+		repo, err := getBitbucketServerRepo(workspace, repoSlug)
+		if repo == nil {
+			return nil, err
+		}
+		return repo.asBitbucketRepo(), nil
+	}
 	resp, err := doBitbucketRequest("GET", fmt.Sprintf("/repositories/%s/%s", workspace, repoSlug), nil, nil)
 	if err != nil {
 		return nil, err
@@ -102,10 +116,19 @@ func getBitbucketRepo(workspace, repoSlug string) (*BitbucketRepo, error) {
 
 // CREATE repository
 // Docs: https://developer.atlassian.com/cloud/bitbucket/rest/api-group-repositories/#api-repositories-workspace-repo-slug-post
-func createBitbucketRepo(workspace, repoSlug string, private bool) (*BitbucketRepo, error) {
+func createBitbucketRepo(workspace, repoSlug string, private bool, description string) (*BitbucketRepo, error) {
+	if config.DryRun {
+		log.Printf("[dry-run] Would create Bitbucket repo %s/%s (private %v)", workspace, repoSlug, private)
+		return &BitbucketRepo{Slug: repoSlug, IsPrivate: private}, nil
+	}
 	body := map[string]any{
-		"scm":        "git",
-		"is_private": private,
+		"scm":         "git",
+		"is_private":  private,
+		"description": description,
+	}
+	// without a project, Bitbucket picks the workspace's oldest one, unless a policy requires one
+	if config.BitbucketProject != "" {
+		body["project"] = map[string]string{"key": config.BitbucketProject}
 	}
 	byts, _ := json.Marshal(body)
 	resp, err := doBitbucketRequest("POST", fmt.Sprintf("/repositories/%s/%s", workspace, repoSlug), nil, bytes.NewReader(byts))
@@ -119,16 +142,20 @@ func createBitbucketRepo(workspace, repoSlug string, private bool) (*BitbucketRe
 	}
 	if result != nil {
 		log.Printf("Created Bitbucket repo %s/%s", workspace, repoSlug)
+		markAction("created")
 		return result.(*BitbucketRepo), nil
 	}
 	return nil, fmt.Errorf("unexpected response")
 }
 
-// UPDATE repository (toggle privacy via is_private)
+// UPDATE repository
 // Docs: https://developer.atlassian.com/cloud/bitbucket/rest/api-group-repositories/#api-repositories-workspace-repo-slug-put
-func updateBitbucketRepoPrivacy(workspace, repoSlug string, private bool) (*BitbucketRepo, error) {
-	body := map[string]any{"is_private": private}
-	byts, _ := json.Marshal(body)
+func updateBitbucketRepo(workspace, repoSlug string, fields map[string]any) (*BitbucketRepo, error) {
+	byts, _ := json.Marshal(fields)
+	if config.DryRun {
+		log.Printf("[dry-run] Would update Bitbucket repo %s/%s: %s", workspace, repoSlug, byts)
+		return &BitbucketRepo{Slug: repoSlug}, nil
+	}
 	resp, err := doBitbucketRequest("PUT", fmt.Sprintf("/repositories/%s/%s", workspace, repoSlug), nil, bytes.NewReader(byts))
 	if err != nil {
 		return nil, err
@@ -139,34 +166,151 @@ func updateBitbucketRepoPrivacy(workspace, repoSlug string, private bool) (*Bitb
 		return nil, err
 	}
 	if result != nil {
+		markAction("updated")
 		return result.(*BitbucketRepo), nil
 	}
 	return nil, fmt.Errorf("unexpected response")
 }
 
+// fixBitbucketDefaultBranch sets the repo's main branch to branch if it differs,
+// and returns the previous main branch when it was changed.
+func fixBitbucketDefaultBranch(workspace, repoSlug, branch string) (string, error) {
+	if bitbucketServer() {
+		return fixBitbucketServerDefaultBranch(workspace, repoSlug, branch)
+	}
+	repo, err := getBitbucketRepo(workspace, repoSlug)
+	if err != nil {
+		return "", err
+	}
+	if repo == nil {
+		return "", nil
+	}
+	current := ""
+	if repo.Mainbranch != nil {
+		current = repo.Mainbranch.Name
+	}
+	if current == branch {
+		return "", nil
+	}
+	if _, err := updateBitbucketRepo(workspace, repoSlug, map[string]any{"mainbranch": map[string]any{"name": branch}}); err != nil {
+		return "", err
+	}
+	log.Printf("Updated Bitbucket repo %s/%s main branch %q -> %q", workspace, repoSlug, current, branch)
+	return current, nil
+}
+
 // Ensure repository exists and matches desired privacy; create or update as needed.
-func checkAndValidateBitbucketRepo(workspace, repoSlug string, private bool) error {
+func checkAndValidateBitbucketRepo(workspace, repoSlug string, private bool, description string) error {
+	if bitbucketServer() {
+		return checkAndValidateBitbucketServerRepo(workspace, repoSlug, private, description)
+	}
 	repo, err := getBitbucketRepo(workspace, repoSlug)
 	if err != nil {
 		return err
 	}
 	if repo == nil {
-		_, err := createBitbucketRepo(workspace, repoSlug, private)
+		_, err := createBitbucketRepo(workspace, repoSlug, private, description)
 		return err
 	}
-	if repo.IsPrivate != private {
-		_, err := updateBitbucketRepoPrivacy(workspace, repoSlug, private)
+	fields := map[string]any{}
+	if repo.IsPrivate != private && confirmChange("bitbucket", repoSlug, fmt.Sprintf("change private %v -> %v", repo.IsPrivate, private)) {
+		fields["is_private"] = private
+	}
+	if repo.Description != description {
+		fields["description"] = description
+	}
+	if key := config.BitbucketProject; key != "" && (repo.Project == nil || !strings.EqualFold(repo.Project.Key, key)) {
+		current := "none"
+		if repo.Project != nil {
+			current = repo.Project.Key
+		}
+		if confirmChange("bitbucket", repoSlug, fmt.Sprintf("move from project %s to %s", current, key)) {
+			fields["project"] = map[string]string{"key": key}
+			log.Printf("Moving Bitbucket repo %s/%s from project %s to %s", workspace, repoSlug, current, key)
+		}
+	}
+	if len(fields) > 0 {
+		_, err := updateBitbucketRepo(workspace, repoSlug, fields)
 		return err
 	}
-	log.Printf("Bitbucket repo %s/%s exists with desired privacy %v", workspace, repoSlug, private)
+	log.Printf("Bitbucket repo %s/%s exists with desired privacy %v and description", workspace, repoSlug, private)
 	return nil
 }
 
+// bitbucketRepoURL returns the (unauthenticated) HTTPS git URL of the repo.
+func bitbucketRepoURL(workspace, repoSlug string) string {
+	if bitbucketServer() {
+		return bitbucketServerRepoURL(workspace, repoSlug)
+	}
+	return fmt.Sprintf("https://bitbucket.org/%s/%s.git", workspace, repoSlug)
+}
+
 // Push a mirrored repository to Bitbucket over HTTPS with API Token.
 // https://support.atlassian.com/bitbucket-cloud/docs/using-api-tokens/
 func syncToBitbucket(email, token, workspace, repoSlug, localPath string) error {
-	bbURL := fmt.Sprintf("https://bitbucket.org/%s/%s.git", workspace, repoSlug)
-	pushURL := strings.Replace(bbURL, "https://", fmt.Sprintf("https://x-bitbucket-api-token-auth:%s@", token), 1)
+	pushURL, err := bitbucketAuthURL(bitbucketRepoURL(workspace, repoSlug), token)
+	if err != nil {
+		return err
+	}
 	log.Printf("Pushing %s -> Bitbucket (%s) ...", repoSlug, workspace)
-	return runCmd("git", "--git-dir", localPath, "push", "--mirror", pushURL)
+	return pushMirror(localPath, pushURL)
+}
+
+// bitbucketAuthURL adds the git credentials of token to the HTTPS URL of a repo: API tokens
+// of Bitbucket Cloud go with a fixed user, Data Center access tokens with their user's name.
+func bitbucketAuthURL(repoURL, token string) (string, error) {
+	if bitbucketServer() {
+		return withCredentials(repoURL, config.BitbucketUser, token)
+	}
+	return withCredentials(repoURL, "x-bitbucket-api-token-auth", token)
+}
+
+// LIST repositories in a workspace
+// Docs: https://developer.atlassian.com/cloud/bitbucket/rest/api-group-repositories/#api-repositories-workspace-get
+func listBitbucketRepos(workspace string) ([]BitbucketRepo, error) {
+	var repos []BitbucketRepo
+	if bitbucketServer() {
+		serverRepos, err := listBitbucketServerRepos(workspace)
+		for _, r := range serverRepos {
+			repos = append(repos, *r.asBitbucketRepo())
+		}
+		return repos, err
+	}
+	params := map[string]string{"pagelen": "100", "page": "1"}
+	for {
+		resp, err := doBitbucketRequest("GET", fmt.Sprintf("/repositories/%s", workspace), params, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Values []BitbucketRepo `json:"values"`
+			Next   string          `json:"next"`
+		}
+		if _, err := handleBitbucketResponse(resp, &page); err != nil {
+			return nil, err
+		}
+		repos = append(repos, page.Values...)
+		if page.Next == "" {
+			return repos, nil
+		}
+		next, err := url.Parse(page.Next)
+		if err != nil {
+			return nil, err
+		}
+		params["page"] = next.Query().Get("page")
+	}
+}
+
+// DELETE repository
+// Docs: https://developer.atlassian.com/cloud/bitbucket/rest/api-group-repositories/#api-repositories-workspace-repo-slug-delete
+func deleteBitbucketRepo(workspace, repoSlug string) error {
+	if bitbucketServer() {
+		return deleteBitbucketServerRepo(workspace, repoSlug)
+	}
+	resp, err := doBitbucketRequest("DELETE", fmt.Sprintf("/repositories/%s/%s", workspace, repoSlug), nil, nil)
+	if err != nil {
+		return err
+	}
+	_, err = handleBitbucketResponse(resp, nil)
+	return err
 }
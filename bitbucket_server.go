@@ -0,0 +1,234 @@
+// Bitbucket Data Center (self-hosted) REST API, used instead of Bitbucket Cloud when BITBUCKET_URL is set
+// Overview: https://developer.atlassian.com/server/bitbucket/rest/
+// Repositories: https://developer.atlassian.com/server/bitbucket/rest/v811/api-group-repository/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type BitbucketServerRepo struct {
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Public grants anonymous read access; Data Center repos are private otherwise
+	Public  bool `json:"public"`
+	Project struct {
+		Key string `json:"key"`
+	} `json:"project"`
+}
+
+// asBitbucketRepo converts repo for the callers of the Bitbucket Cloud functions.
+func (repo BitbucketServerRepo) asBitbucketRepo() *BitbucketRepo {
+	return &BitbucketRepo{Slug: repo.Slug, IsPrivate: !repo.Public, Description: repo.Description}
+}
+
+// bitbucketServer reports whether the bitbucket target is a Data Center instance.
+func bitbucketServer() bool {
+	return config.BitbucketURL != ""
+}
+
+// doBitbucketServerRequest builds a request against <BITBUCKET_URL>/rest/api/1.0 and
+// authenticates with an HTTP access token as bearer token.
+// HTTP access tokens: https://confluence.atlassian.com/bitbucketserver/http-access-tokens-939515499.html
+func doBitbucketServerRequest(method, path string, queryParams map[string]string, body io.Reader) (*http.Response, error) {
+	u, err := url.Parse(config.BitbucketURL + "/rest/api/1.0" + path)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	for k, v := range queryParams {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+config.BitbucketToken)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doWithRetry(bbClient, req)
+}
+
+// bitbucketServerRepoPath is the API path of the repo; Data Center slugs are lowercase.
+func bitbucketServerRepoPath(projectKey, repoSlug string) string {
+	return fmt.Sprintf("/projects/%s/repos/%s", url.PathEscape(projectKey), url.PathEscape(strings.ToLower(repoSlug)))
+}
+
+// Get repository
+// Docs: https://developer.atlassian.com/server/bitbucket/rest/v811/api-group-repository/#api-api-latest-projects-projectkey-repos-repositoryslug-get
+func getBitbucketServerRepo(projectKey, repoSlug string) (*BitbucketServerRepo, error) {
+	resp, err := doBitbucketServerRequest("GET", bitbucketServerRepoPath(projectKey, repoSlug), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil
+	}
+	var repo BitbucketServerRepo
+	if _, err := handleBitbucketResponse(resp, &repo); err != nil {
+		return nil, err
+	}
+	return &repo, nil
+}
+
+// Create repository
+// Docs: https://developer.atlassian.com/server/bitbucket/rest/v811/api-group-repository/#api-api-latest-projects-projectkey-repos-post
+func createBitbucketServerRepo(projectKey, repoSlug string, private bool, description string) (*BitbucketServerRepo, error) {
+	if config.DryRun {
+		log.Printf("[dry-run] Would create Bitbucket Data Center repo %s/%s (private %v)", projectKey, repoSlug, private)
+		return &BitbucketServerRepo{Slug: repoSlug, Public: !private}, nil
+	}
+	byts, _ := json.Marshal(map[string]any{
+		"name":        repoSlug,
+		"scmId":       "git",
+		"public":      !private,
+		"description": description,
+	})
+	resp, err := doBitbucketServerRequest("POST", fmt.Sprintf("/projects/%s/repos", url.PathEscape(projectKey)), nil, bytes.NewReader(byts))
+	if err != nil {
+		return nil, err
+	}
+	var repo BitbucketServerRepo
+	if _, err := handleBitbucketResponse(resp, &repo); err != nil {
+		return nil, err
+	}
+	log.Printf("Created Bitbucket Data Center repo %s/%s", projectKey, repo.Slug)
+	markAction("created")
+	return &repo, nil
+}
+
+// Update repository
+// Docs: https://developer.atlassian.com/server/bitbucket/rest/v811/api-group-repository/#api-api-latest-projects-projectkey-repos-repositoryslug-put
+func updateBitbucketServerRepo(projectKey, repoSlug string, fields map[string]any) error {
+	byts, _ := json.Marshal(fields)
+	if config.DryRun {
+		log.Printf("[dry-run] Would update Bitbucket Data Center repo %s/%s: %s", projectKey, repoSlug, byts)
+		return nil
+	}
+	resp, err := doBitbucketServerRequest("PUT", bitbucketServerRepoPath(projectKey, repoSlug), nil, bytes.NewReader(byts))
+	if err != nil {
+		return err
+	}
+	var repo BitbucketServerRepo
+	if _, err := handleBitbucketResponse(resp, &repo); err != nil {
+		return err
+	}
+	markAction("updated")
+	return nil
+}
+
+// checkAndValidateBitbucketServerRepo is checkAndValidateBitbucketRepo for Data Center.
+func checkAndValidateBitbucketServerRepo(projectKey, repoSlug string, private bool, description string) error {
+	repo, err := getBitbucketServerRepo(projectKey, repoSlug)
+	if err != nil {
+		return err
+	}
+	if repo == nil {
+		_, err := createBitbucketServerRepo(projectKey, repoSlug, private, description)
+		return err
+	}
+	fields := map[string]any{}
+	if repo.Public == private && confirmChange("bitbucket", repoSlug, fmt.Sprintf("change public %v -> %v", repo.Public, !private)) {
+		fields["public"] = !private
+	}
+	if repo.Description != description {
+		fields["description"] = description
+	}
+	if len(fields) > 0 {
+		return updateBitbucketServerRepo(projectKey, repoSlug, fields)
+	}
+	log.Printf("Bitbucket Data Center repo %s/%s exists with desired privacy %v and description", projectKey, repoSlug, private)
+	return nil
+}
+
+// fixBitbucketServerDefaultBranch is fixBitbucketDefaultBranch for Data Center.
+// Docs: https://developer.atlassian.com/server/bitbucket/rest/v811/api-group-repository/#api-api-latest-projects-projectkey-repos-repositoryslug-default-branch-get
+func fixBitbucketServerDefaultBranch(projectKey, repoSlug, branch string) (string, error) {
+	path := bitbucketServerRepoPath(projectKey, repoSlug) + "/default-branch"
+	resp, err := doBitbucketServerRequest("GET", path, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	var current struct {
+		DisplayID string `json:"displayId"`
+	}
+	// a repo without any branch has no default branch yet
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+	} else if _, err := handleBitbucketResponse(resp, &current); err != nil {
+		return "", err
+	}
+	if current.DisplayID == branch {
+		return "", nil
+	}
+	if config.DryRun {
+		log.Printf("[dry-run] Would set the default branch of Bitbucket Data Center repo %s/%s to %q", projectKey, repoSlug, branch)
+		return "", nil
+	}
+	byts, _ := json.Marshal(map[string]string{"id": "refs/heads/" + branch})
+	if resp, err = doBitbucketServerRequest("PUT", path, nil, bytes.NewReader(byts)); err != nil {
+		return "", err
+	}
+	if _, err := handleBitbucketResponse(resp, nil); err != nil {
+		return "", err
+	}
+	markAction("updated")
+	log.Printf("Updated Bitbucket Data Center repo %s/%s default branch %q -> %q", projectKey, repoSlug, current.DisplayID, branch)
+	return current.DisplayID, nil
+}
+
+// bitbucketServerRepoURL returns the (unauthenticated) HTTPS git URL of the repo.
+func bitbucketServerRepoURL(projectKey, repoSlug string) string {
+	return fmt.Sprintf("%s/scm/%s/%s.git", config.BitbucketURL, strings.ToLower(projectKey), strings.ToLower(repoSlug))
+}
+
+// List repositories of a project
+// Docs: https://developer.atlassian.com/server/bitbucket/rest/v811/api-group-repository/#api-api-latest-projects-projectkey-repos-get
+func listBitbucketServerRepos(projectKey string) ([]BitbucketServerRepo, error) {
+	var repos []BitbucketServerRepo
+	params := map[string]string{"limit": "100", "start": "0"}
+	for {
+		resp, err := doBitbucketServerRequest("GET", fmt.Sprintf("/projects/%s/repos", url.PathEscape(projectKey)), params, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Values        []BitbucketServerRepo `json:"values"`
+			IsLastPage    bool                  `json:"isLastPage"`
+			NextPageStart int                   `json:"nextPageStart"`
+		}
+		if _, err := handleBitbucketResponse(resp, &page); err != nil {
+			return nil, err
+		}
+		repos = append(repos, page.Values...)
+		if page.IsLastPage {
+			return repos, nil
+		}
+		params["start"] = strconv.Itoa(page.NextPageStart)
+	}
+}
+
+// Delete repository
+// Docs: https://developer.atlassian.com/server/bitbucket/rest/v811/api-group-repository/#api-api-latest-projects-projectkey-repos-repositoryslug-delete
+func deleteBitbucketServerRepo(projectKey, repoSlug string) error {
+	resp, err := doBitbucketServerRequest("DELETE", bitbucketServerRepoPath(projectKey, repoSlug), nil, nil)
+	if err != nil {
+		return err
+	}
+	_, err = handleBitbucketResponse(resp, nil)
+	return err
+}
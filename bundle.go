@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// exportBundle writes the mirror at localPath as a single-file git bundle into
+// the export dir, named after the run so successive runs don't overwrite it.
+// It can be restored with: git clone --mirror <repo>_<run-id>.bundle <repo>.git
+func exportBundle(repoName, localPath string) error {
+	bundlePath := filepath.Join(config.ExportDir, fmt.Sprintf("%s_%s.bundle", repoName, runID))
+	if config.DryRun {
+		log.Printf("[dry-run] Would export %s to %s", repoName, bundlePath)
+		return nil
+	}
+	if refs, err := listRefs(localPath); err != nil {
+		return err
+	} else if len(refs) == 0 {
+		// git refuses to create an empty bundle
+		log.Printf("⚠️ Not exporting %s: the repository has no refs", repoName)
+		return nil
+	}
+	if err := os.MkdirAll(config.ExportDir, 0755); err != nil {
+		return err
+	}
+	// a bundle is self-contained, the blobs missing from a partial mirror are fetched first
+	if err := runCmd("git", append(partialCloneArgs(localPath), "--git-dir", localPath, "bundle", "create", bundlePath, "--all")...); err != nil {
+		os.Remove(bundlePath)
+		return err
+	}
+	info, err := os.Stat(bundlePath)
+	if err != nil {
+		return err
+	}
+	log.Printf("📦 Exported %s to %s (%s)", repoName, bundlePath, formatBytes(info.Size()))
+	return nil
+}
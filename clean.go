@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// staleMirror is a mirror clone in BackupDir whose GitHub repo is gone.
+type staleMirror struct {
+	Name string
+	Size int64
+}
+
+// runClean removes the mirror clones (and wiki mirrors) in BackupDir of repos missing from
+// githubNames, the complete listing of this run. Unlike -prune-remote it only touches local
+// disk. Gist mirrors are kept unless the gists were listed with -include-gists.
+func runClean(githubNames []string) error {
+	if len(githubNames) == 0 {
+		return fmt.Errorf("the GitHub listing is empty, refusing to remove every mirror")
+	}
+	current := make(map[string]bool, len(githubNames))
+	for _, name := range githubNames {
+		current[name] = true
+	}
+	entries, err := os.ReadDir(config.BackupDir)
+	if err != nil {
+		return err
+	}
+	var stale []staleMirror
+	var total int64
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".git") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimSuffix(entry.Name(), ".git"), ".wiki")
+		if current[name] || (!config.IncludeGists && strings.HasPrefix(name, "gist-")) {
+			continue
+		}
+		size, err := dirSize(filepath.Join(config.BackupDir, entry.Name()))
+		if err != nil {
+			log.Printf("🚫 Failed to measure %s: %v", entry.Name(), err)
+			continue
+		}
+		stale = append(stale, staleMirror{Name: entry.Name(), Size: size})
+		total += size
+	}
+	if len(stale) == 0 {
+		log.Printf("🧹 No stale mirrors in %s", config.BackupDir)
+		fmt.Printf("No stale mirrors in %s\n", config.BackupDir)
+		return nil
+	}
+	lines := Map(stale, func(m staleMirror) string { return fmt.Sprintf("%s (%s)", m.Name, formatBytes(m.Size)) })
+	log.Printf("🧹 %d mirror(s) in %s no longer exist on GitHub: %s", len(stale), config.BackupDir, strings.Join(lines, ", "))
+	fmt.Printf("%d mirror(s) in %s no longer exist on GitHub, %s in total:\n  %s\n", len(stale), config.BackupDir, formatBytes(total), strings.Join(lines, "\n  "))
+	if config.DryRun {
+		log.Printf("[dry-run] Would remove %d mirror(s), reclaiming %s", len(stale), formatBytes(total))
+		return nil
+	}
+	if !config.AssumeYes && !confirm("Remove them?", "yes") {
+		log.Printf("🧹 Clean not confirmed, skipped")
+		return nil
+	}
+	var reclaimed int64
+	for _, m := range stale {
+		if err := os.RemoveAll(filepath.Join(config.BackupDir, m.Name)); err != nil {
+			log.Printf("🚫 Failed to remove %s: %v", m.Name, err)
+			continue
+		}
+		reclaimed += m.Size
+		log.Printf("🗑️ Removed %s (%s)", m.Name, formatBytes(m.Size))
+	}
+	log.Printf("🧹 Clean done, reclaimed %s", formatBytes(reclaimed))
+	fmt.Printf("Reclaimed %s\n", formatBytes(reclaimed))
+	return nil
+}
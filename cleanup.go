@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// stopCtx is cancelled on the first SIGINT/SIGTERM: no new repo is started,
+// but the one being synced finishes and the reports are still written.
+var stopCtx, requestStop = context.WithCancel(context.Background())
+
+// runCtx is cancelled on the second signal, which kills all in-flight git commands.
+var runCtx, cancelRun = context.WithCancel(context.Background())
+
+// stopping reports whether the run was asked to stop after the current repo.
+func stopping() bool {
+	return stopCtx.Err() != nil
+}
+
+// runDeadline expires -run-deadline after the start of the run. Like stopCtx, no new repo
+// is started after it, but the run then ends as usual, with a summary of the repos it got to.
+var runDeadline = context.Background()
+
+// startRunDeadline sets runDeadline, d after runStarted; 0 means none.
+func startRunDeadline(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), runStarted.Add(d))
+	registerCleanup(cancel)
+	runDeadline = ctx
+}
+
+// pastRunDeadline reports whether the -run-deadline has passed.
+func pastRunDeadline() bool {
+	return runDeadline.Err() != nil
+}
+
+// runningCmds tracks the commands started by newCmd.
+var runningCmds sync.WaitGroup
+
+// Cleanup registry: anything that may leave credentials behind (temporary files,
+// tokens in a mirror's git config, ...) registers an undo function here, which runs
+// on every exit path: normal return, fatal errors, panics and SIGINT/SIGTERM.
+var (
+	cleanupMu sync.Mutex
+	cleanups  = make(map[int]func())
+	cleanupID int
+)
+
+// registerCleanup registers f to run at exit. The returned function runs f right away
+// and unregisters it, so the normal path can clean up early; it's safe to call more than once.
+func registerCleanup(f func()) (done func()) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	cleanupID++
+	id := cleanupID
+	cleanups[id] = f
+	return func() {
+		cleanupMu.Lock()
+		f, ok := cleanups[id]
+		delete(cleanups, id)
+		cleanupMu.Unlock()
+		if ok {
+			f()
+		}
+	}
+}
+
+// runCleanups runs all pending cleanups in reverse registration order.
+func runCleanups() {
+	cleanupMu.Lock()
+	pending, lastID := cleanups, cleanupID
+	cleanups = make(map[int]func())
+	cleanupMu.Unlock()
+	for id := lastID; id > 0; id-- {
+		if f, ok := pending[id]; ok {
+			f()
+		}
+	}
+}
+
+// exit runs all pending cleanups, then terminates the process with code.
+func exit(code int) {
+	runCleanups()
+	os.Exit(code)
+}
+
+// fatal is log.Fatal that runs the cleanups before exiting.
+func fatal(v ...any) {
+	log.Print(v...)
+	printFatal(fmt.Sprint(v...))
+	exit(1)
+}
+
+// fatalf is log.Fatalf that runs the cleanups before exiting.
+func fatalf(format string, v ...any) {
+	log.Print(fmt.Sprintf(format, v...))
+	printFatal(fmt.Sprintf(format, v...))
+	exit(1)
+}
+
+// handleSignals asks the run to stop after the current repo on the first SIGINT/SIGTERM,
+// and on the second one kills the git commands, runs the cleanups and exits.
+func handleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("🛑 Received %v, stopping after the current repo (send it again to quit immediately)", sig)
+		fmt.Fprintf(os.Stderr, "Received %v, stopping after the current repo (send it again to quit immediately)\n", sig)
+		requestStop()
+		sig = <-signals
+		log.Printf("🛑 Received %v again, cleaning up and exiting", sig)
+		cancelRun()
+		// let killed git commands return before their mirrors are cleaned up
+		waited := make(chan struct{})
+		go func() {
+			runningCmds.Wait()
+			close(waited)
+		}()
+		select {
+		case <-waited:
+		case <-time.After(15 * time.Second):
+		}
+		exit(130)
+	}()
+}
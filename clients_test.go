@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// withConfig restores the global config after the test.
+func withConfig(t *testing.T) {
+	old := config
+	t.Cleanup(func() { config = old })
+}
+
+// TestServiceClients checks that each service sends its requests through its own client,
+// so the per-service timeouts, proxies and throttling apply to it. Codeberg is the
+// Gitea target with GITEA_URL=https://codeberg.org.
+func TestServiceClients(t *testing.T) {
+	withConfig(t)
+	config.GitLabURL = "https://gitlab.example.com"
+	config.GiteaURL = "https://codeberg.org"
+	config.BitbucketURL = "https://bitbucket.example.com"
+	config.GitHubAPIURL = "https://api.github.com"
+
+	clients := map[string]*http.Client{
+		"github":           ghClient,
+		"gitlab":           glClient,
+		"gitea":            giteaClient,
+		"bitbucket":        bbClient,
+		"bitbucket-server": bbClient,
+		"azure":            azClient,
+		"sourcehut":        srhtClient,
+	}
+	var used []*http.Client
+	for _, c := range []*http.Client{ghClient, glClient, giteaClient, bbClient, azClient, srhtClient} {
+		c := c
+		old := c.Transport
+		c.Transport = NewRoundTripper(func(req *http.Request) (*http.Response, error) {
+			used = append(used, c)
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}")), Request: req}, nil
+		})
+		t.Cleanup(func() { c.Transport = old })
+	}
+
+	requests := map[string]func(method, path string, queryParams map[string]string, body io.Reader) (*http.Response, error){
+		"github":           doGitHubRequest,
+		"gitlab":           doGitLabRequest,
+		"gitea":            doGiteaRequest,
+		"bitbucket":        doBitbucketRequest,
+		"bitbucket-server": doBitbucketServerRequest,
+		"azure":            doAzureRequest,
+		"sourcehut":        doSourceHutRequest,
+	}
+	for service, do := range requests {
+		used = nil
+		resp, err := do("GET", "/user", nil, nil)
+		if err != nil {
+			t.Errorf("%s: %v", service, err)
+			continue
+		}
+		resp.Body.Close()
+		if len(used) != 1 || used[0] != clients[service] {
+			t.Errorf("%s did not send its request through its own client", service)
+		}
+	}
+}
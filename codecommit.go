@@ -0,0 +1,220 @@
+// AWS CodeCommit API (JSON 1.1 protocol, SigV4 signed)
+// Overview: https://docs.aws.amazon.com/codecommit/latest/APIReference/Welcome.html
+// Git access: https://docs.aws.amazon.com/codecommit/latest/userguide/setting-up.html
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type CodeCommitRepo struct {
+	RepositoryID   string `json:"repositoryId"`
+	RepositoryName string `json:"repositoryName"`
+	// Description and DefaultBranch are missing when not set
+	Description   string `json:"repositoryDescription"`
+	DefaultBranch string `json:"defaultBranch"`
+	CloneURLHTTP  string `json:"cloneUrlHttp"`
+}
+
+// codeCommitError is the error body of the JSON protocol, __type ends with the exception name.
+type codeCommitError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *codeCommitError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type[strings.LastIndex(e.Type, "#")+1:], e.Message)
+}
+
+func (e *codeCommitError) is(exception string) bool {
+	return strings.HasSuffix(e.Type, exception)
+}
+
+// doCodeCommitRequest calls the action (e.g. GetRepository) of the CodeCommit API in
+// config.CodeCommitRegion and decodes the response into target.
+func doCodeCommitRequest(action string, input, target any) error {
+	creds, err := getAWSCredentials()
+	if err != nil {
+		return err
+	}
+	payload, _ := json.Marshal(input)
+	req, err := http.NewRequest("POST", fmt.Sprintf("https://codecommit.%s.amazonaws.com/", config.CodeCommitRegion), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "CodeCommit_20150413."+action)
+	signAWSRequest(req, payload, creds, config.CodeCommitRegion, "codecommit", time.Now())
+	resp, err := doWithRetry(ccClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &codeCommitError{}
+		if json.Unmarshal(b, apiErr) == nil && apiErr.Type != "" {
+			return apiErr
+		}
+		log.Printf("CodeCommit API error %d: %s", resp.StatusCode, string(b))
+		return &APIError{Service: "CodeCommit", StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	if target == nil {
+		return nil
+	}
+	return json.Unmarshal(b, target)
+}
+
+// GetRepository
+// Docs: https://docs.aws.amazon.com/codecommit/latest/APIReference/API_GetRepository.html
+func getCodeCommitRepo(repoName string) (*CodeCommitRepo, error) {
+	var out struct {
+		RepositoryMetadata CodeCommitRepo `json:"repositoryMetadata"`
+	}
+	err := doCodeCommitRequest("GetRepository", map[string]string{"repositoryName": repoName}, &out)
+	if apiErr, ok := err.(*codeCommitError); ok && apiErr.is("RepositoryDoesNotExistException") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &out.RepositoryMetadata, nil
+}
+
+// CreateRepository
+// Docs: https://docs.aws.amazon.com/codecommit/latest/APIReference/API_CreateRepository.html
+func createCodeCommitRepo(repoName, description string) (*CodeCommitRepo, error) {
+	if config.DryRun {
+		log.Printf("[dry-run] Would create CodeCommit repo %s in %s", repoName, config.CodeCommitRegion)
+		return &CodeCommitRepo{RepositoryName: repoName}, nil
+	}
+	input := map[string]string{"repositoryName": repoName}
+	if description != "" {
+		input["repositoryDescription"] = description
+	}
+	var out struct {
+		RepositoryMetadata CodeCommitRepo `json:"repositoryMetadata"`
+	}
+	if err := doCodeCommitRequest("CreateRepository", input, &out); err != nil {
+		return nil, err
+	}
+	log.Printf("Created CodeCommit repo %s in %s", repoName, config.CodeCommitRegion)
+	markAction("created")
+	return &out.RepositoryMetadata, nil
+}
+
+// UpdateRepositoryDescription
+// Docs: https://docs.aws.amazon.com/codecommit/latest/APIReference/API_UpdateRepositoryDescription.html
+func updateCodeCommitDescription(repoName, description string) error {
+	if config.DryRun {
+		log.Printf("[dry-run] Would update CodeCommit repo %s description: %q", repoName, description)
+		return nil
+	}
+	if err := doCodeCommitRequest("UpdateRepositoryDescription", map[string]string{"repositoryName": repoName, "repositoryDescription": description}, nil); err != nil {
+		return err
+	}
+	markAction("updated")
+	return nil
+}
+
+// fixCodeCommitDefaultBranch sets the repo's default branch to branch if it differs,
+// and returns the previous default branch when it was changed.
+// Docs: https://docs.aws.amazon.com/codecommit/latest/APIReference/API_UpdateDefaultBranch.html
+func fixCodeCommitDefaultBranch(repoName, branch string) (string, error) {
+	repo, err := getCodeCommitRepo(repoName)
+	if err != nil {
+		return "", err
+	}
+	if repo == nil || repo.DefaultBranch == branch {
+		return "", nil
+	}
+	if err := doCodeCommitRequest("UpdateDefaultBranch", map[string]string{"repositoryName": repoName, "defaultBranchName": branch}, nil); err != nil {
+		return "", err
+	}
+	markAction("updated")
+	log.Printf("Updated CodeCommit repo %s default branch %q -> %q", repoName, repo.DefaultBranch, branch)
+	return repo.DefaultBranch, nil
+}
+
+// Ensure repository exists with the description; create or update as needed.
+// CodeCommit repos are private to the account, access is managed with IAM.
+func checkAndValidateCodeCommitRepo(repoName, description string) error {
+	repo, err := getCodeCommitRepo(repoName)
+	if err != nil {
+		return err
+	}
+	if repo == nil {
+		_, err := createCodeCommitRepo(repoName, description)
+		return err
+	}
+	if repo.Description != description {
+		return updateCodeCommitDescription(repoName, description)
+	}
+	log.Printf("CodeCommit repo %s exists with desired description", repoName)
+	return nil
+}
+
+// codeCommitRepoURL returns the (unauthenticated) HTTPS git URL of the repo.
+func codeCommitRepoURL(repoName string) string {
+	return fmt.Sprintf("https://git-codecommit.%s.amazonaws.com/v1/repos/%s", config.CodeCommitRegion, repoName)
+}
+
+// codeCommitPushURL returns the URL to push to: HTTPS with the Git credentials of an IAM
+// user when they're set, otherwise the git-remote-codecommit helper, which signs with the
+// same AWS credentials as the API calls.
+// Helper: https://docs.aws.amazon.com/codecommit/latest/userguide/setting-up-git-remote-codecommit.html
+func codeCommitPushURL(repoName string) (string, error) {
+	if config.CodeCommitGitUser != "" {
+		return withCredentials(codeCommitRepoURL(repoName), config.CodeCommitGitUser, config.CodeCommitGitPass)
+	}
+	profile := ""
+	if p := lookupEnv("AWS_PROFILE"); p != "" {
+		profile = url.PathEscape(p) + "@"
+	}
+	return fmt.Sprintf("codecommit::%s://%s%s", config.CodeCommitRegion, profile, repoName), nil
+}
+
+// Push a mirrored repository to CodeCommit.
+func syncToCodeCommit(repoName, localPath string) error {
+	pushURL, err := codeCommitPushURL(repoName)
+	if err != nil {
+		return err
+	}
+	log.Printf("Pushing %s -> CodeCommit (%s) ...", repoName, config.CodeCommitRegion)
+	return pushMirror(localPath, pushURL)
+}
+
+// ListRepositories
+// Docs: https://docs.aws.amazon.com/codecommit/latest/APIReference/API_ListRepositories.html
+func listCodeCommitRepos() ([]CodeCommitRepo, error) {
+	var repos []CodeCommitRepo
+	input := map[string]string{}
+	for {
+		var page struct {
+			Repositories []CodeCommitRepo `json:"repositories"`
+			NextToken    string           `json:"nextToken"`
+		}
+		if err := doCodeCommitRequest("ListRepositories", input, &page); err != nil {
+			return nil, err
+		}
+		repos = append(repos, page.Repositories...)
+		if page.NextToken == "" {
+			return repos, nil
+		}
+		input["nextToken"] = page.NextToken
+	}
+}
+
+// DeleteRepository
+// Docs: https://docs.aws.amazon.com/codecommit/latest/APIReference/API_DeleteRepository.html
+func deleteCodeCommitRepo(repoName string) error {
+	return doCodeCommitRequest("DeleteRepository", map[string]string{"repositoryName": repoName}, nil)
+}
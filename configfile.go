@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fileConfig holds the settings of the -config file, keyed by environment variable name.
+// Environment variables (including the ones from .env) win over it.
+var fileConfig = map[string]string{}
+
+// loadConfigFile reads a flat YAML file of "key: value" lines, where the keys are the
+// environment variable names in either case, e.g.
+//
+//	github_user: octocat
+//	gitlab_group: mirrors
+//	dest_added_topics: source-github, mirror
+//
+// Nested mappings, lists and multi-line values are not supported.
+func loadConfigFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return fmt.Errorf("%s:%d: expected \"key: value\"", path, n)
+		}
+		if value, err = parseYAMLScalar(value); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		values[strings.ToUpper(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	fileConfig = values
+	return nil
+}
+
+// parseYAMLScalar unquotes a quoted value, or strips a trailing comment from a plain one.
+func parseYAMLScalar(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := strings.LastIndex(value, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		end := strings.LastIndex(value, "'")
+		if end == 0 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+		return strings.ReplaceAll(value[1:end], "''", "'"), nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
+
+// lookupEnv returns the environment variable key, falling back to the -config file.
+func lookupEnv(key string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return fileConfig[key]
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// filterRepos returns the repos for which keep returns true, logging how many were dropped and why.
+func filterRepos(repos []GitHubRepo, reason string, keep func(GitHubRepo) bool) []GitHubRepo {
+	var kept []GitHubRepo
+	for _, r := range repos {
+		if keep(r) {
+			kept = append(kept, r)
+		}
+	}
+	if dropped := len(repos) - len(kept); dropped > 0 {
+		log.Printf("Filtered out %d repo(s): %s", dropped, reason)
+	}
+	return kept
+}
+
+// filterByOwner keeps only repos whose owner login is one of owners (case-insensitive).
+func filterByOwner(repos []GitHubRepo, owners []string) []GitHubRepo {
+	return filterRepos(repos, "owner not in OWNER_FILTER", func(r GitHubRepo) bool {
+		for _, owner := range owners {
+			if strings.EqualFold(r.Owner.Login, owner) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// filterByVisibility keeps only the "private" or the "public" repos, for -only-private/-only-public.
+func filterByVisibility(repos []GitHubRepo, visibility string) []GitHubRepo {
+	return filterRepos(repos, "not "+visibility+" (-only-"+visibility+")", func(r GitHubRepo) bool {
+		return r.Private == (visibility == "private")
+	})
+}
+
+// readRepoList reads the repo names of a -repos-from file, one per line;
+// blank lines and everything after a # are ignored.
+func readRepoList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if name := strings.TrimSpace(line); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s lists no repos", path)
+	}
+	return names, nil
+}
+
+// filterByNames keeps only the repos listed by name (case-insensitive, like GitHub) and fails
+// for names that aren't in the complete listing all, which usually are typos or renamed repos.
+func filterByNames(repos, all []GitHubRepo, names []string) ([]GitHubRepo, error) {
+	var missing []string
+	for _, name := range names {
+		if !containsRepo(all, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("not found among GitHub repos: %s", strings.Join(missing, ", "))
+	}
+	return filterRepos(repos, "not in -repos-from", func(r GitHubRepo) bool {
+		for _, name := range names {
+			if strings.EqualFold(r.Name, name) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func containsRepo(repos []GitHubRepo, name string) bool {
+	for _, r := range repos {
+		if strings.EqualFold(r.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitRepos keeps the first max repos of the listing for -max-repos, 0 keeps all.
+func limitRepos(repos []GitHubRepo, max int) []GitHubRepo {
+	if max <= 0 || len(repos) <= max {
+		return repos
+	}
+	log.Printf("Limiting the sync to the first %d of %d repos (-max-repos)", max, len(repos))
+	return repos[:max]
+}
+
+// validatePatterns makes sure every glob pattern is well-formed, as path.Match only
+// reports malformed patterns when it gets to match them.
+func validatePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterByPatterns keeps repos whose name matches one of include (when include is non-empty),
+// then drops those matching one of exclude.
+func filterByPatterns(repos []GitHubRepo, include, exclude []string) []GitHubRepo {
+	if len(include) > 0 {
+		repos = filterRepos(repos, "not matching include "+strings.Join(include, ","), func(r GitHubRepo) bool {
+			return matchesAny(r.Name, include)
+		})
+	}
+	if len(exclude) > 0 {
+		repos = filterRepos(repos, "matching exclude "+strings.Join(exclude, ","), func(r GitHubRepo) bool {
+			return !matchesAny(r.Name, exclude)
+		})
+	}
+	return repos
+}
+
+// filterPushedSince keeps only repos pushed to after cutoff.
+func filterPushedSince(repos []GitHubRepo, cutoff time.Time) []GitHubRepo {
+	return filterRepos(repos, "not pushed since "+cutoff.Format(time.RFC3339), func(r GitHubRepo) bool {
+		return r.PushedAt.After(cutoff)
+	})
+}
+
+// skipForks drops forked repos.
+func skipForks(repos []GitHubRepo) []GitHubRepo {
+	var kept []GitHubRepo
+	for _, r := range repos {
+		if !r.Fork {
+			kept = append(kept, r)
+		}
+	}
+	if skipped := len(repos) - len(kept); skipped > 0 {
+		log.Printf("Skipped %d forks (use -include-forks to mirror them)", skipped)
+	}
+	return kept
+}
+
+// applyFilters applies the fork, owner, include/exclude and -since filters of the config.
+func applyFilters(repos []GitHubRepo) []GitHubRepo {
+	if !config.IncludeForks {
+		repos = skipForks(repos)
+	}
+	if len(config.OwnerFilter) > 0 {
+		repos = filterByOwner(repos, config.OwnerFilter)
+	}
+	if config.OnlyVisibility != "" {
+		repos = filterByVisibility(repos, config.OnlyVisibility)
+	}
+	repos = filterByPatterns(repos, config.IncludePatterns, config.ExcludePatterns)
+	if config.Since > 0 {
+		repos = filterPushedSince(repos, runStarted.Add(-config.Since))
+	} else if config.SinceLastRun {
+		if state.LastSuccessfulRun.IsZero() {
+			log.Printf("No successful run recorded yet, syncing all repos (-since-last-run)")
+		} else {
+			repos = filterPushedSince(repos, state.LastSuccessfulRun)
+		}
+	}
+	return repos
+}
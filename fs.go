@@ -0,0 +1,171 @@
+// Bare repos on a (mounted) filesystem, e.g. a backup NAS, as -target fs with FS_TARGET_DIR
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// fsLockWait is how long a push waits for another run to release a destination repo
+	fsLockWait = 5 * time.Minute
+	// fsLockStale is the age after which a lock is taken over, its run must have died
+	fsLockStale = time.Hour
+)
+
+// fsRepoPath returns the path of the bare destination repo.
+func fsRepoPath(repoName string) string {
+	return filepath.Join(config.FSTargetDir, repoName+".git")
+}
+
+// fsRepoURL returns the file:// URL of the bare destination repo, also on Windows (file:///C:/...).
+func fsRepoURL(repoName string) string {
+	p := filepath.ToSlash(fsRepoPath(repoName))
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// checkAndValidateFSRepo creates the bare destination repo if missing, and keeps the
+// description in its description file (shown by gitweb and cgit).
+func checkAndValidateFSRepo(repoName, description string) error {
+	path := fsRepoPath(repoName)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if config.DryRun {
+			log.Printf("[dry-run] Would create the bare repo %s", path)
+			return nil
+		}
+		if err := runCmd("git", "init", "--bare", "--quiet", path); err != nil {
+			return err
+		}
+		log.Printf("Created the bare repo %s", path)
+		markAction("created")
+	} else if err != nil {
+		return err
+	}
+	if config.DryRun {
+		return nil
+	}
+	descriptionPath := filepath.Join(path, "description")
+	if current, err := os.ReadFile(descriptionPath); err == nil && strings.TrimSpace(string(current)) == description {
+		return nil
+	}
+	return os.WriteFile(descriptionPath, []byte(description+"\n"), 0644)
+}
+
+// syncToFS pushes the mirror to the bare destination repo, holding its lock meanwhile so
+// that two runs sharing the destination (e.g. from two hosts on the NAS) don't interleave.
+func syncToFS(repoName, localPath string) error {
+	log.Printf("Pushing %s -> %s ...", repoName, config.FSTargetDir)
+	if config.DryRun {
+		return pushMirror(localPath, fsRepoURL(repoName))
+	}
+	unlock, err := lockFSRepo(fsRepoPath(repoName))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return pushMirror(localPath, fsRepoURL(repoName))
+}
+
+// lockFSRepo creates <repo>/git-sync.lock exclusively, which works on NFS as well, waiting
+// up to fsLockWait for another holder. The lock is also released on exit.
+func lockFSRepo(path string) (unlock func(), err error) {
+	lockPath := filepath.Join(path, "git-sync.lock")
+	deadline := time.Now().Add(fsLockWait)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%s %d\n", runID, os.Getpid())
+			f.Close()
+			return registerCleanup(func() { os.Remove(lockPath) }), nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > fsLockStale {
+			log.Printf("⚠️ Taking over the stale lock %s from %s", lockPath, info.ModTime().Format("2006-01-02 15:04:05"))
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			holder, _ := os.ReadFile(lockPath)
+			return nil, fmt.Errorf("%s is locked by another run (%s)", path, strings.TrimSpace(string(holder)))
+		}
+		select {
+		case <-stopCtx.Done():
+			return nil, errCmdInterrupted
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// fixFSDefaultBranch points HEAD of the bare destination repo to branch if it differs,
+// and returns the previous default branch when it was changed.
+func fixFSDefaultBranch(repoName, branch string) (string, error) {
+	path := fsRepoPath(repoName)
+	out, err := runCmdOutput(nil, "git", "--git-dir", path, "symbolic-ref", "--quiet", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	current := strings.TrimPrefix(strings.TrimSpace(out), "refs/heads/")
+	if current == branch {
+		return "", nil
+	}
+	if err := runCmd("git", "--git-dir", path, "symbolic-ref", "HEAD", "refs/heads/"+branch); err != nil {
+		return "", err
+	}
+	markAction("updated")
+	log.Printf("Updated the default branch of %s %q -> %q", path, current, branch)
+	return current, nil
+}
+
+// listFSRepos returns the names of the bare repos in FS_TARGET_DIR, without the wikis,
+// which are deleted with their repo.
+func listFSRepos() ([]string, error) {
+	entries, err := os.ReadDir(config.FSTargetDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if name, ok := strings.CutSuffix(e.Name(), ".git"); ok && e.IsDir() && !strings.HasSuffix(name, ".wiki") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// deleteFSRepo removes the bare destination repo and its wiki, under the repo's lock.
+func deleteFSRepo(repoName string) error {
+	path := fsRepoPath(repoName)
+	unlock, err := lockFSRepo(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.RemoveAll(fsRepoPath(repoName + ".wiki")); err != nil {
+		return err
+	}
+	// the lock goes with the repo, unlock then has nothing left to remove
+	return os.RemoveAll(path)
+}
+
+// checkFSTargetDir makes sure FS_TARGET_DIR exists and is writable, e.g. that the NAS is mounted.
+func checkFSTargetDir() error {
+	info, err := os.Stat(config.FSTargetDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", config.FSTargetDir)
+	}
+	return ensureWritableDir(config.FSTargetDir)
+}
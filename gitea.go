@@ -0,0 +1,312 @@
+// Gitea and Forgejo, e.g. https://codeberg.org/user/settings/applications
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// https://forgejo.org/docs/latest/user/api-usage/
+// https://codeberg.org/api/swagger
+// https://scalar.val.run/codeberg.org/swagger.v1.json
+
+type GiteaRepoOwner struct {
+	Login    string `json:"login"`
+	Username string `json:"username"`
+}
+
+type GiteaRepo struct {
+	ID          int            `json:"id"`
+	Owner       GiteaRepoOwner `json:"owner"`
+	Name        string         `json:"name"`
+	OriginalURL string         `json:"original_url"`
+	HTMLURL     string         `json:"html_url"`
+	CloneURL    string         `json:"clone_url"`
+	SSHURL      string         `json:"ssh_url"`
+	URL         string         `json:"url"`
+	Private     bool           `json:"private"`
+	Archived    bool           `json:"archived"`
+	Topics      []string       `json:"topics"`
+	Description string         `json:"description"`
+	// DefaultBranch is empty for repos without any branch yet
+	DefaultBranch string `json:"default_branch"`
+}
+
+func doGiteaRequest(method, path string, queryParams map[string]string, body io.Reader) (*http.Response, error) {
+	// Build URL manually to handle pre-encoded paths properly
+	baseURL := config.GiteaURL + path
+	if len(queryParams) > 0 {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		q := u.Query()
+		for k, v := range queryParams {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+		baseURL = u.String()
+	}
+
+	req, err := http.NewRequest(method, baseURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "token "+config.GiteaToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doWithRetry(giteaClient, req)
+}
+
+func handleGiteaResponse(resp *http.Response, target any) (any, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	} else {
+		return nil, newAPIError(config.GiteaName, resp)
+	}
+}
+
+func createGiteaRepo(repoName string, private bool, description string) (*GiteaRepo, error) {
+	if config.DryRun {
+		log.Printf("[dry-run] Would create %s repo %s (private %v)", config.GiteaName, repoName, private)
+		return &GiteaRepo{Name: repoName, Private: private}, nil
+	}
+	bodyMap := map[string]any{
+		"auto_init":   false,
+		"name":        repoName,
+		"private":     private,
+		"description": description,
+	}
+	bodyBytes, err := json.Marshal(bodyMap)
+	if err != nil {
+		return nil, err
+	}
+
+	// https://codeberg.org/api/swagger#/organization/createOrgRepo
+	path := "/api/v1/user/repos"
+	if config.GiteaOrg != "" {
+		path = "/api/v1/orgs/" + url.PathEscape(config.GiteaOrg) + "/repos"
+	}
+	resp, err := doGiteaRequest("POST", path, nil, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	var repo GiteaRepo
+	result, err := handleGiteaResponse(resp, &repo)
+	if err != nil {
+		return nil, err
+	}
+	if result != nil {
+		markAction("created")
+		return result.(*GiteaRepo), nil
+	}
+	return nil, fmt.Errorf("unexpected response")
+}
+
+// https://codeberg.org/api/swagger#/repository/repoEdit
+func editGiteaRepo(owner, repoName string, fields map[string]any) (*GiteaRepo, error) {
+	bodyBytes, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if config.DryRun {
+		log.Printf("[dry-run] Would edit %s repo %s/%s: %s", config.GiteaName, owner, repoName, bodyBytes)
+		return &GiteaRepo{Name: repoName}, nil
+	}
+	path := "/api/v1/repos/" + owner + "/" + repoName
+	resp, err := doGiteaRequest("PATCH", path, nil, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	var repo GiteaRepo
+	result, err := handleGiteaResponse(resp, &repo)
+	if err != nil {
+		return nil, err
+	}
+	if result != nil {
+		markAction("updated")
+		return result.(*GiteaRepo), nil
+	}
+	return nil, fmt.Errorf("unexpected response")
+}
+
+// updateGiteaRepoPrivate updates the privacy and the description in one PATCH.
+func updateGiteaRepoPrivate(owner, repoName string, private bool, description string) (*GiteaRepo, error) {
+	return editGiteaRepo(owner, repoName, map[string]any{"private": private, "description": description})
+}
+
+// fixGiteaDefaultBranch sets the repo's default branch to branch if it differs,
+// and returns the previous default branch when it was changed.
+func fixGiteaDefaultBranch(owner, repoName, branch string) (string, error) {
+	repo, err := getGiteaRepo(owner, repoName)
+	if err != nil {
+		return "", err
+	}
+	if repo == nil || repo.DefaultBranch == branch {
+		return "", nil
+	}
+	if _, err := editGiteaRepo(owner, repoName, map[string]any{"default_branch": branch}); err != nil {
+		return "", err
+	}
+	log.Printf("Updated %s repo %s default branch %q -> %q", config.GiteaName, repoName, repo.DefaultBranch, branch)
+	return repo.DefaultBranch, nil
+}
+
+func getGiteaRepo(owner, repoName string) (*GiteaRepo, error) {
+	path := fmt.Sprintf("/api/v1/repos/%s/%s", owner, repoName)
+	resp, err := doGiteaRequest("GET", path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		var repo GiteaRepo
+		if err := json.NewDecoder(resp.Body).Decode(&repo); err != nil {
+			return nil, err
+		}
+		return &repo, nil
+	}
+	return nil, newAPIError(config.GiteaName, resp)
+}
+
+// https://codeberg.org/api/swagger#/repository/repoUpdateTopics
+func updateGiteaRepoTopics(owner, repoName string, topics []string) error {
+	bodyBytes, err := json.Marshal(map[string]any{"topics": topics})
+	if err != nil {
+		return err
+	}
+	if config.DryRun {
+		log.Printf("[dry-run] Would update %s repo %s topics -> %s", config.GiteaName, repoName, strings.Join(topics, ", "))
+		return nil
+	}
+	path := fmt.Sprintf("/api/v1/repos/%s/%s/topics", owner, repoName)
+	resp, err := doGiteaRequest("PUT", path, nil, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// 204 No Content on success
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		log.Printf("Updated %s repo %s topics -> %s", config.GiteaName, repoName, strings.Join(topics, ", "))
+		markAction("updated")
+		return nil
+	}
+	return newAPIError(config.GiteaName, resp)
+}
+
+func checkAndValidateGiteaRepo(owner, repoName string, private bool, description string, topics []string) error {
+	repo, err := getGiteaRepo(owner, repoName)
+	if err != nil {
+		return err
+	}
+	if repo == nil {
+		if _, err := createGiteaRepo(repoName, private, description); err != nil {
+			return err
+		}
+		log.Printf("Created %s repo %s", config.GiteaName, repoName)
+		// The create endpoint does not accept topics, set them afterwards
+		if len(topics) > 0 {
+			if err := updateGiteaRepoTopics(owner, repoName, topics); err != nil {
+				log.Printf("⚠️ Failed to set topics of %s repo %s: %v", config.GiteaName, repoName, err)
+			}
+		}
+		return nil
+	}
+	if repo.Private != private && !confirmChange(strings.ToLower(config.GiteaName), repoName, fmt.Sprintf("change private %v -> %v", repo.Private, private)) {
+		private = repo.Private
+	}
+	if repo.Private != private || repo.Description != description {
+		if _, err := updateGiteaRepoPrivate(owner, repoName, private, description); err != nil {
+			return err
+		}
+		log.Printf("Updated %s repo %s privacy -> %v, description -> %q", config.GiteaName, repoName, private, description)
+	} else {
+		log.Printf("%s repo %s exists with matching privacy %v and description", config.GiteaName, repoName, private)
+	}
+	// Topics are additive metadata: keep the existing ones and only add missing
+	if merged, changed := mergeTopics(repo.Topics, topics); changed {
+		if err := updateGiteaRepoTopics(owner, repoName, merged); err != nil {
+			log.Printf("⚠️ Failed to update topics of %s repo %s: %v", config.GiteaName, repoName, err)
+		}
+	}
+	return nil
+}
+
+// giteaRepoURL returns the (unauthenticated) HTTPS git URL of the repo.
+func giteaRepoURL(owner, repoName string) string {
+	return fmt.Sprintf("%s/%s/%s.git", config.GiteaURL, owner, repoName)
+}
+
+// syncToGitea pushes to the repo of owner, the user or their org, as the user.
+func syncToGitea(owner, token, repoName, localPath string) error {
+	pushURL, err := withCredentials(giteaRepoURL(owner, repoName), config.GiteaUser, token)
+	if err != nil {
+		return err
+	}
+	log.Printf("Pushing %s -> %s (%s) ...", repoName, config.GiteaName, owner)
+	return pushMirror(localPath, pushURL)
+}
+
+// listGiteaRepos lists the repos owned by owner among those the token has access to,
+// or all repos of GITEA_ORG / CODEBERG_ORG if owner is that org.
+// https://codeberg.org/api/swagger#/user/userCurrentListRepos
+// https://codeberg.org/api/swagger#/organization/orgListRepos
+func listGiteaRepos(owner string) ([]GiteaRepo, error) {
+	path := "/api/v1/user/repos"
+	if config.GiteaOrg != "" && owner == config.GiteaOrg {
+		path = "/api/v1/orgs/" + url.PathEscape(owner) + "/repos"
+	}
+	var repos []GiteaRepo
+	for page := 1; ; page++ {
+		resp, err := doGiteaRequest("GET", path, map[string]string{
+			"limit": "50",
+			"page":  fmt.Sprint(page),
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		var batch []GiteaRepo
+		if _, err := handleGiteaResponse(resp, &batch); err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			return repos, nil
+		}
+		for _, r := range batch {
+			if strings.EqualFold(r.Owner.Login, owner) {
+				repos = append(repos, r)
+			}
+		}
+	}
+}
+
+// https://codeberg.org/api/swagger#/repository/repoDelete
+func deleteGiteaRepo(owner, repoName string, archive bool) error {
+	if archive {
+		_, err := editGiteaRepo(owner, repoName, map[string]any{"archived": true})
+		return err
+	}
+	resp, err := doGiteaRequest("DELETE", fmt.Sprintf("/api/v1/repos/%s/%s", owner, repoName), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return newAPIError(config.GiteaName, resp)
+}
@@ -3,29 +3,106 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type GitHubRepo struct {
-	Name     string `json:"name"`
-	CloneURL string `json:"clone_url"`
-	Private  bool   `json:"private"`
+	Name     string      `json:"name"`
+	FullName string      `json:"full_name"`
+	Owner    GitHubOwner `json:"owner"`
+	CloneURL string      `json:"clone_url"`
+	Private  bool        `json:"private"`
+	Archived bool        `json:"archived"`
+	Fork     bool        `json:"fork"`
+	// Description is empty when not set (null)
+	Description string `json:"description"`
+	// DefaultBranch is empty for repos without any commit
+	DefaultBranch string `json:"default_branch"`
+	// Size is the size of the repository in KB, as estimated by GitHub
+	Size int `json:"size"`
+	// Topics is nil if the listing didn't include them
+	Topics []string `json:"topics"`
+	// PushedAt is the time of the last push to any branch
+	PushedAt time.Time `json:"pushed_at"`
+	// Gist is set for gists listed with -include-gists, see gistAsRepo
+	Gist    bool `json:"-"`
+	HasWiki bool `json:"has_wiki"`
+	// WikiPath is the wiki mirror, set when -sync-wiki mirrored one
+	WikiPath string `json:"-"`
+	// Releases are only fetched with -sync-releases
+	Releases []GitHubRelease `json:"-"`
+	// CustomProperties are listed for org repos, nil if the listing didn't include them
+	CustomProperties map[string]any `json:"custom_properties"`
+}
+
+type GitHubGist struct {
+	ID          string      `json:"id"`
+	Description string      `json:"description"`
+	Public      bool        `json:"public"`
+	GitPullURL  string      `json:"git_pull_url"`
+	Owner       GitHubOwner `json:"owner"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	Files       map[string]struct {
+		Filename string `json:"filename"`
+	} `json:"files"`
+}
+
+type GitHubOwner struct {
+	Login string `json:"login"`
+}
+
+// GitHubSecuritySettings holds repository level security settings. They live outside of git,
+// so a mirror does not carry them to the destination.
+type GitHubSecuritySettings struct {
+	// only returned to users with admin access, e.g. {"secret_scanning": {"status": "enabled"}}
+	SecurityAndAnalysis map[string]struct {
+		Status string `json:"status"`
+	} `json:"security_and_analysis"`
+	VulnerabilityAlerts bool `json:"vulnerability_alerts"`
+}
+
+// Enabled lists the names of all enabled settings.
+func (s *GitHubSecuritySettings) Enabled() []string {
+	var enabled []string
+	for name, feature := range s.SecurityAndAnalysis {
+		if feature.Status == "enabled" {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	if s.VulnerabilityAlerts {
+		enabled = append(enabled, "dependabot_alerts")
+	}
+	return enabled
+}
+
+// setGitHubAPIHeaders sets the media type and API version of GITHUB_ACCEPT/GITHUB_API_VERSION.
+// Docs: https://docs.github.com/en/rest/about-the-rest-api/api-versions
+func setGitHubAPIHeaders(req *http.Request) {
+	req.Header.Set("Accept", config.GitHubAccept)
+	if config.GitHubAPIVersion != "" {
+		req.Header.Set("X-GitHub-Api-Version", config.GitHubAPIVersion)
+	}
 }
 
 func doGitHubRequest(method, path string, queryParams map[string]string, body io.Reader) (*http.Response, error) {
-	u, err := url.Parse("https://api.github.com")
+	u, err := url.Parse(config.GitHubAPIURL)
 	if err != nil {
 		return nil, err
 	}
-	u.Path = path
+	// Keep the path prefix of GitHub Enterprise Server, e.g. https://ghe.example.com/api/v3
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
 	q := u.Query()
 	for k, v := range queryParams {
 		q.Set(k, v)
@@ -36,9 +113,76 @@ func doGitHubRequest(method, path string, queryParams map[string]string, body io
 	if err != nil {
 		return nil, err
 	}
-	req.SetBasicAuth(config.GitHubUser, config.GitHubToken)
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-	return ghClient.Do(req)
+	user, token, err := githubCredentials()
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(user, token)
+	setGitHubAPIHeaders(req)
+	for attempt := 0; ; attempt++ {
+		resp, err := doWithRetry(ghClient, req)
+		if err != nil {
+			return nil, err
+		}
+		// GitHub answers 403 (or 429) with X-RateLimit-Remaining: 0 once the quota is used up
+		rl, ok := rateLimitFromHeaders(resp.Header)
+		throttled := (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) && ok && rl.Remaining == 0
+		if !throttled || attempt >= config.MaxRetries {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if err := waitForRateLimitReset(rl); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// githubRateLimitLow is the remaining quota below which we wait for the rate limit reset.
+const githubRateLimitLow = 10
+
+type rateLimit struct {
+	Remaining int
+	Reset     time.Time
+}
+
+// rateLimitFromHeaders reads GitHub's X-RateLimit-Remaining and X-RateLimit-Reset headers.
+// https://docs.github.com/en/rest/using-the-rest-api/rate-limits-for-the-rest-api#checking-the-status-of-your-rate-limit
+func rateLimitFromHeaders(h http.Header) (rateLimit, bool) {
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return rateLimit{}, false
+	}
+	reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return rateLimit{}, false
+	}
+	return rateLimit{Remaining: remaining, Reset: time.Unix(reset, 0)}, true
+}
+
+// waitForRateLimitReset sleeps until the rate limit window resets. The wait, up to an hour,
+// ends with an error on an interrupt or at the -run-deadline.
+func waitForRateLimitReset(rl rateLimit) error {
+	// the reset time has second precision, add a little slack
+	wait := time.Until(rl.Reset) + time.Second
+	if wait <= 0 {
+		return nil
+	}
+	log.Printf("⏳ GitHub rate limit almost used up (%d requests left), waiting %v until %s", rl.Remaining, wait.Round(time.Second), rl.Reset.Format("15:04:05"))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-stopCtx.Done():
+		return fmt.Errorf("waiting for the GitHub rate limit reset: %w", errCmdInterrupted)
+	case <-runDeadline.Done():
+		return fmt.Errorf("waiting for the GitHub rate limit reset: run deadline of %v passed", config.RunDeadline)
+	case <-timer.C:
+		return nil
+	}
 }
 
 func handleGitHubResponse(resp *http.Response, target any) error {
@@ -49,35 +193,87 @@ func handleGitHubResponse(resp *http.Response, target any) error {
 		}
 		return nil
 	} else {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("GitHub API error %d: %s", resp.StatusCode, string(body))
-		return fmt.Errorf("GitHub API error")
+		return newAPIError("GitHub", resp)
 	}
 }
 
+// validateListingParams checks GITHUB_AFFILIATION, GITHUB_VISIBILITY and GITHUB_TYPE
+// against the values /user/repos accepts.
+// Docs: https://docs.github.com/en/rest/repos/repos#list-repositories-for-the-authenticated-user
+func validateListingParams() error {
+	if len(config.GitHubOrgs) > 0 {
+		// /orgs/{org}/repos knows neither affiliation nor visibility
+		if config.GitHubVisibility != "" {
+			return fmt.Errorf("visibility can't be combined with an organization, use type public or private")
+		}
+		switch config.GitHubType {
+		case "", "all", "public", "private", "forks", "sources", "member":
+		default:
+			return fmt.Errorf("invalid type %q for an organization (expected all, public, private, forks, sources or member)", config.GitHubType)
+		}
+		return nil
+	}
+	for _, a := range splitList(config.GitHubAffiliation) {
+		if a != "owner" && a != "collaborator" && a != "organization_member" {
+			return fmt.Errorf("invalid affiliation %q (expected owner, collaborator or organization_member)", a)
+		}
+	}
+	switch config.GitHubVisibility {
+	case "", "all", "public", "private":
+	default:
+		return fmt.Errorf("invalid visibility %q (expected all, public or private)", config.GitHubVisibility)
+	}
+	switch config.GitHubType {
+	case "":
+	case "all", "owner", "public", "private", "member":
+		// GitHub rejects type together with affiliation or visibility with 422
+		if config.GitHubVisibility != "" {
+			return fmt.Errorf("type can't be combined with visibility")
+		}
+	default:
+		return fmt.Errorf("invalid type %q (expected all, owner, public, private or member)", config.GitHubType)
+	}
+	return nil
+}
+
+// getGitHubRepos lists the repos of the authenticated user, or of the GITHUB_ORG
+// organizations instead when set.
 // https://docs.github.com/en/rest/repos/repos#list-repositories-for-the-authenticated-user
+// https://docs.github.com/en/rest/repos/repos#list-organization-repositories
 func getGitHubRepos() ([]GitHubRepo, error) {
 	var repos []GitHubRepo
-	page := 1
-	for {
-		resp, err := doGitHubRequest("GET", "/user/repos", map[string]string{
-			"per_page":    strconv.Itoa(config.PerPage),
-			"page":        strconv.Itoa(page),
-			"affiliation": "owner,member",
-		}, nil)
-		if err != nil {
+	if len(config.GitHubOrgs) > 0 {
+		params := map[string]string{"per_page": strconv.Itoa(config.PerPage), "type": config.GitHubType}
+		if params["type"] == "" {
+			params["type"] = "all"
+		}
+		for _, org := range config.GitHubOrgs {
+			batch, err := listGitHubPages[GitHubRepo]("/orgs/"+url.PathEscape(org)+"/repos", params)
+			if err != nil {
+				return nil, fmt.Errorf("organization %s: %w", org, err)
+			}
+			log.Printf("Found %d repos of GitHub organization %s", len(batch), org)
+			repos = append(repos, batch...)
+		}
+	} else if githubApp() {
+		var err error
+		if repos, err = listInstallationRepos(); err != nil {
 			return nil, err
 		}
-		var batch []GitHubRepo
-		if err := handleGitHubResponse(resp, &batch); err != nil {
-			break
+	} else {
+		params := map[string]string{"per_page": strconv.Itoa(config.PerPage)}
+		if config.GitHubType != "" {
+			params["type"] = config.GitHubType
+		} else {
+			params["affiliation"] = strings.Join(splitList(config.GitHubAffiliation), ",")
+			if config.GitHubVisibility != "" {
+				params["visibility"] = config.GitHubVisibility
+			}
 		}
-		if len(batch) == 0 {
-			break
+		var err error
+		if repos, err = listGitHubPages[GitHubRepo]("/user/repos", params); err != nil {
+			return nil, err
 		}
-		repos = append(repos, batch...)
-		page++
-		time.Sleep(config.SleepBetweenAPI)
 	}
 	log.Printf("Found %d GitHub repos", len(repos))
 	// List each repo with its private flag
@@ -88,17 +284,361 @@ func getGitHubRepos() ([]GitHubRepo, error) {
 	return repos, nil
 }
 
-func mirrorReposFromGitHub(repoName, githubURL, localPath string) error {
-	authCloneURL := strings.Replace(githubURL, "https://", fmt.Sprintf("https://%s:%s@", config.GitHubUser, config.GitHubToken), 1)
+// getGitHubGists lists the gists of the authenticated user as repos; secret ones need the gist scope.
+// https://docs.github.com/en/rest/gists/gists#list-gists-for-the-authenticated-user
+func getGitHubGists() ([]GitHubRepo, error) {
+	gists, err := listGitHubPages[GitHubGist]("/gists", map[string]string{"per_page": strconv.Itoa(config.PerPage)})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Found %d GitHub gists", len(gists))
+	return Map(gists, gistAsRepo), nil
+}
+
+// gistAsRepo describes a gist as a repo named gist-<id>, since filenames aren't unique
+// and can change; secret gists become private.
+func gistAsRepo(g GitHubGist) GitHubRepo {
+	description := g.Description
+	if description == "" {
+		// the first filename, in a stable order
+		names := make([]string, 0, len(g.Files))
+		for name := range g.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) > 0 {
+			description = names[0]
+		}
+	}
+	return GitHubRepo{
+		Name:        "gist-" + g.ID,
+		FullName:    g.Owner.Login + "/gist-" + g.ID,
+		Owner:       g.Owner,
+		CloneURL:    g.GitPullURL,
+		Private:     !g.Public,
+		Description: description,
+		// gists have no topics, and any push updates them
+		Topics:   []string{},
+		PushedAt: g.UpdatedAt,
+		Gist:     true,
+	}
+}
+
+// githubListingWorkers bounds the listing pages fetched at once.
+const githubListingWorkers = 4
+
+// listGitHubPages fetches all pages of a listing endpoint. When the first page links to
+// the last one, the rest is fetched concurrently, otherwise page by page until there is
+// no next link.
+func listGitHubPages[T any](path string, params map[string]string) ([]T, error) {
+	items, header, err := fetchGitHubPage[T](path, params, 1)
+	if err != nil || parseNextLink(header) == "" {
+		return items, err
+	}
+	page := 2
+	if last, ok := parseLinkHeader(header.Get("Link"))["last"]; ok {
+		if lastPage, err := pageOf(last); err == nil && lastPage > 1 {
+			pages := make([][]T, lastPage+1)
+			headers := make([]http.Header, lastPage+1)
+			errs := make([]error, lastPage+1)
+			var wg sync.WaitGroup
+			sem := make(chan struct{}, githubListingWorkers)
+			for p := 2; p <= lastPage; p++ {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(p int) {
+					defer wg.Done()
+					defer func() { <-sem }()
+					pages[p], headers[p], errs[p] = fetchGitHubPage[T](path, params, p)
+				}(p)
+			}
+			wg.Wait()
+			for p := 2; p <= lastPage; p++ {
+				if errs[p] != nil {
+					return nil, errs[p]
+				}
+				items = append(items, pages[p]...)
+			}
+			// repos created while listing can add pages beyond the last one
+			if parseNextLink(headers[lastPage]) == "" {
+				return items, nil
+			}
+			page = lastPage + 1
+		}
+	}
+	for ; ; page++ {
+		batch, header, err := fetchGitHubPage[T](path, params, page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, batch...)
+		if parseNextLink(header) == "" {
+			return items, nil
+		}
+		// without rate limit headers fall back to a fixed pause between pages
+		if _, ok := rateLimitFromHeaders(header); !ok {
+			time.Sleep(config.SleepBetweenAPI)
+		}
+	}
+}
+
+// fetchGitHubPage fetches one page of a listing endpoint, waiting for the rate limit
+// reset afterwards when the quota is running low.
+func fetchGitHubPage[T any](path string, params map[string]string, page int) ([]T, http.Header, error) {
+	pageParams := make(map[string]string, len(params)+1)
+	for k, v := range params {
+		pageParams[k] = v
+	}
+	pageParams["page"] = strconv.Itoa(page)
+	resp, err := doGitHubRequest("GET", path, pageParams, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var batch []T
+	// A partial listing must not look like a complete one, otherwise
+	// the repos of the missing pages would be treated as deleted.
+	if err := handleGitHubResponse(resp, &batch); err != nil {
+		return nil, nil, fmt.Errorf("listing page %d: %w", page, err)
+	}
+	if rl, ok := rateLimitFromHeaders(resp.Header); ok && rl.Remaining < githubRateLimitLow {
+		if err := waitForRateLimitReset(rl); err != nil {
+			return nil, nil, err
+		}
+	}
+	return batch, resp.Header, nil
+}
+
+// parseLinkHeader maps the rel of each link in an RFC 8288 Link header to its URL, e.g.
+// <https://api.github.com/user/repos?page=5>; rel="last" -> {"last": "https://api.github.com/user/repos?page=5"}
+// Docs: https://docs.github.com/en/rest/using-the-rest-api/using-pagination-in-the-rest-api
+func parseLinkHeader(header string) map[string]string {
+	links := map[string]string{}
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range parts[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.TrimSpace(key) != "rel" {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(value), `"`)) {
+				links[rel] = target[1 : len(target)-1]
+			}
+		}
+	}
+	return links
+}
+
+// parseNextLink returns the rel="next" URL of a response, or "" on the last page.
+func parseNextLink(h http.Header) string {
+	return parseLinkHeader(h.Get("Link"))["next"]
+}
+
+// pageOf returns the page query parameter of a pagination link.
+func pageOf(link string) (int, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(u.Query().Get("page"))
+}
+
+// https://docs.github.com/en/rest/repos/repos#get-a-repository
+// https://docs.github.com/en/rest/repos/repos#check-if-vulnerability-alerts-are-enabled-for-a-repository
+func getGitHubSecuritySettings(fullName string) (*GitHubSecuritySettings, error) {
+	resp, err := doGitHubRequest("GET", "/repos/"+fullName, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var settings GitHubSecuritySettings
+	if err := handleGitHubResponse(resp, &settings); err != nil {
+		return nil, err
+	}
+
+	resp, err = doGitHubRequest("GET", "/repos/"+fullName+"/vulnerability-alerts", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	// 204 if enabled, 404 if disabled (or not visible to the token)
+	settings.VulnerabilityAlerts = resp.StatusCode == http.StatusNoContent
+	return &settings, nil
+}
+
+// Get all repository topics
+// Docs: https://docs.github.com/en/rest/repos/repos#get-all-repository-topics
+func getGitHubRepoTopics(fullName string) ([]string, error) {
+	resp, err := doGitHubRequest("GET", "/repos/"+fullName+"/topics", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Names []string `json:"names"`
+	}
+	if err := handleGitHubResponse(resp, &result); err != nil {
+		return nil, err
+	}
+	return result.Names, nil
+}
+
+// errSAMLSSO is returned when git is refused access to a repository because its
+// organization enforces SAML SSO and the token has not been authorized for it.
+var errSAMLSSO = errors.New("token is not authorized for SAML SSO")
+
+// errRecloneFailed is returned when the fresh clone after a failed fetch fails as well,
+// which QUARANTINE_AFTER counts.
+var errRecloneFailed = errors.New("reclone after a failed fetch failed")
+
+// errCloneFailed is returned when cloning a repo without a mirror fails. After a failed
+// reclone the mirror is gone, so the next runs' attempts end up here.
+var errCloneFailed = errors.New("clone failed")
+
+// isSAMLSSOError reports whether git's stderr contains GitHub's SAML SSO rejection,
+// e.g. "The 'acme' organization has enabled or enforced SAML SSO. To access this repository, ..."
+func isSAMLSSOError(stderr string) bool {
+	return strings.Contains(stderr, "SAML SSO")
+}
+
+// isNetworkError reports whether git's stderr points at a connection problem rather
+// than at the repository, e.g. "Could not resolve host: github.com".
+func isNetworkError(stderr string) bool {
+	for _, s := range []string{
+		"Could not resolve host", "Failed to connect", "Connection refused", "Connection timed out",
+		"Connection reset", "Operation timed out", "Network is unreachable",
+		"The remote end hung up unexpectedly", "RPC failed", "early EOF",
+		"The requested URL returned error: 5",
+		"gnutls_handshake() failed", "SSL_ERROR_SYSCALL",
+	} {
+		if strings.Contains(stderr, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripMirrorCredentials points the origin of the mirror at localPath back to cleanURL,
+// so the token used for cloning isn't kept in the mirror's git config.
+func stripMirrorCredentials(localPath, cleanURL string) {
+	if _, err := os.Stat(localPath); err != nil {
+		return
+	}
+	if err := runCmd("git", "--git-dir", localPath, "remote", "set-url", "origin", cleanURL); err != nil {
+		log.Printf("⚠️ Failed to strip credentials from %s: %v", localPath, err)
+	}
+}
+
+func cloneMirrorFromGitHub(githubURL, authCloneURL, localPath string) error {
+	// git stores the clone URL (token included) in the mirror's config,
+	// scrub it afterwards even if the clone gets interrupted
+	done := registerCleanup(func() { stripMirrorCredentials(localPath, githubURL) })
+	defer done()
+	args := []string{"clone", "--mirror", authCloneURL, localPath}
+	// wikis are small, and a partial mirror isn't worth it for them
+	if config.PartialClone && !strings.HasSuffix(localPath, ".wiki.git") {
+		args = []string{"clone", "--mirror", "--filter=" + partialCloneFilter, authCloneURL, localPath}
+	}
+	stderr, err := runCmdCapture("git", args...)
+	if err != nil && isSAMLSSOError(stderr) {
+		return errSAMLSSO
+	}
+	return err
+}
+
+// checkDiskSpace fails if the volume of the backup dir can't hold a clone of sizeKB.
+func checkDiskSpace(repoName string, sizeKB int) error {
+	free, err := freeDiskSpace(config.BackupDir)
+	if err != nil {
+		log.Printf("⚠️ Failed to check free disk space: %v", err)
+		return nil
+	}
+	// GitHub's size is an estimate, leave some room for the pack files being written
+	needed := uint64(sizeKB) * 1024 * 5 / 4
+	if free < needed {
+		return fmt.Errorf("not enough disk space to clone %s: needs about %s, %s free in %s",
+			repoName, formatBytes(int64(needed)), formatBytes(int64(free)), config.BackupDir)
+	}
+	return nil
+}
+
+func mirrorReposFromGitHub(repoName, githubURL, localPath string, sizeKB int) error {
+	user, token, err := githubCredentials()
+	if err != nil {
+		return err
+	}
+	authCloneURL := strings.Replace(githubURL, "https://", fmt.Sprintf("https://%s:%s@", user, token), 1)
+	return mirrorRepo(repoName, githubURL, authCloneURL, localPath, sizeKB)
+}
+
+// mirrorRepo clones cloneURL as a mirror into localPath, or fetches into the existing mirror,
+// using authCloneURL without ever storing it. Used for GitHub and, in reverse, for the targets.
+func mirrorRepo(repoName, cloneURL, authCloneURL, localPath string, sizeKB int) (err error) {
+	defer func() {
+		if err == nil && !config.DryRun {
+			rememberPartialClone(localPath, cloneURL, authCloneURL)
+		}
+	}()
+	if config.DryRun {
+		if _, err := os.Stat(localPath); os.IsNotExist(err) {
+			log.Printf("[dry-run] Would clone (mirror) %s into %s", repoName, localPath)
+		} else {
+			log.Printf("[dry-run] Would fetch %s into %s", repoName, localPath)
+		}
+		return nil
+	}
 	if _, err := os.Stat(localPath); os.IsNotExist(err) {
-		log.Printf("Cloning (mirror) %s ...", repoName)
-		return runCmd("git", "clone", "--mirror", authCloneURL, localPath)
+		if err := checkDiskSpace(repoName, sizeKB); err != nil {
+			return err
+		}
+		if config.PartialClone && !strings.HasSuffix(localPath, ".wiki.git") {
+			log.Printf("Cloning (partial mirror, without blobs) %s ...", repoName)
+		} else {
+			log.Printf("Cloning (mirror) %s ...", repoName)
+		}
+		if err := cloneMirrorFromGitHub(cloneURL, authCloneURL, localPath); err != nil {
+			return fmt.Errorf("%w: %w", errCloneFailed, err)
+		}
+		return nil
 	} else {
-		err := runCmd("git", "--git-dir", localPath, "fetch", "--all", "--prune")
+		// Mirrors cloned by older versions still have the token in their config
+		stripMirrorCredentials(localPath, cloneURL)
+		// Fetch from the authenticated URL directly instead of storing it as the origin
+		stderr, err := runCmdCapture("git", "--git-dir", localPath, "fetch", "--prune", authCloneURL, "+refs/*:refs/*")
+		for attempt := 0; err != nil && isNetworkError(stderr) && attempt < config.MaxRetries; attempt++ {
+			wait := backoff(attempt)
+			log.Printf("⚠️ Fetching %s failed with a network error, retrying in %v", repoName, wait.Round(time.Second))
+			timer := time.NewTimer(wait)
+			select {
+			case <-stopCtx.Done():
+				timer.Stop()
+				return fmt.Errorf("fetch failed, keeping the existing mirror: %w", errCmdInterrupted)
+			case <-timer.C:
+			}
+			stderr, err = runCmdCapture("git", "--git-dir", localPath, "fetch", "--prune", authCloneURL, "+refs/*:refs/*")
+		}
 		if err != nil {
+			// Recloning can't help in these cases, and would throw away the existing backup
+			if isSAMLSSOError(stderr) {
+				return errSAMLSSO
+			}
+			if isNetworkError(stderr) || errors.Is(err, errCmdTimeout) || errors.Is(err, errCmdInterrupted) {
+				return fmt.Errorf("fetch failed, keeping the existing mirror: %w", err)
+			}
+			// Only a broken mirror is worth a full reclone
+			if fsckErr := runCmd("git", "--git-dir", localPath, "fsck", "--connectivity-only", "--no-progress"); fsckErr == nil {
+				return fmt.Errorf("fetch failed, but the existing mirror is intact: %w", err)
+			}
 			log.Printf("Recloning %s due to fetch failure", repoName)
 			os.RemoveAll(localPath)
-			return runCmd("git", "clone", "--mirror", authCloneURL, localPath)
+			if err := checkDiskSpace(repoName, sizeKB); err != nil {
+				return err
+			}
+			if err := cloneMirrorFromGitHub(cloneURL, authCloneURL, localPath); err != nil {
+				return fmt.Errorf("%w: %w", errRecloneFailed, err)
+			}
+			return nil
 		}
 		return nil
 	}
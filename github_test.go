@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseNextLink(t *testing.T) {
+	const next = "https://api.github.com/user/repos?page=2"
+	for _, tt := range []struct {
+		name string
+		link string
+		want string
+	}{
+		{"next then last", `<` + next + `>; rel="next", <https://api.github.com/user/repos?page=5>; rel="last"`, next},
+		{"last then next", `<https://api.github.com/user/repos?page=5>; rel="last", <` + next + `>; rel="next"`, next},
+		{"last page", `<https://api.github.com/user/repos?page=1>; rel="first", <https://api.github.com/user/repos?page=4>; rel="prev"`, ""},
+		{"no header", "", ""},
+		{"unquoted rel", `<` + next + `>; rel=next`, next},
+		{"several rels", `<` + next + `>; rel="next last"`, next},
+		{"spaces around the params", `<` + next + `> ;  rel = "next" `, next},
+		{"malformed entry skipped", `https://api.github.com/user/repos?page=9; rel="next", <` + next + `>; rel="next"`, next},
+		{"malformed only", `https://api.github.com/user/repos?page=9; rel="next"`, ""},
+		{"no rel", `<` + next + `>; title="next"`, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.link != "" {
+				h.Set("Link", tt.link)
+			}
+			if got := parseNextLink(h); got != tt.want {
+				t.Errorf("parseNextLink(%q) = %q, want %q", tt.link, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitForRateLimitResetStops(t *testing.T) {
+	oldStop, oldDeadline := stopCtx, runDeadline
+	t.Cleanup(func() { stopCtx, runDeadline = oldStop, oldDeadline })
+	stopped, stop := context.WithCancel(context.Background())
+	stop()
+	expired, expire := context.WithCancel(context.Background())
+	expire()
+
+	rl := rateLimit{Remaining: 0, Reset: time.Now().Add(time.Hour)}
+	for _, tt := range []struct {
+		name     string
+		stop     context.Context
+		deadline context.Context
+	}{
+		{"interrupt", stopped, context.Background()},
+		{"run deadline", context.Background(), expired},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stopCtx, runDeadline = tt.stop, tt.deadline
+			done := make(chan error, 1)
+			go func() { done <- waitForRateLimitReset(rl) }()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Error("want an error for the cut short wait")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("still waiting for the rate limit reset")
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Authentication as a GitHub App installation, with GITHUB_APP_ID, GITHUB_APP_PRIVATE_KEY
+// and GITHUB_INSTALLATION_ID instead of GITHUB_USER/GITHUB_TOKEN.
+// https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/authenticating-as-a-github-app-installation
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// installationTokenRefresh is how long before its expiry an installation token is replaced;
+// tokens are valid for an hour, and a clone must not start with one about to expire.
+const installationTokenRefresh = 5 * time.Minute
+
+var installationToken struct {
+	sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// githubApp reports whether GitHub is accessed as an app installation.
+func githubApp() bool {
+	return config.GitHubAppID != ""
+}
+
+// parseGitHubAppKey reads the app's private key, given either as PEM or as the path of the
+// .pem file GitHub offers for download (PKCS#1), PKCS#8 keys work as well.
+func parseGitHubAppKey(value string) (*rsa.PrivateKey, error) {
+	data := []byte(value)
+	if !strings.Contains(value, "-----BEGIN") {
+		var err error
+		if data, err = os.ReadFile(value); err != nil {
+			return nil, err
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return key, nil
+}
+
+// githubAppJWT signs the short lived RS256 JWT that authenticates as the app itself.
+// iat is backdated against clock drift, GitHub accepts at most 10 minutes of validity.
+// Docs: https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/generating-a-json-web-token-jwt-for-a-github-app
+func githubAppJWT(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": config.GitHubAppID,
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, config.GitHubAppKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Create an installation access token for an app
+// Docs: https://docs.github.com/en/rest/apps/apps#create-an-installation-access-token-for-an-app
+func createInstallationToken() (string, time.Time, error) {
+	jwt, err := githubAppJWT(time.Now())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	u, err := url.Parse(config.GitHubAPIURL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + fmt.Sprintf("/app/installations/%s/access_tokens", url.PathEscape(config.GitHubInstallationID))
+	req, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	setGitHubAPIHeaders(req)
+	resp, err := doWithRetry(ghClient, req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var token struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := handleGitHubResponse(resp, &token); err != nil {
+		return "", time.Time{}, fmt.Errorf("creating the installation token: %w", err)
+	}
+	return token.Token, token.ExpiresAt, nil
+}
+
+// githubCredentials returns the user and token for the API and git: GITHUB_USER/GITHUB_TOKEN,
+// or the installation token, minted again when it is about to expire.
+func githubCredentials() (string, string, error) {
+	if !githubApp() {
+		return config.GitHubUser, config.GitHubToken, nil
+	}
+	installationToken.Lock()
+	defer installationToken.Unlock()
+	if time.Until(installationToken.expiresAt) < installationTokenRefresh {
+		token, expiresAt, err := createInstallationToken()
+		if err != nil {
+			return "", "", err
+		}
+		// ghs_ tokens are masked in the logs by tokenPattern
+		installationToken.token, installationToken.expiresAt = token, expiresAt
+		log.Printf("🔑 Created a GitHub App installation token, valid until %s", expiresAt.Local().Format("15:04:05"))
+	}
+	// git accepts installation tokens with any user name, GitHub documents x-access-token
+	return "x-access-token", installationToken.token, nil
+}
+
+// List repositories accessible to the app installation
+// Docs: https://docs.github.com/en/rest/apps/installations#list-repositories-accessible-to-the-app-installation
+func listInstallationRepos() ([]GitHubRepo, error) {
+	var repos []GitHubRepo
+	params := map[string]string{"per_page": strconv.Itoa(config.PerPage)}
+	for page := 1; ; page++ {
+		params["page"] = strconv.Itoa(page)
+		resp, err := doGitHubRequest("GET", "/installation/repositories", params, nil)
+		if err != nil {
+			return nil, err
+		}
+		var batch struct {
+			Repositories []GitHubRepo `json:"repositories"`
+		}
+		if err := handleGitHubResponse(resp, &batch); err != nil {
+			return nil, fmt.Errorf("listing page %d: %w", page, err)
+		}
+		repos = append(repos, batch.Repositories...)
+		if parseNextLink(resp.Header) == "" {
+			return repos, nil
+		}
+		if rl, ok := rateLimitFromHeaders(resp.Header); ok && rl.Remaining < githubRateLimitLow {
+			if err := waitForRateLimitReset(rl); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
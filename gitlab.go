@@ -15,14 +15,45 @@ import (
 )
 
 type GitLabProject struct {
+	ID            int      `json:"id"`
+	Path          string   `json:"path"`
+	Archived      bool     `json:"archived"`
+	Visibility    string   `json:"visibility"`
+	Topics        []string `json:"topics"`
+	DefaultBranch string   `json:"default_branch"`
+	// Description is empty when not set (null)
+	Description string `json:"description"`
+}
+
+type gitLabGroup struct {
 	ID         int    `json:"id"`
+	FullPath   string `json:"full_path"`
 	Visibility string `json:"visibility"`
 }
 
-// doGitLabRequest issues a request against the GitLab v4 API (https://gitlab.com/api/v4).
+// gitLabVisibilityRank orders the visibility levels from the least visible.
+var gitLabVisibilityRank = map[string]int{"private": 0, "internal": 1, "public": 2}
+
+// gitLabVisibility maps the visibility resolved for a mirror to the project's on GitLab:
+// public repos get GITLAB_PUBLIC_AS, and a project can't be more visible than GITLAB_GROUP,
+// GitLab would reject it (and we would try again on every run).
+func gitLabVisibility(visibility string) string {
+	if visibility == "public" {
+		visibility = config.GitLabPublicAs
+	}
+	if g := gitLabNamespaceGroup; g != nil && g.Visibility != "" && gitLabVisibilityRank[visibility] > gitLabVisibilityRank[g.Visibility] {
+		return g.Visibility
+	}
+	return visibility
+}
+
+// gitLabNamespaceGroup is GITLAB_GROUP as looked up by loadGitLabNamespace, nil without one.
+var gitLabNamespaceGroup *gitLabGroup
+
+// doGitLabRequest issues a request against the GitLab v4 API (<GITLAB_URL>/api/v4).
 func doGitLabRequest(method, path string, queryParams map[string]string, body io.Reader) (*http.Response, error) {
 	// Build URL manually to handle pre-encoded paths properly
-	baseURL := "https://gitlab.com" + path
+	baseURL := config.GitLabURL + path
 	if len(queryParams) > 0 {
 		u, err := url.Parse(baseURL)
 		if err != nil {
@@ -40,11 +71,23 @@ func doGitLabRequest(method, path string, queryParams map[string]string, body io
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("PRIVATE-TOKEN", config.GitLabToken)
+	setGitLabAuth(req)
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
-	return glClient.Do(req)
+	return doWithRetry(glClient, req)
+}
+
+// setGitLabAuth authenticates req with the personal access token or the CI job token.
+func setGitLabAuth(req *http.Request) {
+	switch {
+	case config.GitLabAuthMode == "job-token":
+		req.Header.Set("JOB-TOKEN", config.GitLabToken)
+	case config.GitLabAuthScheme == "bearer":
+		req.Header.Set("Authorization", "Bearer "+config.GitLabToken)
+	default:
+		req.Header.Set("PRIVATE-TOKEN", config.GitLabToken)
+	}
 }
 
 // handleGitLabResponse decodes 2xx JSON responses; logs and errors otherwise.
@@ -56,27 +99,32 @@ func handleGitLabResponse(resp *http.Response, target any) (any, error) {
 		}
 		return target, nil
 	} else {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("GitLab API error %d: %s", resp.StatusCode, string(body))
-		return nil, fmt.Errorf("API error")
+		return nil, newAPIError("GitLab", resp)
 	}
 }
 
+// resolveGitLabNamespace returns the namespace projects are looked up, created, pushed and
+// listed in: the ID and full path of GITLAB_GROUP (possibly nested, e.g. acme/backend/team-a),
+// or no ID and the path of the user. GITLAB_GROUP must have been loaded by loadGitLabNamespace.
+func resolveGitLabNamespace() (id *int, path string) {
+	if g := gitLabNamespaceGroup; g != nil {
+		return &g.ID, g.FullPath
+	}
+	return nil, config.GitLabUser
+}
+
+// gitLabPathID encodes a full group or project path for use as the :id of an endpoint.
+// GitLab requires the slashes to be encoded too, which url.PathEscape leaves alone.
+func gitLabPathID(fullPath string) string {
+	return strings.ReplaceAll(url.PathEscape(fullPath), "/", "%2F")
+}
+
 // Get single project
 // Docs: https://docs.gitlab.com/ee/api/projects.html#get-single-project
-func getGitLabProject(repoName, userName string, groupID *int) (*GitLabProject, error) {
-	var projPath string
-	if groupID != nil {
-		if config.GitLabGroup != "" {
-			projPath = fmt.Sprintf("%s/%s", config.GitLabGroup, repoName)
-		} else {
-			projPath = fmt.Sprintf("%s/%s", userName, repoName)
-		}
-	} else {
-		projPath = fmt.Sprintf("%s/%s", userName, repoName)
-	}
-	// URL-encode the project path for the API endpoint - use PathEscape for URL paths
-	resp, err := doGitLabRequest("GET", "/api/v4/projects/"+url.PathEscape(projPath), nil, nil)
+func getGitLabProject(repoName string) (*GitLabProject, error) {
+	_, namespace := resolveGitLabNamespace()
+	projPath := namespace + "/" + repoName
+	resp, err := doGitLabRequest("GET", "/api/v4/projects/"+gitLabPathID(projPath), nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -94,33 +142,42 @@ func getGitLabProject(repoName, userName string, groupID *int) (*GitLabProject,
 	return result.(*GitLabProject), nil
 }
 
-// Details of a group
+// loadGitLabNamespace looks up GITLAB_GROUP, given as a full path or a numeric ID,
+// for resolveGitLabNamespace. Without GITLAB_GROUP the projects go to the user.
 // Docs: https://docs.gitlab.com/ee/api/groups.html#details-of-a-group
-func getGitLabGroupID() (*int, error) {
+func loadGitLabNamespace() error {
+	gitLabNamespaceGroup = nil
 	if config.GitLabGroup == "" {
-		return nil, nil
+		return nil
 	}
-	encoded := url.PathEscape(config.GitLabGroup)
-	resp, err := doGitLabRequest("GET", fmt.Sprintf("/api/v4/groups/%s", encoded), nil, nil)
+	// a subgroup is looked up by its full path, its ID then works as namespace_id like any group's
+	resp, err := doGitLabRequest("GET", "/api/v4/groups/"+gitLabPathID(config.GitLabGroup), nil, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	var group struct {
-		ID int `json:"id"`
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return fmt.Errorf("GitLab group %s not found", config.GitLabGroup)
 	}
-	result, err := handleGitLabResponse(resp, &group)
-	if err != nil {
-		return nil, err
+	var group gitLabGroup
+	if _, err := handleGitLabResponse(resp, &group); err != nil {
+		return err
 	}
-	if result == nil {
-		return nil, fmt.Errorf("GitLab group not found")
+	// the full path is also what GITLAB_GROUP=<id> pushes to
+	if group.FullPath == "" {
+		group.FullPath = config.GitLabGroup
 	}
-	return &result.(*struct{ ID int }).ID, nil
+	gitLabNamespaceGroup = &group
+	return nil
 }
 
 // Edit project (update visibility)
 // Docs: https://docs.gitlab.com/ee/api/projects.html#edit-project
 func updateGitLabProjectVisibility(projectID int, visibility string) error {
+	if config.DryRun {
+		log.Printf("[dry-run] Would update GitLab project %d visibility -> %s", projectID, visibility)
+		return nil
+	}
 	payload := map[string]any{
 		"visibility": visibility,
 	}
@@ -135,6 +192,7 @@ func updateGitLabProjectVisibility(projectID int, visibility string) error {
 	defer resp.Body.Close()
 	if resp.StatusCode == 200 {
 		log.Printf("Updated GitLab project %d visibility -> %s", projectID, visibility)
+		markAction("updated")
 		return nil
 	} else {
 		body, _ := io.ReadAll(resp.Body)
@@ -145,14 +203,22 @@ func updateGitLabProjectVisibility(projectID int, visibility string) error {
 
 // Create project (optionally under a group via namespace_id)
 // Docs: https://docs.gitlab.com/ee/api/projects.html#create-project
-func createGitLabProject(groupID *int, repoName, visibility string) (*GitLabProject, error) {
+func createGitLabProject(repoName, visibility, description string, topics []string) (*GitLabProject, error) {
+	if config.DryRun {
+		log.Printf("[dry-run] Would create GitLab project %s (visibility %s)", repoName, visibility)
+		return &GitLabProject{Visibility: visibility, Topics: topics}, nil
+	}
 	payload := map[string]any{
 		"name":                   repoName,
 		"path":                   repoName,
 		"visibility":             visibility,
 		"initialize_with_readme": false,
+		"description":            description,
 	}
-	if groupID != nil {
+	if len(topics) > 0 {
+		payload["topics"] = topics
+	}
+	if groupID, _ := resolveGitLabNamespace(); groupID != nil {
 		payload["namespace_id"] = *groupID
 	}
 	jsonData, err := json.Marshal(payload)
@@ -170,39 +236,194 @@ func createGitLabProject(groupID *int, repoName, visibility string) (*GitLabProj
 	}
 	if result != nil {
 		log.Printf("Created GitLab project %s", repoName)
+		markAction("created")
 		return result.(*GitLabProject), nil
 	}
 	return nil, fmt.Errorf("unexpected response")
 }
 
-func checkAndValidateGitLabRepos(groupID *int, repoName, userName, repoVisibility string) error {
-	proj, err := getGitLabProject(repoName, userName, groupID)
+// Edit project
+// Docs: https://docs.gitlab.com/ee/api/projects.html#edit-project
+func editGitLabProject(projectID int, payload map[string]any) (*GitLabProject, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if config.DryRun {
+		log.Printf("[dry-run] Would edit GitLab project %d: %s", projectID, jsonData)
+		return &GitLabProject{ID: projectID}, nil
+	}
+	resp, err := doGitLabRequest("PUT", fmt.Sprintf("/api/v4/projects/%d", projectID), nil, strings.NewReader(string(jsonData)))
+	if err != nil {
+		return nil, err
+	}
+	var proj GitLabProject
+	if _, err := handleGitLabResponse(resp, &proj); err != nil {
+		return nil, err
+	}
+	markAction("updated")
+	return &proj, nil
+}
+
+func updateGitLabProjectTopics(projectID int, topics []string) error {
+	if _, err := editGitLabProject(projectID, map[string]any{"topics": topics}); err != nil {
+		return err
+	}
+	if config.DryRun {
+		// editGitLabProject logged what it would do
+		return nil
+	}
+	log.Printf("Updated GitLab project %d topics -> %s", projectID, strings.Join(topics, ", "))
+	return nil
+}
+
+// fixGitLabDefaultBranch sets the project's default branch to branch if it differs,
+// and returns the previous default branch when it was changed.
+func fixGitLabDefaultBranch(repoName, branch string) (string, error) {
+	proj, err := getGitLabProject(repoName)
 	if err != nil {
+		return "", err
+	}
+	if proj == nil || proj.DefaultBranch == branch {
+		return "", nil
+	}
+	if _, err := editGitLabProject(proj.ID, map[string]any{"default_branch": branch}); err != nil {
+		return "", err
+	}
+	if config.DryRun {
+		return "", nil
+	}
+	log.Printf("Updated GitLab project %s default branch %q -> %q", repoName, proj.DefaultBranch, branch)
+	return proj.DefaultBranch, nil
+}
+
+func checkAndValidateGitLabRepos(repoName, repoVisibility, description string, topics []string) error {
+	proj, err := getGitLabProject(repoName)
+	if err != nil {
+		// CI job tokens can only access a few API endpoints, so the project may
+		// well exist even though we can't see it; let the push decide.
+		if config.GitLabAuthMode == "job-token" {
+			log.Printf("⚠️ Can't look up GitLab project %s with the CI job token (%v), assuming it exists", repoName, err)
+			return nil
+		}
 		return err
 	}
+	if proj == nil && config.GitLabAuthMode == "job-token" {
+		log.Printf("⚠️ GitLab project %s not found, but CI job tokens can't create projects. Create it manually and allow this project's job token to push to it.", repoName)
+		return fmt.Errorf("project not found")
+	}
 	if proj == nil {
 		log.Printf("Project %s not found on GitLab. Creating...", repoName)
-		_, err = createGitLabProject(groupID, repoName, repoVisibility)
+		_, err = createGitLabProject(repoName, repoVisibility, description, topics)
 		return err
 	} else {
 		if proj.Visibility != repoVisibility {
 			log.Printf("Project %s exists on GitLab with visibility '%s' but desired is '%s'. Updating...", repoName, proj.Visibility, repoVisibility)
-			return updateGitLabProjectVisibility(proj.ID, repoVisibility)
+			if confirmChange("gitlab", repoName, fmt.Sprintf("change the visibility %s -> %s", proj.Visibility, repoVisibility)) {
+				if err := updateGitLabProjectVisibility(proj.ID, repoVisibility); err != nil {
+					return err
+				}
+			}
 		} else {
 			log.Printf("Project %s exists on GitLab with matching visibility '%s'.", repoName, proj.Visibility)
 		}
+		if proj.Description != description {
+			if _, err := editGitLabProject(proj.ID, map[string]any{"description": description}); err != nil {
+				return err
+			}
+			if !config.DryRun {
+				log.Printf("Updated GitLab project %s description -> %q", repoName, description)
+			}
+		}
+		// Topics are additive metadata: never drop topics set on the destination,
+		// and don't fail the sync if they can't be applied.
+		if merged, changed := mergeTopics(proj.Topics, topics); changed {
+			if err := updateGitLabProjectTopics(proj.ID, merged); err != nil {
+				log.Printf("⚠️ Failed to update topics of GitLab project %s: %v", repoName, err)
+			}
+		}
 	}
 	return nil
 }
 
 // https://forum.gitlab.com/t/how-to-git-clone-via-https-with-personal-access-token-in-private-project/43418
-func syncRepos(groupID *int, userName, gitlabToken, repoName, localPath string) error {
-	targetNamespace := config.GitLabGroup
-	if groupID == nil {
-		targetNamespace = userName
+// gitLabRepoURL returns the (unauthenticated) HTTPS git URL of the project.
+func gitLabRepoURL(repoName string) string {
+	_, namespace := resolveGitLabNamespace()
+	return fmt.Sprintf("%s/%s/%s.git", config.GitLabURL, namespace, repoName)
+}
+
+func syncRepos(gitlabToken, repoName, localPath string) error {
+	_, targetNamespace := resolveGitLabNamespace()
+	glRepoURL := gitLabRepoURL(repoName)
+	user := "oauth2"
+	if config.GitLabAuthMode == "job-token" {
+		user = "gitlab-ci-token"
+	}
+	pushURL, err := withCredentials(glRepoURL, user, gitlabToken)
+	if err != nil {
+		return err
 	}
-	glRepoURL := fmt.Sprintf("https://gitlab.com/%s/%s.git", targetNamespace, repoName)
-	pushURL := strings.Replace(glRepoURL, "https://", fmt.Sprintf("https://oauth2:%s@", gitlabToken), 1)
 	log.Printf("Pushing %s -> GitLab (%s) ...", repoName, targetNamespace)
-	return runCmd("git", "--git-dir", localPath, "push", "--mirror", pushURL)
+	err = pushMirror(localPath, pushURL)
+	if !isProtectedBranchRejection(err) {
+		return err
+	}
+	if config.ForceUnprotect && confirmChange("gitlab", repoName, "unprotect the protected branches for the push") {
+		log.Printf("GitLab rejected the push of protected branches of %s, pushing again with them unprotected (-force-unprotect)", repoName)
+		return pushWithGitLabBranchesUnprotected(repoName, func() error { return pushMirror(localPath, pushURL) })
+	}
+	// push --mirror isn't atomic, everything but the protected branches got pushed
+	log.Printf("⚠️ GitLab rejected the push of protected branches of %s, the other refs were pushed (see -force-unprotect): %v", repoName, err)
+	markAction("updated")
+	return nil
+}
+
+// List group projects / list user projects
+// Docs: https://docs.gitlab.com/ee/api/groups.html#list-a-groups-projects
+// Docs: https://docs.gitlab.com/ee/api/projects.html#list-user-projects
+func listGitLabProjects() ([]GitLabProject, error) {
+	groupID, userName := resolveGitLabNamespace()
+	path := fmt.Sprintf("/api/v4/users/%s/projects", url.PathEscape(userName))
+	if groupID != nil {
+		path = fmt.Sprintf("/api/v4/groups/%d/projects", *groupID)
+	}
+	var projects []GitLabProject
+	for page := 1; ; page++ {
+		resp, err := doGitLabRequest("GET", path, map[string]string{
+			"per_page": "100",
+			"page":     fmt.Sprint(page),
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		var batch []GitLabProject
+		if _, err := handleGitLabResponse(resp, &batch); err != nil {
+			return nil, err
+		}
+		projects = append(projects, batch...)
+		// X-Next-Page is empty on the last page
+		if len(batch) == 0 || resp.Header.Get("X-Next-Page") == "" {
+			return projects, nil
+		}
+	}
+}
+
+// Delete project / archive project
+// Docs: https://docs.gitlab.com/ee/api/projects.html#delete-a-project
+// Docs: https://docs.gitlab.com/ee/api/projects.html#archive-a-project
+func deleteGitLabProject(proj GitLabProject, archive bool) error {
+	method, path := "DELETE", fmt.Sprintf("/api/v4/projects/%d", proj.ID)
+	if archive {
+		method, path = "POST", path+"/archive"
+	}
+	resp, err := doGitLabRequest(method, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return newAPIError("GitLab", resp)
 }
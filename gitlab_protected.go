@@ -0,0 +1,146 @@
+// Protected branches of GitLab projects, which reject the force pushes of push --mirror
+// Docs: https://docs.gitlab.com/ee/api/protected_branches.html
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+type GitLabAccessLevel struct {
+	AccessLevel int  `json:"access_level"`
+	UserID      *int `json:"user_id,omitempty"`
+	GroupID     *int `json:"group_id,omitempty"`
+	DeployKeyID *int `json:"deploy_key_id,omitempty"`
+}
+
+type GitLabProtectedBranch struct {
+	// Name may be a wildcard, e.g. release/*
+	Name                      string              `json:"name"`
+	PushAccessLevels          []GitLabAccessLevel `json:"push_access_levels"`
+	MergeAccessLevels         []GitLabAccessLevel `json:"merge_access_levels"`
+	UnprotectAccessLevels     []GitLabAccessLevel `json:"unprotect_access_levels"`
+	AllowForcePush            bool                `json:"allow_force_push"`
+	CodeOwnerApprovalRequired bool                `json:"code_owner_approval_required"`
+}
+
+// isProtectedBranchRejection reports whether a failed push was (also) rejected by GitLab for
+// a protected branch, e.g. "You are not allowed to force push code to a protected branch".
+func isProtectedBranchRejection(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "protected branch")
+}
+
+// pushWithGitLabBranchesUnprotected unprotects the protected branches of the project for the
+// duration of push, for -force-unprotect, and protects them again with the same settings.
+// It needs the api scope and the Maintainer role.
+func pushWithGitLabBranchesUnprotected(repoName string, push func() error) (err error) {
+	_, namespace := resolveGitLabNamespace()
+	projectPath := "/api/v4/projects/" + gitLabPathID(namespace+"/"+repoName) + "/protected_branches"
+	branches, err := listGitLabProtectedBranches(projectPath)
+	if err != nil {
+		return fmt.Errorf("listing protected branches: %w", err)
+	}
+	var unprotected []GitLabProtectedBranch
+	defer func() {
+		// always put the protection back, even if the push failed again
+		for _, branch := range unprotected {
+			if perr := protectGitLabBranch(projectPath, branch); perr != nil {
+				log.Printf("🚫 Failed to protect branch %s of GitLab project %s again, protect it by hand: %v", branch.Name, repoName, perr)
+				err = errors.Join(err, fmt.Errorf("branch %s left unprotected: %w", branch.Name, perr))
+			}
+		}
+	}()
+	for _, branch := range branches {
+		if err := unprotectGitLabBranch(projectPath, branch.Name); err != nil {
+			return fmt.Errorf("unprotecting branch %s: %w", branch.Name, err)
+		}
+		unprotected = append(unprotected, branch)
+		log.Printf("Unprotected branch %s of GitLab project %s for the push", branch.Name, repoName)
+	}
+	return push()
+}
+
+// List protected branches
+// Docs: https://docs.gitlab.com/ee/api/protected_branches.html#list-protected-branches
+func listGitLabProtectedBranches(path string) ([]GitLabProtectedBranch, error) {
+	var branches []GitLabProtectedBranch
+	for page := 1; ; page++ {
+		resp, err := doGitLabRequest("GET", path, map[string]string{
+			"per_page": "100",
+			"page":     fmt.Sprint(page),
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		var batch []GitLabProtectedBranch
+		if _, err := handleGitLabResponse(resp, &batch); err != nil {
+			return nil, err
+		}
+		branches = append(branches, batch...)
+		if len(batch) == 0 || resp.Header.Get("X-Next-Page") == "" {
+			return branches, nil
+		}
+	}
+}
+
+// Unprotect repository branches
+// Docs: https://docs.gitlab.com/ee/api/protected_branches.html#unprotect-repository-branches
+func unprotectGitLabBranch(path, name string) error {
+	resp, err := doGitLabRequest("DELETE", path+"/"+gitLabPathID(name), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return newAPIError("GitLab", resp)
+}
+
+// Protect repository branches
+// Docs: https://docs.gitlab.com/ee/api/protected_branches.html#protect-repository-branches
+func protectGitLabBranch(path string, branch GitLabProtectedBranch) error {
+	fields := map[string]any{
+		"name":                         branch.Name,
+		"allow_force_push":             branch.AllowForcePush,
+		"code_owner_approval_required": branch.CodeOwnerApprovalRequired,
+	}
+	for _, levels := range []struct {
+		key    string
+		levels []GitLabAccessLevel
+	}{
+		{"push", branch.PushAccessLevels},
+		{"merge", branch.MergeAccessLevels},
+		{"unprotect", branch.UnprotectAccessLevels},
+	} {
+		// the role goes into *_access_level, users, groups and deploy keys (Premium) into allowed_to_*
+		var allowed []map[string]int
+		for _, level := range levels.levels {
+			switch {
+			case level.UserID != nil:
+				allowed = append(allowed, map[string]int{"user_id": *level.UserID})
+			case level.GroupID != nil:
+				allowed = append(allowed, map[string]int{"group_id": *level.GroupID})
+			case level.DeployKeyID != nil:
+				allowed = append(allowed, map[string]int{"deploy_key_id": *level.DeployKeyID})
+			default:
+				fields[levels.key+"_access_level"] = level.AccessLevel
+			}
+		}
+		if len(allowed) > 0 {
+			fields["allowed_to_"+levels.key] = allowed
+		}
+	}
+	byts, _ := json.Marshal(fields)
+	resp, err := doGitLabRequest("POST", path, nil, bytes.NewReader(byts))
+	if err != nil {
+		return err
+	}
+	var protected GitLabProtectedBranch
+	_, err = handleGitLabResponse(resp, &protected)
+	return err
+}
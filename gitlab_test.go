@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// gitLabResponse is the canned answer of fakeGitLab to one escaped request path.
+type gitLabResponse struct {
+	status int
+	body   string
+}
+
+// fakeGitLab is a GitLab API answering the paths in routes and 404 to anything else.
+// It records the method and escaped path of every request, and the bodies.
+type fakeGitLab struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+	bodies   []string
+}
+
+func newFakeGitLab(t *testing.T, routes map[string]gitLabResponse) *fakeGitLab {
+	withConfig(t)
+	g := &fakeGitLab{}
+	g.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		g.mu.Lock()
+		g.requests = append(g.requests, r.Method+" "+r.URL.EscapedPath())
+		g.bodies = append(g.bodies, string(body))
+		g.mu.Unlock()
+		res, ok := routes[r.Method+" "+r.URL.EscapedPath()]
+		if !ok {
+			res = gitLabResponse{http.StatusNotFound, `{"message":"404 Not Found"}`}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(res.status)
+		w.Write([]byte(res.body))
+	}))
+	t.Cleanup(g.Close)
+	config.GitLabURL = g.URL
+	config.GitLabToken = "glpat-test"
+	config.GitLabUser = "alice"
+	t.Cleanup(func() { gitLabNamespaceGroup = nil })
+	return g
+}
+
+func (g *fakeGitLab) requested() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.requests...)
+}
+
+func TestLoadGitLabNamespace(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		status  int
+		body    string
+		wantID  int
+		wantErr string
+	}{
+		{"found", http.StatusOK, `{"id":42,"full_path":"acme"}`, 42, ""},
+		{"not found", http.StatusNotFound, `{"message":"404 Group Not Found"}`, 0, "GitLab group acme not found"},
+		{"forbidden", http.StatusForbidden, `{"message":"403 Forbidden"}`, 0, "GitLab API error 403"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeGitLab(t, map[string]gitLabResponse{
+				"GET /api/v4/groups/acme": {tt.status, tt.body},
+			})
+			config.GitLabGroup = "acme"
+			err := loadGitLabNamespace()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if gitLabNamespaceGroup != nil {
+					t.Errorf("group = %+v after a failed lookup", gitLabNamespaceGroup)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gitLabNamespaceGroup == nil || gitLabNamespaceGroup.ID != tt.wantID {
+				t.Errorf("group = %+v, want ID %d", gitLabNamespaceGroup, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestGetGitLabProject(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		status int
+		body   string
+		wantID int // 0 for no project
+	}{
+		{"found", http.StatusOK, `{"id":7,"path":"repo","visibility":"private"}`, 7},
+		{"not found", http.StatusNotFound, `{"message":"404 Project Not Found"}`, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeGitLab(t, map[string]gitLabResponse{
+				"GET /api/v4/projects/alice%2Frepo": {tt.status, tt.body},
+			})
+			proj, err := getGitLabProject("repo")
+			if err != nil {
+				t.Fatalf("err = %v, want nil", err)
+			}
+			switch {
+			case tt.wantID == 0 && proj != nil:
+				t.Errorf("project = %+v, want nil", proj)
+			case tt.wantID != 0 && (proj == nil || proj.ID != tt.wantID):
+				t.Errorf("project = %+v, want ID %d", proj, tt.wantID)
+			}
+		})
+	}
+}
+
+// TestGitLabSubgroup round-trips a 3-level GITLAB_GROUP: the group and the project are
+// looked up by their fully escaped paths, and the project is created with the
+// subgroup's ID rather than under the user.
+func TestGitLabSubgroup(t *testing.T) {
+	g := newFakeGitLab(t, map[string]gitLabResponse{
+		"GET /api/v4/groups/a%2Fb%2Fc": {http.StatusOK, `{"id":99,"full_path":"a/b/c"}`},
+		"POST /api/v4/projects":        {http.StatusCreated, `{"id":7,"path":"repo"}`},
+	})
+	config.GitLabGroup = "a/b/c"
+	if err := loadGitLabNamespace(); err != nil {
+		t.Fatal(err)
+	}
+	if id, path := resolveGitLabNamespace(); id == nil || *id != 99 || path != "a/b/c" {
+		t.Fatalf("namespace = %v, %q, want 99, a/b/c", id, path)
+	}
+	if err := checkAndValidateGitLabRepos("repo", "private", "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"GET /api/v4/groups/a%2Fb%2Fc",
+		"GET /api/v4/projects/a%2Fb%2Fc%2Frepo",
+		"POST /api/v4/projects",
+	}
+	got := g.requested()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("requests:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	var payload struct {
+		NamespaceID int    `json:"namespace_id"`
+		Path        string `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(g.bodies[2]), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.NamespaceID != 99 || payload.Path != "repo" {
+		t.Errorf("created %+v, want path repo in namespace 99", payload)
+	}
+}
+
+func TestResolveGitLabNamespace(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		group    string
+		wantID   int // 0 for the user's namespace
+		wantPath string
+		wantErr  string
+	}{
+		{"user", "", 0, "alice", ""},
+		{"group by path", "acme/backend", 42, "acme/backend", ""},
+		{"group by id", "42", 42, "acme/backend", ""},
+		{"group not found", "nope", 0, "", "GitLab group nope not found"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			group := gitLabResponse{http.StatusOK, `{"id":42,"full_path":"acme/backend"}`}
+			g := newFakeGitLab(t, map[string]gitLabResponse{
+				"GET /api/v4/groups/acme%2Fbackend": group,
+				"GET /api/v4/groups/42":             group,
+			})
+			config.GitLabGroup = tt.group
+			err := loadGitLabNamespace()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.group == "" && len(g.requested()) > 0 {
+				t.Errorf("looked up a group without GITLAB_GROUP: %v", g.requested())
+			}
+			id, path := resolveGitLabNamespace()
+			if tt.wantID == 0 && id != nil || tt.wantID != 0 && (id == nil || *id != tt.wantID) {
+				t.Errorf("id = %v, want %d", id, tt.wantID)
+			}
+			if path != tt.wantPath {
+				t.Errorf("path = %q, want %q", path, tt.wantPath)
+			}
+		})
+	}
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,56 +28,309 @@ func (trt transportRoundTrip) RoundTrip(req *http.Request) (*http.Response, erro
 	return trt.RoundTripImpl(req)
 }
 
-// transport is a custom RoundTripper that logs request/response details
-// transport wraps DefaultTransport to log request/response details
-var transport = NewRoundTripper(func(req *http.Request) (*http.Response, error) {
+// serviceClientOptions tunes the client of one service, see newServiceClient.
+type serviceClientOptions struct {
+	// MaxIdleConnsPerHost is the connections kept open to the service's API host,
+	// 0 for DefaultTransport's 2
+	MaxIdleConnsPerHost int
+	// Timeout is used instead of HTTP_TIMEOUT when set
+	Timeout time.Duration
+	// NoTimeout exempts the client from HTTP_TIMEOUT, for large transfers
+	NoTimeout bool
+	// ProxyEnv optionally names a setting with a proxy for this service only,
+	// which wins over HTTPS_PROXY, e.g. GITHUB_PROXY
+	ProxyEnv string
+	// Throttle spaces the requests by API_SLEEP, for the target services, whose rate
+	// limits (unlike GitHub's) aren't reported in the responses
+	Throttle bool
+}
+
+// serviceClient is a client created by newServiceClient, with its own connection pool.
+type serviceClient struct {
+	opts      serviceClientOptions
+	client    *http.Client
+	transport *http.Transport
+	// proxy is the proxy URL set by configureProxy, "" for none
+	proxy string
+}
+
+// serviceClients are all clients of newServiceClient, for applyHTTPTimeout and configureProxy.
+var serviceClients []*serviceClient
+
+// newServiceClient returns a client with its own transport, so that pool sizes, timeouts
+// and proxies can differ between services. Requests and responses are logged by loggingTransport.
+func newServiceClient(opts serviceClientOptions) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	}
+	rt := loggingTransport(t)
+	if opts.Throttle {
+		rt = throttlingTransport(rt)
+	}
+	c := &http.Client{Transport: rt, Timeout: opts.Timeout}
+	serviceClients = append(serviceClients, &serviceClient{opts: opts, client: c, transport: t})
+	return c
+}
+
+// loggingTransport wraps base to log request/response details
+func loggingTransport(base http.RoundTripper) http.RoundTripper {
+	return NewRoundTripper(func(req *http.Request) (*http.Response, error) {
+		return logRoundTrip(base, req)
+	})
+}
+
+// throttlingTransport lets at least API_SLEEP pass between the starts of two requests
+// through base, retries included, so big runs don't trip rate limits. 0 disables it.
+func throttlingTransport(base http.RoundTripper) http.RoundTripper {
+	var mu sync.Mutex
+	var next time.Time
+	return NewRoundTripper(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		now := time.Now()
+		wait := next.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+		next = now.Add(wait + config.SleepBetweenAPI)
+		mu.Unlock()
+		if wait > 0 {
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(wait):
+			}
+		}
+		return base.RoundTrip(req)
+	})
+}
+
+func logRoundTrip(base http.RoundTripper, req *http.Request) (*http.Response, error) {
 	now := time.Now()
 	var err error
+	reqURL := redactURL(req.URL)
+	log.Printf("⬆️ Request headers (%s %s): %v", req.Method, reqURL, redactHeaders(req.Header))
 
-	var reqAllBody []byte
-	if req.Body != nil {
-		if reqAllBody, err = io.ReadAll(req.Body); err != nil {
-			log.Printf("❌ Error reading request body: %v", err)
-		} else {
-			req.Body = io.NopCloser(bytes.NewReader(reqAllBody)) // clone body
-			if len(reqAllBody) > 0 {
-				log.Printf("⬆️ Request body (%s %s):\n%s", req.Method, req.URL, string(reqAllBody))
+	if config.LogBodies {
+		var reqAllBody []byte
+		if ct := req.Header.Get("Content-Type"); req.Body != nil && ct != "" && !strings.Contains(ct, "json") {
+			// uploads aren't worth buffering into memory for the log
+			log.Printf("⬆️ Request body (%s %s): <%d bytes of %s>", req.Method, reqURL, req.ContentLength, ct)
+		} else if req.Body != nil {
+			if reqAllBody, err = io.ReadAll(req.Body); err != nil {
+				log.Printf("❌ Error reading request body: %v", err)
 			} else {
-				log.Printf("⬆️ Request body (%s %s): <empty>", req.Method, req.URL)
+				// the body can only be read once: hand the snapshot on, with a GetBody so that
+				// the transport's own retries and redirects replay it rather than an empty body.
+				// A RoundTripper must not modify the caller's request, so that goes to a clone.
+				snapshot := reqAllBody
+				req.Body.Close()
+				req = req.Clone(req.Context())
+				req.Body = io.NopCloser(bytes.NewReader(snapshot))
+				req.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(snapshot)), nil
+				}
+				if len(reqAllBody) > 0 {
+					log.Printf("⬆️ Request body (%s %s):\n%s", req.Method, reqURL, truncateBody(reqAllBody, int64(len(reqAllBody))))
+				} else {
+					log.Printf("⬆️ Request body (%s %s): <empty>", req.Method, reqURL)
+				}
 			}
+		} else {
+			log.Printf("⬆️ Request body (%s %s): <nil>", req.Method, reqURL)
 		}
-	} else {
-		log.Printf("⬆️ Request body (%s %s): <nil>", req.Method, req.URL)
 	}
-	// capture request headers if needed (not currently used)
 
-	// Perform HTTP request using default transport
-	res, err := http.DefaultTransport.RoundTrip(req)
+	// Perform HTTP request using the proxy-aware base transport
+	res, err := base.RoundTrip(req)
 
 	if err != nil {
-		log.Printf("❌ Error performing request: %v", err)
+		log.Printf("❌ Error performing request: %s", redactText(err.Error()))
 		return res, err
 	}
 
-	var resAllBody []byte
-	if res.Body != nil {
-		if resAllBody, err = io.ReadAll(res.Body); err != nil {
-			log.Printf("❌ Error reading response body: %v", err)
-		} else {
-			res.Body = io.NopCloser(bytes.NewReader(resAllBody)) // clone body
-			if len(resAllBody) > 0 {
-				log.Printf("⬇️ Response body (%s %s -> %d):\n%s", req.Method, req.URL, res.StatusCode, string(resAllBody))
+	if config.LogBodies {
+		var resAllBody []byte
+		if res.Body != nil {
+			// Only buffer what gets logged, the rest is streamed to the caller
+			body := res.Body
+			if config.MaxLogBody > 0 {
+				body = io.NopCloser(io.LimitReader(res.Body, int64(config.MaxLogBody+bodyLogSlack)))
+			}
+			if resAllBody, err = io.ReadAll(body); err != nil {
+				// e.g. the client timeout or an interrupt cancelled the request; what's
+				// left of the body is unusable, so fail like the read itself would have
+				log.Printf("❌ Error reading response body: %s", redactText(err.Error()))
+				res.Body.Close()
+				return nil, err
 			} else {
-				log.Printf("⬇️ Response body (%s %s -> %d): <empty>", req.Method, req.URL, res.StatusCode)
+				total := int64(len(resAllBody))
+				if config.MaxLogBody > 0 && len(resAllBody) == config.MaxLogBody+bodyLogSlack {
+					// the body may go on, hand the peeked part and the rest downstream
+					res.Body = readCloser{io.MultiReader(bytes.NewReader(resAllBody), res.Body), res.Body}
+					total = res.ContentLength
+				} else {
+					res.Body.Close()
+					res.Body = io.NopCloser(bytes.NewReader(resAllBody)) // clone body
+				}
+				if len(resAllBody) > 0 {
+					log.Printf("⬇️ Response body (%s %s -> %d):\n%s", req.Method, reqURL, res.StatusCode, truncateBody(resAllBody, total))
+				} else {
+					log.Printf("⬇️ Response body (%s %s -> %d): <empty>", req.Method, reqURL, res.StatusCode)
+				}
 			}
+		} else {
+			log.Printf("⬇️ Response body (%s %s -> %d): <nil>", req.Method, reqURL, res.StatusCode)
 		}
-	} else {
-		log.Printf("⬇️ Response body (%s %s -> %d): <nil>", req.Method, req.URL, res.StatusCode)
 	}
 	// capture response headers if needed (not currently used)
 	// var resHeaders map[string][]string = res.Header.Clone()
 
 	// Log duration and status
-	log.Printf("📡 %s %s -> %d (%v)", req.Method, req.URL, res.StatusCode, time.Since(now))
+	log.Printf("📡 %s %s -> %d (%v)", req.Method, reqURL, res.StatusCode, time.Since(now))
 	return res, err
-})
+}
+
+// applyHTTPTimeout sets config.HTTPTimeout on the API clients. git runs as a subprocess
+// and is bounded by -git-timeout instead.
+func applyHTTPTimeout() {
+	for _, sc := range serviceClients {
+		if !sc.opts.NoTimeout && sc.opts.Timeout == 0 {
+			sc.client.Timeout = config.HTTPTimeout
+		}
+	}
+}
+
+// transferClient downloads and uploads release assets, whose size makes any fixed
+// overall timeout wrong; DefaultTransport still bounds the TLS handshake.
+var transferClient = newServiceClient(serviceClientOptions{NoTimeout: true})
+
+// bodyLogSlack is read beyond -max-log-body, so a secret crossing the cut
+// is still recognized and redacted before the body is truncated.
+const bodyLogSlack = 512
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// truncateBody redacts body and cuts it to -max-log-body bytes for the log.
+// total is the length of the whole body, or -1 if unknown.
+func truncateBody(body []byte, total int64) []byte {
+	masked := redactBody(body)
+	if config.MaxLogBody <= 0 || len(masked) <= config.MaxLogBody {
+		return masked
+	}
+	marker := "...[truncated]"
+	if total >= 0 {
+		marker = fmt.Sprintf("...[truncated %d bytes]", total-int64(config.MaxLogBody))
+	}
+	return append(masked[:config.MaxLogBody:config.MaxLogBody], marker...)
+}
+
+// isRetryableStatus reports whether a response with this status is worth retrying.
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses the Retry-After header, given either in seconds or as an HTTP date.
+func retryAfter(h http.Header) (time.Duration, bool) {
+	val := h.Get("Retry-After")
+	if val == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(val); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// backoff returns the exponential delay before retry number attempt (0-based), with jitter.
+func backoff(attempt int) time.Duration {
+	const base, max = time.Second, 30 * time.Second
+	d := base << attempt
+	if d > max || d <= 0 {
+		d = max
+	}
+	// add up to 50% jitter so concurrent clients don't retry in lockstep
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// isIdempotent reports whether sending a request with this method twice has the same
+// effect as sending it once, so it can be retried whatever happened to the first one.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// shouldRetry reports whether the outcome of req is worth retrying. A POST or PATCH may
+// have been applied before the connection broke or the server failed, so those are
+// only retried when the server said it didn't process them: 429, or 503 with Retry-After.
+func shouldRetry(req *http.Request, res *http.Response, err error) bool {
+	if isIdempotent(req.Method) {
+		return err != nil || isRetryableStatus(res.StatusCode)
+	}
+	if err != nil {
+		return false
+	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	_, ok := retryAfter(res.Header)
+	return res.StatusCode == http.StatusServiceUnavailable && ok
+}
+
+// doWithRetry sends req with client, retrying connection errors and 429/5xx responses
+// up to MAX_RETRIES times with exponential backoff, honoring Retry-After. See shouldRetry
+// for the methods that aren't idempotent. The wait ends early on an interrupt.
+func doWithRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		res, err := client.Do(req)
+		// a consumed body can only be sent again if it can be recreated
+		replayable := req.Body == nil || req.GetBody != nil
+		if !shouldRetry(req, res, err) || !replayable || attempt >= config.MaxRetries {
+			return res, err
+		}
+
+		wait := backoff(attempt)
+		if err != nil {
+			log.Printf("🔁 %s %s failed: %s, retrying in %v (%d/%d)", req.Method, redactURL(req.URL), redactText(err.Error()), wait.Round(time.Millisecond), attempt+1, config.MaxRetries)
+		} else {
+			if d, ok := retryAfter(res.Header); ok && d > 0 {
+				wait = d
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			log.Printf("🔁 %s %s -> %d, retrying in %v (%d/%d)", req.Method, redactURL(req.URL), res.StatusCode, wait.Round(time.Millisecond), attempt+1, config.MaxRetries)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-stopCtx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%s %s not retried: %w", req.Method, redactURL(req.URL), stopCtx.Err())
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("replaying request body: %w", err)
+			}
+			req.Body = body
+		}
+	}
+}
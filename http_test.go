@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer answers the first failures requests with status and Retry-After: 1, then 200.
+// It records the bodies it received.
+type flakyServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []string
+}
+
+func newFlakyServer(t *testing.T, failures, status int) *flakyServer {
+	s := &flakyServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(body))
+		n := len(s.bodies)
+		s.mu.Unlock()
+		if n <= failures {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *flakyServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+func withMaxRetries(t *testing.T, n int) {
+	old := config.MaxRetries
+	config.MaxRetries = n
+	t.Cleanup(func() { config.MaxRetries = old })
+}
+
+func TestDoWithRetryReplaysBody(t *testing.T) {
+	withMaxRetries(t, 3)
+	srv := newFlakyServer(t, 2, http.StatusServiceUnavailable)
+
+	req, _ := http.NewRequest("POST", srv.URL, bytes.NewReader([]byte(`{"name":"repo"}`)))
+	start := time.Now()
+	res, err := doWithRetry(srv.Client(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", res.StatusCode)
+	}
+	// Retry-After: 1 replaces the backoff, which would be 1s and 2s plus jitter
+	if elapsed := time.Since(start); elapsed < 2*time.Second || elapsed >= 3*time.Second {
+		t.Errorf("took %v, want two waits of 1s", elapsed)
+	}
+	got := srv.requests()
+	if len(got) != 3 {
+		t.Fatalf("sent %d requests, want 3", len(got))
+	}
+	for i, body := range got {
+		if body != `{"name":"repo"}` {
+			t.Errorf("request %d body = %q", i+1, body)
+		}
+	}
+}
+
+func TestDoWithRetryGivesUp(t *testing.T) {
+	withMaxRetries(t, 1)
+	srv := newFlakyServer(t, 5, http.StatusServiceUnavailable)
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	res, err := doWithRetry(srv.Client(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", res.StatusCode)
+	}
+	if n := len(srv.requests()); n != 2 {
+		t.Errorf("sent %d requests, want 2", n)
+	}
+}
+
+func TestDoWithRetryPOST(t *testing.T) {
+	withMaxRetries(t, 3)
+	for _, tt := range []struct {
+		name     string
+		status   int
+		attempts int
+	}{
+		{"500 may have been applied", http.StatusInternalServerError, 1},
+		{"502 may have been applied", http.StatusBadGateway, 1},
+		{"429 was not processed", http.StatusTooManyRequests, 2},
+		{"503 with Retry-After was not processed", http.StatusServiceUnavailable, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFlakyServer(t, 1, tt.status)
+			req, _ := http.NewRequest("POST", srv.URL, bytes.NewReader([]byte("{}")))
+			res, err := doWithRetry(srv.Client(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if n := len(srv.requests()); n != tt.attempts {
+				t.Errorf("sent %d requests, want %d", n, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestDoWithRetryStopsWaiting(t *testing.T) {
+	withMaxRetries(t, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	start := time.Now()
+	if _, err := doWithRetry(srv.Client(), req); err == nil {
+		t.Fatal("want the cancelled context's error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("waited %v for Retry-After despite the cancelled request", elapsed)
+	}
+}
+
+func TestLogBodiesRetriedPOST(t *testing.T) {
+	withMaxRetries(t, 3)
+	oldLogBodies := config.LogBodies
+	config.LogBodies = true
+	t.Cleanup(func() { config.LogBodies = oldLogBodies })
+	srv := newFlakyServer(t, 1, http.StatusTooManyRequests)
+
+	client := &http.Client{Transport: loggingTransport(http.DefaultTransport)}
+	body := bytes.NewReader([]byte(`{"name":"repo"}`))
+	req, _ := http.NewRequest("POST", srv.URL, body)
+	res, err := doWithRetry(client, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	got := srv.requests()
+	if len(got) != 2 {
+		t.Fatalf("sent %d requests, want 2", len(got))
+	}
+	for i, b := range got {
+		if b != `{"name":"repo"}` {
+			t.Errorf("request %d body = %q", i+1, b)
+		}
+	}
+}
+
+func TestLogRoundTripLeavesRequest(t *testing.T) {
+	oldLogBodies := config.LogBodies
+	config.LogBodies = true
+	t.Cleanup(func() { config.LogBodies = oldLogBodies })
+	srv := newFlakyServer(t, 0, http.StatusOK)
+
+	body := io.NopCloser(bytes.NewReader([]byte("{}")))
+	req, _ := http.NewRequest("POST", srv.URL, body)
+	req.GetBody = nil
+	res, err := logRoundTrip(http.DefaultTransport, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if req.Body != body || req.GetBody != nil {
+		t.Error("logRoundTrip replaced the body of the caller's request")
+	}
+	if got := srv.requests(); len(got) != 1 || got[0] != "{}" {
+		t.Errorf("server received %q, want the logged body", got)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// listedRepo is a repo as printed by -list -list-format json.
+type listedRepo struct {
+	Name     string    `json:"name"`
+	FullName string    `json:"full_name"`
+	Private  bool      `json:"private"`
+	Fork     bool      `json:"fork"`
+	Archived bool      `json:"archived"`
+	Gist     bool      `json:"gist,omitempty"`
+	SizeKB   int       `json:"size_kb"`
+	PushedAt time.Time `json:"pushed_at"`
+}
+
+// printRepoList writes the repos selected for a sync to w, as a table or as a JSON array.
+func printRepoList(w io.Writer, repos []GitHubRepo, format string) error {
+	if format == "json" {
+		listed := make([]listedRepo, 0, len(repos))
+		for _, r := range repos {
+			listed = append(listed, listedRepo{r.Name, r.FullName, r.Private, r.Fork, r.Archived, r.Gist, r.Size, r.PushedAt})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(listed)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tFLAGS\tPUSHED")
+	for _, r := range repos {
+		var flags []string
+		if r.Private {
+			flags = append(flags, "private")
+		}
+		if r.Fork {
+			flags = append(flags, "fork")
+		}
+		if r.Archived {
+			flags = append(flags, "archived")
+		}
+		if r.Gist {
+			flags = append(flags, "gist")
+		}
+		if len(flags) == 0 {
+			flags = append(flags, "-")
+		}
+		pushed := "-"
+		if !r.PushedAt.IsZero() {
+			pushed = r.PushedAt.Format("2006-01-02")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.FullName, formatBytes(int64(r.Size)*1024), strings.Join(flags, ","), pushed)
+	}
+	fmt.Fprintf(tw, "\n%d repo(s)\n", len(repos))
+	return tw.Flush()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logFields are structured fields attached to a log entry, e.g. repo, target, duration_ms.
+// They are only emitted with -log-format json; text output stays the plain message.
+type logFields map[string]any
+
+// jsonLog is set by setupLogger when -log-format json is used.
+var jsonLog *jsonLogWriter
+
+// logWith logs like log.Printf, attaching fields in JSON mode.
+func logWith(fields logFields, format string, args ...any) {
+	if jsonLog == nil {
+		log.Printf(format, args...)
+		return
+	}
+	jsonLog.writeEntry(fields, fmt.Sprintf(format, args...))
+}
+
+// jsonLogWriter is the output of the standard logger in JSON mode: each line
+// written to it, including git output, becomes one {"ts","level","msg"} object.
+type jsonLogWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (jw *jsonLogWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+			if err := jw.writeEntry(nil, line); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (jw *jsonLogWriter) writeEntry(fields logFields, msg string) error {
+	var buf bytes.Buffer
+	buf.WriteString(`{"ts":`)
+	writeJSON(&buf, time.Now().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSON(&buf, logLevel(msg))
+	buf.WriteString(`,"msg":`)
+	writeJSON(&buf, msg)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteByte(',')
+		writeJSON(&buf, k)
+		buf.WriteByte(':')
+		writeJSON(&buf, fields[k])
+	}
+	buf.WriteString("}\n")
+
+	jw.mu.Lock()
+	defer jw.mu.Unlock()
+	_, err := jw.w.Write(buf.Bytes())
+	return err
+}
+
+// writeJSON appends v to buf, falling back to its %v string if it can't be encoded.
+func writeJSON(buf *bytes.Buffer, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(data)
+}
+
+// logLevel derives the level from the emoji prefix the messages already carry.
+func logLevel(msg string) string {
+	switch {
+	case strings.HasPrefix(msg, "🚫"):
+		return "error"
+	case strings.HasPrefix(msg, "⚠️"):
+		return "warn"
+	default:
+		return "info"
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,117 +20,1039 @@ import (
 )
 
 type Config struct {
-	GitHubUser      string
-	GitHubToken     string
-	GitLabUser      string
-	GitLabGroup     string
-	GitLabToken     string
-	CodebergUser    string
-	CodebergToken   string
-	BitbucketEmail  string
-	BitbucketToken  string
-	BitbucketWs     string
-	RepoVisibility  string
-	PerPage         int
-	BackupDir       string
-	LogsFolder      string
+	GitHubUser     string
+	GitHubToken    string
+	GitHubAPIURL   string
+	GitLabURL      string
+	GitLabUser     string
+	GitLabGroup    string
+	GitLabToken    string
+	GitLabAuthMode string
+	// GitLabAuthScheme sends GITLAB_TOKEN as PRIVATE-TOKEN, or as a bearer token for OAuth tokens
+	GitLabAuthScheme string
+	// GitLabPublicAs is the visibility of the mirrors of public repos, "internal" for signed-in users only
+	GitLabPublicAs string
+	// Gitea/Forgejo; the codeberg target is the instance at https://codeberg.org.
+	// GiteaOwner owns the mirrors: GiteaOrg (CODEBERG_ORG or GITEA_ORG) if set, else GiteaUser
+	GiteaURL       string
+	GiteaName      string
+	GiteaUser      string
+	GiteaToken     string
+	GiteaOrg       string
+	GiteaOwner     string
+	BitbucketEmail string
+	BitbucketToken string
+	BitbucketWs    string
+	// BitbucketURL is a Bitbucket Data Center instance, BitbucketWs then is a project key
+	BitbucketURL  string
+	BitbucketUser string
+	// BitbucketProject is the key of the Cloud project the repos are created in and moved to, "" for the workspace's default
+	BitbucketProject string
+	// Azure DevOps organization and the project the repos are created in
+	AzureOrg       string
+	AzureProject   string
+	AzureToken     string
+	SourceHutUser  string
+	SourceHutToken string
+	FSTargetDir    string
+	// AWS credentials are resolved like the AWS CLI, see aws.go
+	CodeCommitRegion  string
+	CodeCommitGitUser string
+	CodeCommitGitPass string
+	RepoVisibility    string
+	VisibilityMap     map[string]string
+	MaxRefs           int
+	MaxRefsAction     string
+	MaxRefsFilter     []string
+	DestAddedTopics   []string
+	OwnerFilter       []string
+	// organizations listed instead of the authenticated user's repos
+	GitHubOrgs []string
+	// query params of the GitHub listing; GitHubType replaces the other two
+	GitHubAffiliation string
+	GitHubVisibility  string
+	GitHubType        string
+	IncludePatterns   []string
+	ExcludePatterns   []string
+	IncludeForks      bool
+	IncludeGists      bool
+	// OnlyVisibility keeps only the "private" or "public" repos of the listing, "" for all
+	OnlyVisibility string
+	// set the destination default branch to GitHub's after every push
+	FixDefaultBranch bool
+	PerPage          int
+	BackupDir        string
+	LogsFolder       string
+	// SleepBetweenAPI spaces the API calls to the targets and the GitHub listing; 0 disables it
 	SleepBetweenAPI time.Duration
+	// HTTPTimeout bounds each API call, from connecting to reading the body; 0 disables it
+	HTTPTimeout time.Duration
+	// Proxy is used for the API calls and git, unless the host matches NoProxy
+	Proxy         string
+	NoProxy       string
+	MaxRetries    int
+	CheckSecurity bool
+	DumpSecurity  bool
+	Trace         bool
+	DryRun        bool
+	PruneArchive  bool
+	AssumeYes     bool
+	LogFormat     string
+	// Quiet drops the progress lines on stderr, Verbose echoes the whole log there instead
+	Quiet   bool
+	Verbose bool
+	// GitVerbosity is "verbose" (-verbose-git) or "quiet" (-quiet-git) for git clone, fetch and push
+	GitVerbosity string
+	// NotifyURL receives the outcome of the run as NotifyFormat (generic | slack | discord)
+	NotifyURL     string
+	NotifyFormat  string
+	FailFast      bool
+	GitTimeout    time.Duration
+	MaxLogBody    int
+	LogBodies     bool
+	MaxRepoSizeMB int
+	SyncTopics    bool
+	SyncReleases  bool
+	SyncWiki      bool
+	// SkipEmpty skips repos without any ref instead of creating them empty on the targets
+	SkipEmpty bool
+	// StripPullRefs removes refs/pull/* from the mirrors before they are pushed
+	StripPullRefs bool
+	// VerifyPush compares the refs of the destination with the mirror after each push
+	VerifyPush bool
+	// PartialClone clones new mirrors without their blobs, see partialclone.go
+	PartialClone bool
+	// ForceUnprotect lifts the protection of GitLab branches which rejected the push while pushing again
+	ForceUnprotect bool
+	// MetricsPushURL is the Pushgateway the metrics are pushed to at the end of the run
+	MetricsPushURL string
+	// OTLPEndpoint enables the OpenTelemetry spans of otel.go, OTLPTracesEndpoint overrides its /v1/traces
+	OTLPEndpoint       string
+	OTLPTracesEndpoint string
+	OTLPHeaders        map[string]string
+	OTelServiceName    string
+	// Refspecs replace push --mirror with a push of only these refs, see normalizeRefspec
+	Refspecs []string
+	// GitConfig are key=value options passed with -c to every git command, see -git-config
+	GitConfig []string
+	// Direction is github-to-target, or target-to-github for the reverse sync in reverse.go
+	Direction    string
+	ExportBundle bool
+	Since        time.Duration
+	SinceLastRun bool
+	Force        bool
+	ExportDir    string
+	// runs a repo must be missing from the GitHub listing before it counts as deleted
+	PruneMinMissingRuns int
+	// QuarantineAfter is the number of failed reclones in a row after which a repo is skipped, 0 never
+	QuarantineAfter int
+	// NamePrefix, NameSuffix and NameReplace rename the destination repos, see targetName
+	NamePrefix      string
+	NameSuffix      string
+	NameReplace     *regexp.Regexp
+	NameReplaceWith string
+	// GitHubAppID, GitHubAppKey and GitHubInstallationID authenticate as a GitHub App
+	// installation instead of GITHUB_USER/GITHUB_TOKEN, see githubapp.go
+	GitHubAppID          string
+	GitHubAppKey         *rsa.PrivateKey
+	GitHubInstallationID string
+	// Interactive asks before changing or pruning existing target repos, see confirmChange
+	Interactive bool
+	// GitHubAccept and GitHubAPIVersion are sent with every GitHub API call, GitHubAPIVersion only if set
+	GitHubAccept     string
+	GitHubAPIVersion string
+	// SyncProperties copies the GitHub custom properties to GitLab project labels, see properties.go
+	SyncProperties bool
+	// OutputDir holds a dir per run, RunDir, with its log, summary, failures and trace; see applyOutputDir
+	OutputDir string
+	RunDir    string
+	// RunDeadline bounds the whole run (-run-deadline), unlike GitTimeout which bounds each git command
+	RunDeadline time.Duration
 }
 
 var config Config
-var ghClient = &http.Client{Transport: transport}
-var glClient = &http.Client{Transport: transport}
-var bbClient = &http.Client{Transport: transport}
-var cbClient = &http.Client{Transport: transport}
+var runStarted = time.Now()
 
-func loadConfig(target string) Config {
+// runID identifies this execution across the log file, reports and notifications.
+var runID = newRunID(runStarted)
+
+// one client per service, see newServiceClient; GitHub listings fetch pages concurrently
+var ghClient = newServiceClient(serviceClientOptions{MaxIdleConnsPerHost: githubListingWorkers, ProxyEnv: "GITHUB_PROXY"})
+var glClient = newServiceClient(serviceClientOptions{ProxyEnv: "GITLAB_PROXY", Throttle: true})
+var bbClient = newServiceClient(serviceClientOptions{ProxyEnv: "BITBUCKET_PROXY", Throttle: true})
+var giteaClient = newServiceClient(serviceClientOptions{ProxyEnv: "GITEA_PROXY", Throttle: true})
+var azClient = newServiceClient(serviceClientOptions{ProxyEnv: "AZURE_DEVOPS_PROXY", Throttle: true})
+var srhtClient = newServiceClient(serviceClientOptions{ProxyEnv: "SOURCEHUT_PROXY", Throttle: true})
+var ccClient = newServiceClient(serviceClientOptions{ProxyEnv: "CODECOMMIT_PROXY", Throttle: true})
+
+func loadConfig(targets []string, withGitHub bool) Config {
 	cfg := Config{
-		GitHubUser:      mustGetEnv("GITHUB_USER"),
-		GitHubToken:     mustGetEnv("GITHUB_TOKEN"),
 		RepoVisibility:  getEnv("REPO_VISIBILITY", "auto"),
+		DestAddedTopics: splitList(getEnv("DEST_ADDED_TOPICS", "")),
 		PerPage:         100,
-		BackupDir:       "./repos-backup",
-		LogsFolder:      "./logs",
-		SleepBetweenAPI: 500 * time.Millisecond,
+		BackupDir:       getEnv("BACKUP_DIR", "./repos-backup"),
+		LogsFolder:      getEnv("LOGS_DIR", "./logs"),
+		OutputDir:       getEnv("OUTPUT_DIR", ""),
+		ExportDir:       getEnv("EXPORT_DIR", ""),
+		SleepBetweenAPI: getEnvDuration("API_SLEEP", 500*time.Millisecond),
+		MaxRetries:      getEnvInt("MAX_RETRIES", 3),
+		HTTPTimeout:     getEnvDuration("HTTP_TIMEOUT", 30*time.Second),
+		Proxy:           getEnv("HTTPS_PROXY", getEnv("https_proxy", getEnv("HTTP_PROXY", lookupEnv("http_proxy")))),
+		NoProxy:         getEnv("NO_PROXY", lookupEnv("no_proxy")),
 	}
-	switch target {
-	case "gitlab":
-		cfg.GitLabUser = mustGetEnv("GITLAB_USER")
-		cfg.GitLabToken = mustGetEnv("GITLAB_TOKEN")
-		cfg.GitLabGroup = getEnv("GITLAB_GROUP", "")
-	case "codeberg":
-		cfg.CodebergUser = mustGetEnv("CODEBERG_USER")
-		cfg.CodebergToken = mustGetEnv("CODEBERG_TOKEN")
-	case "bitbucket":
-		cfg.BitbucketEmail = mustGetEnv("BITBUCKET_EMAIL")
-		cfg.BitbucketToken = mustGetEnv("BITBUCKET_TOKEN")
-		// Workspace is required for Bitbucket API
-		cfg.BitbucketWs = mustGetEnv("BITBUCKET_WORKSPACE")
+	cfg.OTLPEndpoint = getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	cfg.OTLPTracesEndpoint = getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if cfg.OTLPEndpoint == "" {
+		// the traces endpoint alone enables tracing as well
+		cfg.OTLPEndpoint = cfg.OTLPTracesEndpoint
+	}
+	cfg.OTLPHeaders = parseOTLPHeaders(getEnv("OTEL_EXPORTER_OTLP_HEADERS", ""))
+	cfg.OTelServiceName = getEnv("OTEL_SERVICE_NAME", "git-sync")
+	// A standalone maintenance run only touches local mirrors
+	if !withGitHub {
+		return cfg
+	}
+	cfg.GitHubAPIURL = getEnv("GITHUB_API_URL", "https://api.github.com")
+	if u, err := url.Parse(cfg.GitHubAPIURL); err != nil || u.Scheme == "" || u.Host == "" {
+		log.Fatalf("Invalid GITHUB_API_URL: %q", cfg.GitHubAPIURL)
+	}
+	cfg.GitHubAccept = getEnv("GITHUB_ACCEPT", "application/vnd.github.v3+json")
+	// older GitHub Enterprise Server versions don't know the header, so it's opt-in there
+	defaultAPIVersion := ""
+	if cfg.GitHubAPIURL == "https://api.github.com" {
+		defaultAPIVersion = "2022-11-28"
+	}
+	cfg.GitHubAPIVersion = getEnv("GITHUB_API_VERSION", defaultAPIVersion)
+	if cfg.GitHubAppID = getEnv("GITHUB_APP_ID", ""); cfg.GitHubAppID != "" {
+		key, err := parseGitHubAppKey(mustGetSecret("GITHUB_APP_PRIVATE_KEY"))
+		if err != nil {
+			log.Fatalf("Invalid GITHUB_APP_PRIVATE_KEY: %v", err)
+		}
+		cfg.GitHubAppKey = key
+		cfg.GitHubInstallationID = mustGetEnv("GITHUB_INSTALLATION_ID")
+		// the installation token is minted on first use
+		cfg.GitHubUser = getEnv("GITHUB_USER", "x-access-token")
+	} else {
+		cfg.GitHubUser = mustGetEnv("GITHUB_USER")
+		cfg.GitHubToken = mustGetSecret("GITHUB_TOKEN")
+	}
+	cfg.OwnerFilter = splitList(getEnv("OWNER_FILTER", ""))
+	cfg.GitHubOrgs = splitList(getEnv("GITHUB_ORG", ""))
+	cfg.NotifyURL = getEnv("NOTIFY_URL", "")
+	cfg.NotifyFormat = getEnv("NOTIFY_FORMAT", "generic")
+	cfg.GitHubAffiliation = getEnv("GITHUB_AFFILIATION", "owner,organization_member")
+	cfg.GitHubVisibility = getEnv("GITHUB_VISIBILITY", "")
+	cfg.GitHubType = getEnv("GITHUB_TYPE", "")
+	cfg.IncludePatterns = splitList(getEnv("GITHUB_INCLUDE", ""))
+	cfg.ExcludePatterns = splitList(getEnv("GITHUB_EXCLUDE", ""))
+	cfg.FixDefaultBranch = getEnvBool("FIX_DEFAULT_BRANCH", true)
+	visibilityMap, err := parseVisibilityMap(getEnv("VISIBILITY_MAP", ""))
+	if err != nil {
+		log.Fatalf("Invalid VISIBILITY_MAP: %v", err)
+	}
+	cfg.VisibilityMap = visibilityMap
+	cfg.MaxRefs = getEnvInt("MAX_REFS", 0)
+	cfg.MaxRefsAction = getEnv("MAX_REFS_ACTION", "warn")
+	if cfg.MaxRefsAction != "warn" && cfg.MaxRefsAction != "filter" && cfg.MaxRefsAction != "skip" {
+		log.Fatalf("Invalid MAX_REFS_ACTION: %q (expected warn, filter or skip)", cfg.MaxRefsAction)
+	}
+	cfg.MaxRefsFilter = splitList(getEnv("MAX_REFS_FILTER", "refs/pull/"))
+	cfg.PruneMinMissingRuns = getEnvInt("PRUNE_MIN_MISSING_RUNS", 3)
+	if cfg.PruneMinMissingRuns < 1 {
+		log.Fatalf("PRUNE_MIN_MISSING_RUNS must be at least 1")
+	}
+	cfg.QuarantineAfter = getEnvInt("QUARANTINE_AFTER", 3)
+	if cfg.QuarantineAfter < 0 {
+		log.Fatalf("QUARANTINE_AFTER must not be negative")
+	}
+	// every selected target is validated up front, before anything is synced
+	for _, target := range targets {
+		switch target {
+		case "gitlab":
+			cfg.GitLabURL = strings.TrimSuffix(getEnv("GITLAB_URL", "https://gitlab.com"), "/")
+			if u, err := url.Parse(cfg.GitLabURL); err != nil || u.Scheme == "" || u.Host == "" {
+				log.Fatalf("Invalid GITLAB_URL: %q", cfg.GitLabURL)
+			}
+			cfg.GitLabUser = mustGetEnv("GITLAB_USER")
+			cfg.GitLabAuthMode = getEnv("GITLAB_AUTH_MODE", "token")
+			switch cfg.GitLabAuthMode {
+			case "token":
+				cfg.GitLabToken = mustGetSecret("GITLAB_TOKEN")
+				cfg.GitLabAuthScheme = getEnv("GITLAB_AUTH_SCHEME", "private-token")
+				if cfg.GitLabAuthScheme != "private-token" && cfg.GitLabAuthScheme != "bearer" {
+					log.Fatalf("Invalid GITLAB_AUTH_SCHEME: %q (expected private-token or bearer)", cfg.GitLabAuthScheme)
+				}
+			case "job-token":
+				// Provided by GitLab CI to every job
+				cfg.GitLabToken = mustGetEnv("CI_JOB_TOKEN")
+			default:
+				log.Fatalf("Invalid GITLAB_AUTH_MODE: %q (expected token or job-token)", cfg.GitLabAuthMode)
+			}
+			// full path, subgroups included, e.g. acme/backend/team-a
+			cfg.GitLabGroup = strings.Trim(getEnv("GITLAB_GROUP", ""), "/")
+			cfg.GitLabPublicAs = getEnv("GITLAB_PUBLIC_AS", "public")
+			if cfg.GitLabPublicAs != "public" && cfg.GitLabPublicAs != "internal" {
+				log.Fatalf("Invalid GITLAB_PUBLIC_AS: %q (expected public or internal)", cfg.GitLabPublicAs)
+			}
+		case "codeberg":
+			cfg.GiteaURL, cfg.GiteaName = "https://codeberg.org", "Codeberg"
+			cfg.GiteaUser = mustGetEnv("CODEBERG_USER")
+			cfg.GiteaToken = mustGetSecret("CODEBERG_TOKEN")
+			cfg.GiteaOrg = getEnv("CODEBERG_ORG", "")
+		case "gitea":
+			cfg.GiteaURL, cfg.GiteaName = strings.TrimSuffix(mustGetEnv("GITEA_URL"), "/"), "Gitea"
+			if u, err := url.Parse(cfg.GiteaURL); err != nil || u.Scheme == "" || u.Host == "" {
+				log.Fatalf("Invalid GITEA_URL: %q", cfg.GiteaURL)
+			}
+			cfg.GiteaUser = mustGetEnv("GITEA_USER")
+			cfg.GiteaToken = mustGetSecret("GITEA_TOKEN")
+			cfg.GiteaOrg = getEnv("GITEA_ORG", "")
+		case "bitbucket":
+			cfg.BitbucketURL = strings.TrimSuffix(getEnv("BITBUCKET_URL", ""), "/")
+			if cfg.BitbucketURL != "" {
+				if u, err := url.Parse(cfg.BitbucketURL); err != nil || u.Scheme == "" || u.Host == "" {
+					log.Fatalf("Invalid BITBUCKET_URL: %q", cfg.BitbucketURL)
+				}
+				// Data Center: an HTTP access token, pushed with its user's name
+				cfg.BitbucketUser = mustGetEnv("BITBUCKET_USER")
+				cfg.BitbucketToken = mustGetSecret("BITBUCKET_TOKEN")
+				cfg.BitbucketWs = mustGetEnv("BITBUCKET_PROJECT")
+				break
+			}
+			cfg.BitbucketEmail = mustGetEnv("BITBUCKET_EMAIL")
+			cfg.BitbucketToken = mustGetSecret("BITBUCKET_TOKEN")
+			// Workspace is required for Bitbucket API
+			cfg.BitbucketWs = mustGetEnv("BITBUCKET_WORKSPACE")
+			// the same variable as for Data Center, where the project replaces the workspace
+			cfg.BitbucketProject = getEnv("BITBUCKET_PROJECT", "")
+		case "azure":
+			cfg.AzureOrg = mustGetEnv("AZURE_DEVOPS_ORG")
+			cfg.AzureProject = mustGetEnv("AZURE_DEVOPS_PROJECT")
+			cfg.AzureToken = mustGetSecret("AZURE_DEVOPS_TOKEN")
+		case "sourcehut":
+			cfg.SourceHutUser = strings.TrimPrefix(mustGetEnv("SOURCEHUT_USER"), "~")
+			cfg.SourceHutToken = mustGetSecret("SOURCEHUT_TOKEN")
+		case "codecommit":
+			if cfg.CodeCommitRegion = awsRegion(); cfg.CodeCommitRegion == "" {
+				log.Fatalf("Missing AWS region: set AWS_REGION or the region of the profile in ~/.aws/config")
+			}
+			// HTTPS Git credentials of an IAM user; without them git-remote-codecommit is used
+			cfg.CodeCommitGitUser = getEnv("CODECOMMIT_GIT_USER", "")
+			cfg.CodeCommitGitPass = getSecret("CODECOMMIT_GIT_PASSWORD", "")
+			if (cfg.CodeCommitGitUser == "") != (cfg.CodeCommitGitPass == "") {
+				log.Fatalf("CODECOMMIT_GIT_USER and CODECOMMIT_GIT_PASSWORD must be set together")
+			}
+		case "fs":
+			dir, err := filepath.Abs(mustGetEnv("FS_TARGET_DIR"))
+			if err != nil {
+				log.Fatalf("Invalid FS_TARGET_DIR: %v", err)
+			}
+			cfg.FSTargetDir = dir
+		}
+	}
+	// with an org, the repos are created, pushed and pruned there, still with the user's token
+	cfg.GiteaOwner = cfg.GiteaUser
+	if cfg.GiteaOrg != "" {
+		cfg.GiteaOwner = cfg.GiteaOrg
 	}
 	return cfg
 }
 
+// parseVisibilityMap parses rules like "private=private,public=public,archived=private"
+// into a map from source condition to destination visibility.
+func parseVisibilityMap(s string) (map[string]string, error) {
+	rules := make(map[string]string)
+	for _, rule := range splitList(s) {
+		cond, vis, ok := strings.Cut(rule, "=")
+		cond, vis = strings.TrimSpace(cond), strings.TrimSpace(vis)
+		if !ok {
+			return nil, fmt.Errorf("rule %q is not of the form condition=visibility", rule)
+		}
+		switch cond {
+		case "private", "public", "archived":
+		default:
+			return nil, fmt.Errorf("unknown condition %q (expected private, public or archived)", cond)
+		}
+		switch vis {
+		case "private", "internal", "public":
+		default:
+			return nil, fmt.Errorf("unknown visibility %q (expected private, internal or public)", vis)
+		}
+		rules[cond] = vis
+	}
+	return rules, nil
+}
+
+// resolveVisibility determines the destination visibility of repo.
+// VISIBILITY_MAP rules win over REPO_VISIBILITY, and the "archived" rule
+// wins over the "private"/"public" rules since it is the more specific one.
+func resolveVisibility(repo GitHubRepo) string {
+	if vis, ok := config.VisibilityMap["archived"]; ok && repo.Archived {
+		return vis
+	}
+	cond := "public"
+	if repo.Private {
+		cond = "private"
+	}
+	if vis, ok := config.VisibilityMap[cond]; ok {
+		return vis
+	}
+	if config.RepoVisibility == "auto" {
+		return cond
+	} else if config.RepoVisibility != "" {
+		return config.RepoVisibility
+	}
+	return "private"
+}
+
 func getEnv(key, defaultVal string) string {
-	if val := os.Getenv(key); val != "" {
+	if val := lookupEnv(key); val != "" {
 		return val
 	}
 	return defaultVal
 }
 
+func getEnvInt(key string, defaultVal int) int {
+	val := lookupEnv(key)
+	if val == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Fatalf("Environment variable %s must be an integer, got %q.", key, val)
+	}
+	return n
+}
+
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	val := lookupEnv(key)
+	if val == "" {
+		return defaultVal
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d < 0 {
+		log.Fatalf("Environment variable %s must be a duration like 30s or 2m, got %q.", key, val)
+	}
+	return d
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	val := lookupEnv(key)
+	if val == "" {
+		return defaultVal
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		log.Fatalf("Environment variable %s must be a boolean, got %q.", key, val)
+	}
+	return b
+}
+
 func mustGetEnv(key string) string {
-	if val := os.Getenv(key); val != "" {
+	if val := lookupEnv(key); val != "" {
 		return val
 	}
-	log.Fatalf("Environment variable %s is not set.", key)
+	log.Fatalf("Environment variable %s is not set (neither in the -config file).", key)
 	return ""
 }
 
+// getSecret is getEnv for tokens and passwords, which can also be read from the file
+// named by <key>_FILE, e.g. GITHUB_TOKEN_FILE=/run/secrets/github_token as with Docker
+// and Kubernetes secrets. The file wins over the variable itself; a trailing newline is dropped.
+func getSecret(key, defaultVal string) string {
+	if path := lookupEnv(key + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read %s_FILE: %v", key, err)
+		}
+		if val := strings.TrimRight(string(data), "\r\n"); val != "" {
+			return val
+		}
+		log.Fatalf("%s_FILE=%s is empty", key, path)
+	}
+	return getEnv(key, defaultVal)
+}
+
+// mustGetSecret is mustGetEnv for tokens and passwords, see getSecret.
+func mustGetSecret(key string) string {
+	if val := getSecret(key, ""); val != "" {
+		return val
+	}
+	log.Fatalf("Environment variable %s (or %s_FILE) is not set (neither in the -config file).", key, key)
+	return ""
+}
+
+// applyDirFlags overrides the backup and logs dirs with the flags, if set,
+// and makes sure both are writable before anything is fetched.
+func applyDirFlags(backupDir, logsDir string) {
+	if backupDir != "" {
+		config.BackupDir = backupDir
+	}
+	if logsDir != "" {
+		config.LogsFolder = logsDir
+	}
+	for _, dir := range []string{config.BackupDir, config.LogsFolder} {
+		if err := ensureWritableDir(dir); err != nil {
+			log.Fatalf("Directory %s is not writable: %v", dir, err)
+		}
+	}
+}
+
+// applyOutputDir sets the run dir below -output-dir (OUTPUT_DIR), <output-dir>/<targets>/<run-id>,
+// e.g. ./runs/gitlab+gitea/20060102_150405-1a2b3c, and makes sure it is writable.
+func applyOutputDir(outputDir string, targets []string) {
+	if outputDir != "" {
+		config.OutputDir = outputDir
+	}
+	if config.OutputDir == "" {
+		return
+	}
+	name := strings.Join(targets, "+")
+	switch {
+	case len(targets) == 0:
+		name = "maintenance"
+	case config.Direction == "target-to-github":
+		// like the mirrors in <backup-dir>/from-<target>
+		name = "from-" + name
+	}
+	config.RunDir = filepath.Join(config.OutputDir, name, runID)
+	if err := ensureWritableDir(config.RunDir); err != nil {
+		log.Fatalf("Directory %s is not writable: %v", config.RunDir, err)
+	}
+}
+
+// runFile returns the path of a file of this run: name in the run dir with -output-dir,
+// else legacy in the logs dir, whose names carry the run ID where needed.
+func runFile(legacy, name string) string {
+	if config.RunDir != "" {
+		return filepath.Join(config.RunDir, name)
+	}
+	return filepath.Join(config.LogsFolder, legacy)
+}
+
+// logFilePath is the log file of this run, set by setupLogger.
+var logFilePath string
+
 func setupLogger() {
 	os.MkdirAll(config.LogsFolder, 0755)
-	timestamp := time.Now().Format("20060102_150405")
-	logFilePath := filepath.Join(config.LogsFolder, fmt.Sprintf("logs_%s.txt", timestamp))
+	logFilePath = runFile(fmt.Sprintf("logs_%s.txt", runID), "log.txt")
 	file, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.SetOutput(file)
+	var out io.Writer = file
+	if config.Verbose {
+		out = io.MultiWriter(file, os.Stderr)
+	}
+	if config.LogFormat == "json" {
+		// the timestamp is part of each JSON object
+		jsonLog = &jsonLogWriter{w: out}
+		log.SetOutput(jsonLog)
+		log.SetFlags(0)
+		return
+	}
+	log.SetOutput(out)
 	log.SetFlags(log.LstdFlags)
 }
 
 func main() {
-	target := flag.String("target", "", "sync target: gitlab | codeberg | bitbucket")
+	// Make sure credentials get scrubbed even if the run panics
+	defer func() {
+		if r := recover(); r != nil {
+			runCleanups()
+			panic(r)
+		}
+	}()
+	defer runCleanups()
+	handleSignals()
+
+	configFile := flag.String("config", "", "YAML file of settings keyed by environment variable name, e.g. github_user: octocat (env wins)")
+	target := flag.String("target", "", "sync target(s), comma-separated: gitlab | gitea | codeberg | bitbucket | azure | sourcehut | codecommit | fs | local")
 	repoFilter := flag.String("repo", "", "if set, only sync this specific GitHub repo (test mode)")
+	maxRepos := flag.Int("max-repos", 0, "only sync the first N repos after filtering, e.g. to try a big account with -dry-run first (0 = all)")
+	reposFrom := flag.String("repos-from", "", "file of the GitHub repos to sync, one name per line (# comments allowed)")
+	checkSecurity := flag.Bool("check-security", false, "warn about GitHub security settings (secret scanning, dependabot alerts) that are not mirrored")
+	dumpSecurity := flag.Bool("dump-security", false, "like -check-security, and also save the settings to <backup-dir>/<repo>.security.json")
+	org := flag.String("org", "", "comma-separated GitHub organizations to list instead of your own repos (overrides GITHUB_ORG)")
+	affiliation := flag.String("github-affiliation", "", "which of your GitHub repos are listed, comma-separated owner, collaborator, organization_member (overrides GITHUB_AFFILIATION, default owner,organization_member, which includes the repos of your organizations; use owner for only your own)")
+	ghVisibility := flag.String("github-visibility", "", "list only all | public | private GitHub repos (overrides GITHUB_VISIBILITY)")
+	ghType := flag.String("github-type", "", "list GitHub repos by all | owner | public | private | member instead of affiliation (overrides GITHUB_TYPE)")
+	include := flag.String("include", "", "comma-separated glob patterns of repo names to sync (overrides GITHUB_INCLUDE)")
+	exclude := flag.String("exclude", "", "comma-separated glob patterns of repo names to skip (overrides GITHUB_EXCLUDE)")
+	includeForks := flag.Bool("include-forks", false, "also mirror repos that are forks")
+	onlyPrivate := flag.Bool("only-private", false, "only sync private GitHub repos")
+	onlyPublic := flag.Bool("only-public", false, "only sync public GitHub repos")
+	includeGists := flag.Bool("include-gists", false, "also mirror your gists, as repos named gist-<id> (secret gists need the gist scope)")
+	trace := flag.Bool("trace", false, "record per-repo timings of each phase into <logs>/trace_<run-id>.json (trace.json in the -output-dir run dir)")
+	dryRun := flag.Bool("dry-run", false, "only log what would be cloned, created, updated and pushed, without changing anything")
+	pruneRemoteFlag := flag.Bool("prune-remote", false, "delete destination repos whose GitHub repo was deleted (see PRUNE_MIN_MISSING_RUNS)")
+	pruneArchive := flag.Bool("prune-archive", false, "like -prune-remote, but archive instead of delete (not supported by Bitbucket and SourceHut)")
+	assumeYes := flag.Bool("yes", false, "don't ask for confirmation before pruning, confirms everything of -interactive")
+	interactive := flag.Bool("interactive", false, "ask (y/N) before changing the visibility of existing target repos, unprotecting branches and pruning each repo")
+	apiSleep := flag.String("api-sleep", "", "minimum pause between two API calls to a target, e.g. 1s, 0 disables it (overrides API_SLEEP, default 500ms)")
+	runDeadlineFlag := flag.Duration("run-deadline", 0, "stop starting new repos this long after the start, e.g. 50m for an hourly job, and end with the summary of the synced ones (0 = no limit)")
+	gitTimeout := flag.Duration("git-timeout", 30*time.Minute, "maximum duration of each git command (clone, fetch, push, ...), 0 for no limit")
+	maxLogBody := flag.Int("max-log-body", 4096, "truncate logged HTTP bodies to this many bytes, 0 for no limit")
+	noLogBody := flag.Bool("no-log-body", false, "don't log HTTP request and response bodies at all")
+	maxRepoSize := flag.Int("max-repo-size-mb", 0, "skip repos larger than this many MB according to GitHub, 0 for no limit")
+	direction := flag.String("direction", "github-to-target", "github-to-target, or target-to-github to mirror the repos of the one -target (gitlab, gitea, codeberg or bitbucket) to GitHub")
+	syncDefaultBranch := flag.Bool("sync-default-branch", false, "align the default branch of the destination repos with GitHub's even if FIX_DEFAULT_BRANCH=false")
+	partialClone := flag.Bool("partial-clone", false, "clone new mirrors with --filter=blob:none, the blobs are fetched when pushing (faster first clone of huge repos)")
+	resetQuarantine := flag.String("reset-quarantine", "", "sync the repos quarantined after failed reclones again: comma-separated names, or all (see QUARANTINE_AFTER)")
+	stripPRRefs := flag.Bool("strip-pr-refs", true, "remove GitHub's refs/pull/* from the mirrors before pushing")
+	verifyPushFlag := flag.Bool("verify-push", false, "after each push, compare the refs of the destination (git ls-remote) with the mirror and fail on differences")
+	forceUnprotect := flag.Bool("force-unprotect", false, "when GitLab rejects the push of protected branches, unprotect them via the API, push again and protect them again (needs the Maintainer role)")
+	var gitConfig listFlag
+	flag.Var(&gitConfig, "git-config", "git config key=value passed as -c to every git command, repeatable, e.g. -git-config http.postBuffer=524288000 -git-config pack.threads=2")
+	var refspecs listFlag
+	flag.Var(&refspecs, "refspec", "push only these branches or refs instead of push --mirror, repeatable, e.g. -refspec main -refspec 'release/*' -refspec 'refs/tags/*'; refs deleted on GitHub are then kept on the destination")
+	skipEmpty := flag.Bool("skip-empty", false, "don't create repos without any commit on the targets")
+	syncWiki := flag.Bool("sync-wiki", false, "also mirror the GitHub wiki of each repo to the GitLab or Gitea/Codeberg wiki")
+	syncReleases := flag.Bool("sync-releases", false, "also copy the published GitHub releases and their assets to GitLab and Gitea/Codeberg")
+	syncProperties := flag.Bool("sync-properties", false, "also copy the custom properties of GitHub org repos to GitLab, as project labels <property>::<value>")
+	syncTopics := flag.Bool("sync-topics", false, "also copy the GitHub topics to GitLab and Gitea/Codeberg (Bitbucket has none)")
+	failFast := flag.Bool("fail-fast", false, "stop at the first repo that fails instead of continuing with the others")
+	logFormat := flag.String("log-format", "text", "log file format: text | json (one object per line)")
+	quiet := flag.Bool("quiet", false, "don't print a progress line per repo to stderr")
+	verbose := flag.Bool("verbose", false, "also echo the full log to stderr")
+	verboseGit := flag.Bool("verbose-git", false, "run git clone, fetch and push with --verbose --progress, for debugging")
+	quietGit := flag.Bool("quiet-git", false, "run git clone, fetch and push with --quiet (no progress) to keep the log short")
+	metricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics on this address during the run, e.g. :9090")
+	metricsPushURL := flag.String("metrics-push-url", "", "push the Prometheus metrics to this Pushgateway at the end of the run, e.g. http://pushgateway:9091")
+	notifyURL := flag.String("notify-url", "", "webhook to POST the outcome of the run to (overrides NOTIFY_URL)")
+	notifyFormat := flag.String("notify-format", "", "payload of -notify-url: generic | slack | discord (overrides NOTIFY_FORMAT, default generic)")
+	since := flag.Duration("since", 0, "only sync repos pushed to within this duration, e.g. 24h")
+	sinceLastRun := flag.Bool("since-last-run", false, "only sync repos pushed to since the start of the last run in which every repo synced")
+	force := flag.Bool("force", false, "fetch and push every repo, even those not pushed to on GitHub since they were last synced (e.g. after changing the refs on a target by hand); their description, topics, wiki and releases are synced either way")
+	exportBundleFlag := flag.Bool("export-bundle", false, "also write each mirror as <export-dir>/<repo>_<run-id>.bundle")
+	exportDir := flag.String("export-dir", "", "directory of -export-bundle (overrides EXPORT_DIR, default <backup-dir>/bundles)")
+	backupDir := flag.String("backup-dir", "", "directory of the mirror clones and manifests (overrides BACKUP_DIR, default ./repos-backup)")
+	logsDir := flag.String("logs-dir", "", "directory of the log files, reports and state (overrides LOGS_DIR, default ./logs)")
+	outputDir := flag.String("output-dir", "", "write the log, summary.json, failures.txt and trace of each run to <dir>/<targets>/<run-id>/ instead of the logs dir (overrides OUTPUT_DIR)")
+	bitbucketServer := flag.String("bitbucket-server", "", "base URL of a Bitbucket Data Center instance to use instead of Bitbucket Cloud (overrides BITBUCKET_URL)")
+	namePrefix := flag.String("name-prefix", "", "prepend this to the name of every destination repo, e.g. gh-mirror-")
+	nameSuffix := flag.String("name-suffix", "", "append this to the name of every destination repo")
+	nameReplace := flag.String("name-replace", "", "rename destination repos with a regular expression, as from=to (split at the first =), before -name-prefix/-name-suffix")
+	list := flag.Bool("list", false, "print the GitHub repos that would be synced with the current filters and exit, no -target needed")
+	listFormat := flag.String("list-format", "table", "output of -list: table | json")
+	selfTest := flag.Bool("self-test", false, "check the auth and permissions of GitHub and each -target on a throwaway repo (created, pushed and deleted), print the outcome and exit")
+	clean := flag.Bool("clean", false, "remove the mirrors in the backup dir of repos deleted from GitHub and exit, no -target needed (see -dry-run, -yes)")
+	maintenance := flag.Bool("maintenance", false, "repack all mirrors in the backup dir; runs after the sync when combined with -target")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "\nUsage: %s -target {gitlab|codeberg|bitbucket}\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nUsage: %s -target {gitlab|gitea|codeberg|bitbucket|azure|sourcehut|codecommit|local}[,...] [-maintenance]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -maintenance\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -list [-list-format json]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -clean [-dry-run]\n", os.Args[0])
 		flag.PrintDefaults()
 		fmt.Fprintln(os.Stderr)
 		fmt.Fprintln(os.Stderr, "Targets and required environment:")
-		fmt.Fprintln(os.Stderr, "  gitlab   -> requires GITLAB_USER, GITLAB_TOKEN; optional GITLAB_GROUP")
-		fmt.Fprintln(os.Stderr, "  codeberg -> requires CODEBERG_USER, CODEBERG_TOKEN")
-		fmt.Fprintln(os.Stderr, "  bitbucket-> requires BITBUCKET_EMAIL, BITBUCKET_TOKEN, BITBUCKET_WORKSPACE")
-		fmt.Fprintln(os.Stderr, "Always required:")
+		fmt.Fprintln(os.Stderr, "  gitlab   -> requires GITLAB_USER, GITLAB_TOKEN; optional GITLAB_GROUP, GITLAB_URL (default https://gitlab.com)")
+		fmt.Fprintln(os.Stderr, "              (GITLAB_AUTH_MODE=job-token uses CI_JOB_TOKEN instead of GITLAB_TOKEN)")
+		fmt.Fprintln(os.Stderr, "              (GITLAB_PUBLIC_AS=internal mirrors public repos as internal projects)")
+		fmt.Fprintln(os.Stderr, "              (GITLAB_AUTH_SCHEME=bearer sends GITLAB_TOKEN as Authorization: Bearer, e.g. for OAuth tokens)")
+		fmt.Fprintln(os.Stderr, "  gitea    -> requires GITEA_URL (e.g. https://git.example.com), GITEA_USER, GITEA_TOKEN; optional GITEA_ORG; also Forgejo")
+		fmt.Fprintln(os.Stderr, "  codeberg -> requires CODEBERG_USER, CODEBERG_TOKEN; optional CODEBERG_ORG (gitea with GITEA_URL=https://codeberg.org)")
+		fmt.Fprintln(os.Stderr, "  bitbucket-> requires BITBUCKET_EMAIL, BITBUCKET_TOKEN, BITBUCKET_WORKSPACE; optional BITBUCKET_PROJECT")
+		fmt.Fprintln(os.Stderr, "              (Data Center: BITBUCKET_URL or -bitbucket-server, BITBUCKET_USER, BITBUCKET_TOKEN, BITBUCKET_PROJECT)")
+		fmt.Fprintln(os.Stderr, "  azure    -> requires AZURE_DEVOPS_ORG, AZURE_DEVOPS_PROJECT, AZURE_DEVOPS_TOKEN")
+		fmt.Fprintln(os.Stderr, "  sourcehut-> requires SOURCEHUT_USER, SOURCEHUT_TOKEN and an SSH key registered on meta.sr.ht")
+		fmt.Fprintln(os.Stderr, "  codecommit-> requires AWS credentials (env, AWS_PROFILE or a role) and AWS_REGION; pushes with")
+		fmt.Fprintln(os.Stderr, "              CODECOMMIT_GIT_USER/CODECOMMIT_GIT_PASSWORD if set, else git-remote-codecommit")
+		fmt.Fprintln(os.Stderr, "  fs       -> requires FS_TARGET_DIR: pushes to bare repos <dir>/<repo>.git, e.g. on a NAS mount")
+		fmt.Fprintln(os.Stderr, "  local    -> only keeps the mirror clones in the backup dir, pushes nowhere")
+		fmt.Fprintln(os.Stderr, "Always required (except for a standalone -maintenance run):")
 		fmt.Fprintln(os.Stderr, "  GITHUB_USER, GITHUB_TOKEN")
+		fmt.Fprintln(os.Stderr, "  or, as a GitHub App: GITHUB_APP_ID, GITHUB_APP_PRIVATE_KEY (PEM or path), GITHUB_INSTALLATION_ID")
+		fmt.Fprintln(os.Stderr, "  (any token or password can be read from a file with <VAR>_FILE, e.g. GITHUB_TOKEN_FILE)")
 		fmt.Fprintln(os.Stderr, "Optional:")
+		fmt.Fprintln(os.Stderr, "  BACKUP_DIR (default ./repos-backup), LOGS_DIR (default ./logs), see -backup-dir/-logs-dir")
+		fmt.Fprintln(os.Stderr, "  OUTPUT_DIR (a dir per run, <dir>/<targets>/<run-id>/, instead of the logs dir), see -output-dir")
+		fmt.Fprintln(os.Stderr, "  EXPORT_DIR (default <backup-dir>/bundles), see -export-bundle")
+		fmt.Fprintln(os.Stderr, "  GITHUB_API_URL (default https://api.github.com, e.g. https://ghe.example.com/api/v3)")
+		fmt.Fprintln(os.Stderr, "  GITHUB_ACCEPT (default application/vnd.github.v3+json), GITHUB_API_VERSION (X-GitHub-Api-Version, default 2022-11-28 on github.com, none on GHE)")
 		fmt.Fprintln(os.Stderr, "  REPO_VISIBILITY (auto|public|private), default=auto")
+		fmt.Fprintln(os.Stderr, "  VISIBILITY_MAP (e.g. private=private,public=internal,archived=private), overrides REPO_VISIBILITY")
+		fmt.Fprintln(os.Stderr, "  MAX_RETRIES (default 3): retries of API calls failing with 429/5xx or connection errors")
+		fmt.Fprintln(os.Stderr, "  HTTPS_PROXY (or HTTP_PROXY), NO_PROXY: proxy of the API calls and git (passed as -c http.proxy)")
+		fmt.Fprintln(os.Stderr, "  GITHUB_PROXY, GITLAB_PROXY, BITBUCKET_PROXY, GITEA_PROXY, ...: proxy of the API calls of one service instead")
+		fmt.Fprintln(os.Stderr, "  HTTP_TIMEOUT (default 30s, 0 for none): limit of each API call; git commands have -git-timeout instead")
+		fmt.Fprintln(os.Stderr, "  API_SLEEP (default 500ms, 0 disables throttling): minimum pause between two API calls to a target, see -api-sleep")
+		fmt.Fprintln(os.Stderr, "  NOTIFY_URL, NOTIFY_FORMAT (generic|slack|discord, default generic): webhook notified at the end, see -notify-url")
+		fmt.Fprintln(os.Stderr, "  OWNER_FILTER (comma-separated GitHub owners/orgs to keep)")
+		fmt.Fprintln(os.Stderr, "  GITHUB_ORG (comma-separated organizations, lists /orgs/{org}/repos instead of your repos; GITHUB_TYPE then is all|public|private|forks|sources|member)")
+		fmt.Fprintln(os.Stderr, "  GITHUB_AFFILIATION (default owner,organization_member; add collaborator for repos you were invited to)")
+		fmt.Fprintln(os.Stderr, "  GITHUB_VISIBILITY (all|public|private, default all), GITHUB_TYPE (all|owner|public|private|member, replaces the affiliation and can't be combined with the visibility)")
+		fmt.Fprintln(os.Stderr, "  GITHUB_INCLUDE, GITHUB_EXCLUDE (comma-separated glob patterns, see -include/-exclude)")
+		fmt.Fprintln(os.Stderr, "  MAX_REFS, MAX_REFS_ACTION (warn|filter|skip), MAX_REFS_FILTER (default refs/pull/)")
+		fmt.Fprintln(os.Stderr, "  FIX_DEFAULT_BRANCH (true|false), default=true: align the destination default branch with GitHub's, see -sync-default-branch")
+		fmt.Fprintln(os.Stderr, "  PRUNE_MIN_MISSING_RUNS (default 3): successful runs a repo must be missing from GitHub before -prune-remote removes it")
+		fmt.Fprintln(os.Stderr, "  QUARANTINE_AFTER (default 3, 0 for never): failed reclones in a row after which a repo is skipped until -reset-quarantine")
+		fmt.Fprintln(os.Stderr, "  DEST_ADDED_TOPICS (comma-separated topics added on GitLab/Gitea/Codeberg, e.g. source-github,mirror)")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Exit status:")
+		fmt.Fprintln(os.Stderr, "  0  all repos synced (or skipped, e.g. SAML SSO or MAX_REFS_ACTION=skip)")
+		fmt.Fprintln(os.Stderr, "  1  at least one repo failed to mirror, validate or push; or a fatal error")
+		fmt.Fprintln(os.Stderr, "  2  invalid usage")
+		fmt.Fprintln(os.Stderr, "  130  interrupted")
 
 	}
 	flag.Parse()
-	if *target != "gitlab" && *target != "codeberg" && *target != "bitbucket" {
-		fmt.Fprintf(os.Stderr, "Invalid -target: %q\n\n", *target)
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile); err != nil {
+			log.Fatalf("Failed to read -config: %v", err)
+		}
+	}
+	if *runDeadlineFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -run-deadline: %v, it must not be negative\n\n", *runDeadlineFlag)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *logFormat != "text" && *logFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Invalid -log-format: %q\n\n", *logFormat)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *listFormat != "table" && *listFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Invalid -list-format: %q\n\n", *listFormat)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *onlyPrivate && *onlyPublic {
+		fmt.Fprintf(os.Stderr, "-only-private and -only-public can't be combined\n\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *quiet && *verbose {
+		fmt.Fprintf(os.Stderr, "-quiet and -verbose can't be combined\n\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *verboseGit && *quietGit {
+		fmt.Fprintf(os.Stderr, "-verbose-git and -quiet-git can't be combined\n\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *verboseGit {
+		config.GitVerbosity = "verbose"
+	} else if *quietGit {
+		config.GitVerbosity = "quiet"
+	}
+	for _, kv := range gitConfig {
+		if err := checkGitConfigOption(kv); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -git-config: %v\n\n", err)
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+	targets := splitList(*target)
+	if *maintenance && len(targets) == 0 {
+		config = loadConfig(nil, false)
+		config.DryRun = *dryRun
+		config.LogFormat = *logFormat
+		config.Quiet, config.Verbose = *quiet, *verbose
+		config.GitTimeout = *gitTimeout
+		config.GitConfig = gitConfig
+		applyDirFlags(*backupDir, *logsDir)
+		applyOutputDir(*outputDir, nil)
+		setupLogger()
+		log.Printf("🔔 Logger started (run %s)", runID)
+		if err := runMaintenance(); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *maxRepos < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -max-repos: %d\n\n", *maxRepos)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *reposFrom != "" && *repoFilter != "" {
+		fmt.Fprintf(os.Stderr, "-repos-from and -repo can't be combined\n\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if len(targets) == 0 && !*list && !*clean {
+		fmt.Fprintf(os.Stderr, "Missing -target\n\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+	for i, t := range targets {
+		if t != "gitlab" && t != "gitea" && t != "codeberg" && t != "bitbucket" && t != "azure" && t != "sourcehut" && t != "codecommit" && t != "fs" && t != "local" {
+			fmt.Fprintf(os.Stderr, "Invalid -target: %q\n\n", t)
+			flag.Usage()
+			os.Exit(2)
+		}
+		if Contains(targets[:i], t) {
+			fmt.Fprintf(os.Stderr, "Duplicate -target: %q\n\n", t)
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+
+	switch *direction {
+	case "github-to-target":
+	case "target-to-github":
+		if len(targets) != 1 || !Contains([]string{"gitlab", "gitea", "codeberg", "bitbucket"}, targets[0]) {
+			fmt.Fprintf(os.Stderr, "-direction target-to-github needs exactly one -target of gitlab, gitea, codeberg or bitbucket\n\n")
+			flag.Usage()
+			os.Exit(2)
+		}
+		if *pruneRemoteFlag || *pruneArchive {
+			fmt.Fprintf(os.Stderr, "-prune-remote and -prune-archive can't be combined with -direction target-to-github\n\n")
+			flag.Usage()
+			os.Exit(2)
+		}
+		if *namePrefix != "" || *nameSuffix != "" || *nameReplace != "" {
+			fmt.Fprintf(os.Stderr, "-name-prefix, -name-suffix and -name-replace can't be combined with -direction target-to-github\n\n")
+			flag.Usage()
+			os.Exit(2)
+		}
+		if *reposFrom != "" {
+			fmt.Fprintf(os.Stderr, "-repos-from can't be combined with -direction target-to-github, use -repo\n\n")
+			flag.Usage()
+			os.Exit(2)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid -direction: %q\n\n", *direction)
 		flag.Usage()
 		os.Exit(2)
 	}
 
-	config = loadConfig(*target)
+	if *selfTest && (*dryRun || *direction != "github-to-target") {
+		fmt.Fprintf(os.Stderr, "-self-test can't be combined with -dry-run or -direction target-to-github\n\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// both are served by the same Gitea config
+	if Contains(targets, "gitea") && Contains(targets, "codeberg") {
+		fmt.Fprintf(os.Stderr, "-target gitea and codeberg can't be combined\n\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *bitbucketServer != "" {
+		// loadConfig picks the Data Center settings by BITBUCKET_URL
+		os.Setenv("BITBUCKET_URL", *bitbucketServer)
+	}
+	config = loadConfig(targets, true)
+	applyHTTPTimeout()
+	if err := configureProxy(); err != nil {
+		log.Fatalf("Invalid proxy: %v", err)
+	}
+	config.CheckSecurity = *checkSecurity || *dumpSecurity
+	config.DumpSecurity = *dumpSecurity
+	config.Trace = *trace
+	config.DryRun = *dryRun
+	config.IncludeForks = *includeForks
+	config.IncludeGists = *includeGists
+	if *onlyPrivate {
+		config.OnlyVisibility = "private"
+	} else if *onlyPublic {
+		config.OnlyVisibility = "public"
+	}
+	config.PruneArchive = *pruneArchive
+	config.AssumeYes = *assumeYes
+	config.Interactive = *interactive
+	config.LogFormat = *logFormat
+	config.Quiet, config.Verbose = *quiet, *verbose
+	if *notifyURL != "" {
+		config.NotifyURL = *notifyURL
+	}
+	if *apiSleep != "" {
+		d, err := time.ParseDuration(*apiSleep)
+		if err != nil || d < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid -api-sleep: %q (expected a duration like 500ms or 2s)\n", *apiSleep)
+			flag.Usage()
+			os.Exit(2)
+		}
+		config.SleepBetweenAPI = d
+	}
+	if *notifyFormat != "" {
+		config.NotifyFormat = *notifyFormat
+	}
+	switch config.NotifyFormat {
+	case "generic", "slack", "discord":
+	default:
+		log.Fatalf("Invalid -notify-format: %q (expected generic, slack or discord)", config.NotifyFormat)
+	}
+	config.FailFast = *failFast
+	config.GitTimeout = *gitTimeout
+	config.MaxLogBody = *maxLogBody
+	config.LogBodies = !*noLogBody
+	config.MaxRepoSizeMB = *maxRepoSize
+	config.SyncTopics = *syncTopics
+	config.SyncReleases = *syncReleases
+	config.SyncProperties = *syncProperties
+	config.SyncWiki = *syncWiki
+	config.SkipEmpty = *skipEmpty
+	config.StripPullRefs = *stripPRRefs
+	config.PartialClone = *partialClone
+	config.VerifyPush = *verifyPushFlag
+	config.MetricsPushURL = *metricsPushURL
+	config.ForceUnprotect = *forceUnprotect
+	config.GitConfig = gitConfig
+	for _, r := range refspecs {
+		spec, err := normalizeRefspec(r)
+		if err != nil {
+			log.Fatalf("Invalid -refspec: %v", err)
+		}
+		config.Refspecs = append(config.Refspecs, spec)
+	}
+	if *syncDefaultBranch {
+		config.FixDefaultBranch = true
+	}
+	config.Direction = *direction
+	config.NamePrefix, config.NameSuffix = *namePrefix, *nameSuffix
+	if *nameReplace != "" {
+		re, with, err := parseNameReplace(*nameReplace)
+		if err != nil {
+			log.Fatalf("Invalid -name-replace: %v", err)
+		}
+		config.NameReplace, config.NameReplaceWith = re, with
+	}
+	config.ExportBundle = *exportBundleFlag
+	config.Since = *since
+	config.SinceLastRun = *sinceLastRun
+	config.Force = *force
+	if config.Since != 0 && config.SinceLastRun {
+		log.Fatalf("-since and -since-last-run can't be combined")
+	}
+	if *exportDir != "" {
+		config.ExportDir = *exportDir
+	}
+	if *org != "" {
+		config.GitHubOrgs = splitList(*org)
+	}
+	if githubApp() {
+		// both go through the endpoints of the authenticated user, which installation tokens can't use
+		if config.IncludeGists {
+			log.Fatalf("-include-gists needs GITHUB_USER/GITHUB_TOKEN, gists aren't accessible to a GitHub App")
+		}
+		if config.Direction == "target-to-github" && len(config.GitHubOrgs) == 0 {
+			log.Fatalf("-direction target-to-github with a GitHub App needs GITHUB_ORG to create the repos in")
+		}
+	}
+	if *affiliation != "" {
+		config.GitHubAffiliation = *affiliation
+	}
+	if *ghVisibility != "" {
+		config.GitHubVisibility = *ghVisibility
+	}
+	if *ghType != "" {
+		config.GitHubType = *ghType
+	}
+	if err := validateListingParams(); err != nil {
+		log.Fatalf("Invalid GitHub listing: %v", err)
+	}
+	if *include != "" {
+		config.IncludePatterns = splitList(*include)
+	}
+	if *exclude != "" {
+		config.ExcludePatterns = splitList(*exclude)
+	}
+	for _, patterns := range [][]string{config.IncludePatterns, config.ExcludePatterns} {
+		if err := validatePatterns(patterns); err != nil {
+			log.Fatalf("Invalid -include/-exclude: %v", err)
+		}
+	}
+	var repoList []string
+	if *reposFrom != "" {
+		var err error
+		if repoList, err = readRepoList(*reposFrom); err != nil {
+			log.Fatalf("Invalid -repos-from: %v", err)
+		}
+	}
+	applyDirFlags(*backupDir, *logsDir)
+	applyOutputDir(*outputDir, targets)
+	if config.ExportDir == "" {
+		config.ExportDir = filepath.Join(config.BackupDir, "bundles")
+	}
 	// before this line, the logger will print to stdout
 	setupLogger()
 	// after this line, all logs will go to the log file
-	log.Printf("🔔 Logger started")
+	log.Printf("🔔 Logger started (run %s)", runID)
 	log.Printf("🕒 Timestamp: %s", time.Now().Format("2006-01-02 15:04:05"))
+	config.RunDeadline = *runDeadlineFlag
+	startRunDeadline(config.RunDeadline)
+	if config.DryRun {
+		log.Printf("🧪 DRY RUN: nothing will be cloned, created, updated or pushed")
+	}
 
+	if *selfTest {
+		if err := runSelfTest(os.Stdout, targets); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	startRunSpan()
+	if *metricsAddr != "" {
+		if err := startMetricsServer(*metricsAddr); err != nil {
+			fatalf("🚫 Failed to start the metrics server: %v", err)
+		}
+	}
+	if err := preflight(targets); err != nil {
+		fatalf("🚫 %v", err)
+	}
+	if config.Direction == "target-to-github" {
+		runReverse(targets[0], *repoFilter)
+		return
+	}
+	listingStart := time.Now()
 	repos, err := getGitHubRepos()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	if config.IncludeGists {
+		gists, err := getGitHubGists()
+		if err != nil {
+			fatalf("🚫 Failed to list gists: %v", err)
+		}
+		repos = append(repos, gists...)
+	}
+	listingTrace = time.Since(listingStart)
+	if err := loadState(); err != nil {
+		fatalf("🚫 Failed to load state: %v", err)
+	}
+	if *resetQuarantine != "" {
+		reset := state.resetQuarantine(splitList(*resetQuarantine))
+		log.Printf("🚧 Lifted the quarantine of %d repo(s): %s", len(reset), strings.Join(reset, ", "))
+	}
+	// -list and -clean must not count as a listing for prune
+	if *clean {
+		if err := runClean(Map(repos, func(r GitHubRepo) string { return r.Name })); err != nil {
+			fatalf("🚫 Failed to clean %s: %v", config.BackupDir, err)
+		}
+		return
+	}
+	if *list {
+		listed := applyFilters(repos)
+		if len(repoList) > 0 {
+			if listed, err = filterByNames(listed, repos, repoList); err != nil {
+				fatalf("🚫 Invalid -repos-from %s: %v", *reposFrom, err)
+			}
+		}
+		if err := printRepoList(os.Stdout, limitRepos(listed, *maxRepos), *listFormat); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	state.recordListing(repos)
+	// prune must compare against the complete listing, not the filtered one
+	githubNames := Map(repos, func(r GitHubRepo) string { return r.Name })
+	// a dry run must not count towards the runs a repo has been missing for
+	if !config.DryRun {
+		if err := saveState(); err != nil {
+			log.Printf("⚠️ Failed to save state: %v", err)
+		}
+	}
+	all := repos
+	repos = applyFilters(repos)
+	if len(repoList) > 0 {
+		if repos, err = filterByNames(repos, all, repoList); err != nil {
+			fatalf("🚫 Invalid -repos-from %s: %v", *reposFrom, err)
+		}
+		log.Printf("📦 Will sync %d of the %d repositories in %s", len(repos), len(repoList), *reposFrom)
 	}
 	// Test mode: filter to a specific repo if flag provided
 	if *repoFilter != "" {
@@ -137,7 +1065,7 @@ func main() {
 			}
 		}
 		if len(filtered) == 0 {
-			log.Fatalf("Test mode: repository %s not found among GitHub repos", *repoFilter)
+			fatalf("Test mode: repository %s not found among GitHub repos", *repoFilter)
 		}
 		repos = filtered
 
@@ -146,11 +1074,14 @@ func main() {
 			Map(repos, func(r GitHubRepo) string { return r.Name }), ", "))
 
 	}
-	var gitlabGroupID *int
-	if *target == "gitlab" {
-		gitlabGroupID, err = getGitLabGroupID()
-		if err != nil {
-			log.Fatal(err)
+	repos = limitRepos(repos, *maxRepos)
+	if err := checkTargetNames(repos); err != nil {
+		fatalf("🚫 Invalid -name-prefix/-name-suffix/-name-replace: %v", err)
+	}
+	repos = dropNameCollisions(repos, targets)
+	if Contains(targets, "gitlab") {
+		if err := loadGitLabNamespace(); err != nil {
+			fatal(err)
 		}
 	}
 	if len(repos) == 0 {
@@ -160,71 +1091,479 @@ func main() {
 
 	os.MkdirAll(config.BackupDir, 0755)
 	reposDone := 0
-	for _, repo := range repos {
+	// rejectedTargets are the targets whose token was rejected (401), with the error
+	rejectedTargets := map[string]error{}
+	var repoSpan *otelSpan
+	for i, repo := range repos {
+		if stopping() {
+			log.Printf("🛑 Interrupted, %d repo(s) not synced", len(repos)-i)
+			break
+		}
+		if pastRunDeadline() {
+			log.Printf("⏰ Run deadline of %v passed, %d repo(s) not synced", config.RunDeadline, len(repos)-i)
+			summary.notStarted = len(repos) - i
+			break
+		}
+		if config.FailFast && failedResults() > 0 {
+			log.Printf("🚫 Stopping after the first failure (-fail-fast), %d repo(s) not synced", len(repos)-i)
+			break
+		}
 		repoName := repo.Name
 		githubURL := repo.CloneURL
-		repoVisibility := "private"
-		if config.RepoVisibility == "auto" {
-			if repo.Private {
-				repoVisibility = "private"
-			} else {
-				repoVisibility = "public"
-			}
-		} else if config.RepoVisibility != "" {
-			repoVisibility = config.RepoVisibility
-		}
+		progressStart(i+1, len(repos), repoName)
 		localPath := filepath.Join(config.BackupDir, fmt.Sprintf("%s.git", repoName))
 
-		log.Printf("🌐 Syncing %s", repoName)
-		err := mirrorReposFromGitHub(repoName, githubURL, localPath)
+		repoLog := logFields{"repo": repoName}
+		if config.MaxRepoSizeMB > 0 && repo.Size > config.MaxRepoSizeMB*1024 {
+			logWith(repoLog, "⚠️ Skipping %s: its size of %d MB exceeds -max-repo-size-mb=%d", repoName, repo.Size/1024, config.MaxRepoSizeMB)
+			recordResults(repoName, targets, "skipped", fmt.Errorf("size %d MB exceeds -max-repo-size-mb", repo.Size/1024))
+			summary.tooLarge = append(summary.tooLarge, repoName)
+			continue
+		}
+		if state.quarantined(repoName) {
+			logWith(repoLog, "🚧 Skipping %s: quarantined after %d failed reclones in a row, fix the cause and run with -reset-quarantine %s",
+				repoName, config.QuarantineAfter, repoName)
+			recordResults(repoName, targets, "skipped", errors.New("quarantined after failed reclones"))
+			summary.quarantined = append(summary.quarantined, repoName)
+			continue
+		}
+		// the state can only vouch for the refs: the metadata, wiki and releases change
+		// without a push, so those are synced either way
+		refsUnchanged := !config.Force && state.upToDate(repo, targets, localPath)
+		if refsUnchanged {
+			logWith(repoLog, "⏭️ %s is unchanged since its last sync (pushed %s), skipping the fetch and push (use -force to push anyway)",
+				repoName, repo.PushedAt.Format("2006-01-02 15:04:05"))
+		}
+		logWith(repoLog, "🌐 Syncing %s", repoName)
+		repoStart := time.Now()
+		_, statErr := os.Stat(localPath)
+		cloned := os.IsNotExist(statErr)
+		repoSpan = startSpan("sync "+repoName, map[string]any{"gitsync.repo": repoName, "gitsync.cloned": cloned})
+		var sizeBefore int64
+		if repoSpan != nil && !cloned {
+			sizeBefore, _ = dirSize(localPath)
+		}
+		var err error
+		if !refsUnchanged {
+			err = tracePhase(repoName, "mirror", func() error {
+				return mirrorReposFromGitHub(repoName, githubURL, localPath, repo.Size)
+			})
+		}
+		if errors.Is(err, errSAMLSSO) {
+			logWith(repoLog, "🔐 Skipping %s: its organization enforces SAML SSO and GITHUB_TOKEN is not authorized for it", repoName)
+			summary.ssoBlocked = append(summary.ssoBlocked, repoName)
+			recordResults(repoName, targets, "skipped", err)
+			repoSpan.finish(err)
+			continue
+		}
+		if state.recordMirror(repoName, err) {
+			logWith(repoLog, "🚧 Quarantined %s after %d failed reclones in a row, it's skipped until -reset-quarantine", repoName, config.QuarantineAfter)
+			summary.quarantined = append(summary.quarantined, repoName)
+		}
 		if err != nil {
-			log.Printf("🚫 Failed to mirror %s: %v", repoName, err)
+			logWith(repoLog, "🚫 Failed to mirror %s: %v", repoName, err)
+			recordResults(repoName, targets, "failed", err)
+			repoSpan.finish(err)
 			continue
 		}
-		switch *target {
-		case "gitlab":
-			err = checkAndValidateGitLabRepos(gitlabGroupID, repoName, config.GitLabUser, repoVisibility)
-			if err != nil {
-				log.Printf("🚫 Failed to validate GitLab repo %s: %v", repoName, err)
-				continue
+		// a repo without any commit clones fine, but there's nothing to push from it
+		empty := false
+		if _, err := os.Stat(localPath); err == nil {
+			if config.StripPullRefs && !config.DryRun && !repo.Gist {
+				stripPullRefs(repoName, localPath)
 			}
-			err = syncRepos(gitlabGroupID, config.GitLabUser, config.GitLabToken, repoName, localPath)
-			if err != nil {
-				log.Printf("🚫 Failed to sync %s: %v", repoName, err)
-				continue
+			if size, err := dirSize(localPath); err == nil {
+				if summary.mirrorBytes == nil {
+					summary.mirrorBytes = make(map[string]int64)
+				}
+				summary.mirrorBytes[repoName] = size
+				repoSpan.setAttr("gitsync.mirror_bytes", size)
+				repoSpan.setAttr("gitsync.fetched_bytes", size-sizeBefore)
 			}
-		case "codeberg":
-			if config.CodebergUser == "" || config.CodebergToken == "" {
-				log.Fatalf("🚫 CODEBERG_USER and CODEBERG_TOKEN must be set when target=codeberg")
+			if refs, err := listRefs(localPath); err == nil && len(refs) == 0 {
+				empty = true
+				summary.empty = append(summary.empty, repoName)
+				if config.SkipEmpty {
+					logWith(repoLog, "⏭️ Skipping %s: the repository is empty (-skip-empty)", repoName)
+					recordResults(repoName, targets, "skipped", nil)
+					repoSpan.finish(nil)
+					continue
+				}
+				logWith(repoLog, "⚠️ %s is empty, creating it on the targets but there's nothing to push", repoName)
 			}
-			private := repoVisibility == "private"
-			if err := checkAndValidateCodebergRepo(config.CodebergUser, repoName, private); err != nil {
-				log.Printf("🚫 Failed to validate Codeberg repo %s: %v", repoName, err)
-				continue
+		}
+		if config.MaxRefs > 0 && !config.DryRun && !checkRefCount(repoName, localPath) {
+			recordResults(repoName, targets, "skipped", nil)
+			repoSpan.finish(nil)
+			continue
+		}
+		if config.CheckSecurity && !repo.Gist {
+			checkGitHubSecuritySettings(repo)
+		}
+		if config.ExportBundle && !refsUnchanged {
+			if err := exportBundle(repoName, localPath); err != nil {
+				logWith(repoLog, "⚠️ Failed to export %s: %v", repoName, err)
+			}
+		}
+		// the listing normally includes the topics, only ask for them if it didn't
+		if config.SyncTopics && repo.Topics == nil {
+			if repo.Topics, err = getGitHubRepoTopics(repo.FullName); err != nil {
+				logWith(repoLog, "⚠️ Failed to read topics of %s: %v", repoName, err)
 			}
-			if err := syncToCodeberg(config.CodebergUser, config.CodebergToken, repoName, localPath); err != nil {
-				log.Printf("🚫 Failed to sync to Codeberg %s: %v", repoName, err)
+		}
+		if config.SyncWiki {
+			if err := tracePhase(repoName, "wiki", func() error {
+				var err error
+				repo.WikiPath, err = mirrorWiki(repo, localPath)
+				return err
+			}); err != nil {
+				logWith(repoLog, "⚠️ Failed to mirror the wiki of %s: %v", repoName, err)
+			}
+		}
+		if config.SyncReleases && !repo.Gist {
+			if repo.Releases, err = getGitHubReleases(repo.FullName); err != nil {
+				logWith(repoLog, "⚠️ Failed to read releases of %s: %v", repoName, err)
+			}
+		}
+		// like the topics, only ask for the properties if the listing didn't include them
+		if config.SyncProperties && !repo.Gist && repo.CustomProperties == nil {
+			if repo.CustomProperties, err = getGitHubCustomProperties(repo.FullName); err != nil {
+				logWith(repoLog, "⚠️ Failed to read custom properties of %s: %v", repoName, err)
+			}
+		}
+		// the local mirror is pushed to every target in turn
+		for _, target := range targets {
+			res := startResult(repoName, target)
+			if err, ok := rejectedTargets[target]; ok {
+				res.fail(err)
 				continue
 			}
-		case "bitbucket":
-			if config.BitbucketEmail == "" || config.BitbucketToken == "" || config.BitbucketWs == "" {
-				log.Fatalf("🚫 BITBUCKET_EMAIL, BITBUCKET_TOKEN, and BITBUCKET_WORKSPACE must be set when target=bitbucket")
+			if target == "local" {
+				// the mirror itself is all there is to do
+				res.start = repoStart
+				if !config.DryRun && !refsUnchanged {
+					if cloned {
+						markAction("created")
+					} else {
+						markAction("updated")
+					}
+				}
 			}
-			private := repoVisibility == "private"
-			if err := checkAndValidateBitbucketRepo(config.BitbucketWs, repoName, private); err != nil {
-				log.Printf("🚫 Failed to validate Bitbucket repo %s: %v", repoName, err)
+			if err := syncToTarget(target, repo, localPath, !refsUnchanged); err != nil {
+				res.fail(err)
+				// a rejected token fails the remaining repos the same way, e.g. when revoked mid-run
+				if errors.Is(err, errAPIUnauthorized) {
+					rejectedTargets[target] = err
+					log.Printf("🚫 %s rejected the token, skipping it for the remaining repos", target)
+				}
 				continue
 			}
-			if err := syncToBitbucket(config.BitbucketEmail, config.BitbucketToken, config.BitbucketWs, repoName, localPath); err != nil {
-				log.Printf("🚫 Failed to sync to Bitbucket %s: %v", repoName, err)
+			if !config.DryRun {
+				state.markSynced(repo, target, localPath)
+			}
+			if empty {
+				logWith(logFields{"repo": repoName, "target": target, "duration_ms": time.Since(res.start).Milliseconds()},
+					"✅ Created %s on %s, empty so nothing was pushed", repoName, target)
 				continue
 			}
-		default:
-			log.Fatalf("🚫 Unknown target: %s (expected gitlab or codeberg)", *target)
+			logWith(logFields{"repo": repoName, "target": target, "duration_ms": time.Since(res.start).Milliseconds()},
+				"✅ Synced %s to %s", repoName, target)
 		}
-		log.Printf("✅ Synced %s", repoName)
+		clearReleaseAssets()
+		repoSpan.finish(nil)
 		reposDone++
 		log.Printf("Repos done: %d/%d", reposDone, len(repos))
 	}
-	log.Printf("✅ All Done :), all repositories has been synced, please check the logs for details.")
+	finishResult()
+	progressFinish()
+	// pruning compares against a complete sync, don't start it after an interrupt or the deadline
+	if (*pruneRemoteFlag || *pruneArchive) && !stopping() && summary.notStarted == 0 {
+		for _, target := range targets {
+			if target == "local" {
+				continue
+			}
+			if err := pruneRemote(target, githubNames); err != nil {
+				log.Printf("🚫 Failed to prune %s: %v", target, err)
+			}
+		}
+	}
+	writeResults()
+	if !config.DryRun {
+		writeManifest(strings.Join(targets, ","))
+		// only a complete run moves the -since-last-run cutoff forward, a -repo, -repos-from or -max-repos run isn't one
+		// nor is one which skipped quarantined repos or was cut short by the deadline
+		if failedResults() == 0 && !stopping() && *repoFilter == "" && *reposFrom == "" && *maxRepos == 0 && len(summary.quarantined) == 0 && summary.notStarted == 0 {
+			state.LastSuccessfulRun = runStarted
+		}
+		if err := saveState(); err != nil {
+			log.Printf("⚠️ Failed to save state: %v", err)
+		}
+	}
+	if config.Trace {
+		writeTrace()
+	}
+	if Contains(targets, "local") {
+		if size, err := dirSize(config.BackupDir); err != nil {
+			log.Printf("⚠️ Failed to measure %s: %v", config.BackupDir, err)
+		} else {
+			summary.backupBytes = size
+		}
+	}
+	logSummary()
+	progressSummary()
+	notify()
+	finishMetrics()
+	finishRunSpan()
+	if stopping() {
+		log.Printf("🛑 Stopped early (run %s)", runID)
+		exit(130)
+	}
+	if *maintenance && pastRunDeadline() {
+		log.Printf("⏰ Skipping -maintenance, the run deadline of %v passed", config.RunDeadline)
+	} else if *maintenance {
+		if err := runMaintenance(); err != nil {
+			fatal(err)
+		}
+	}
+	if failed := failedResults(); failed > 0 {
+		log.Printf("🚫 %d of %d repo(s) failed, see %s (run %s)", failed, len(results), runFile("summary.json", "summary.json"), runID)
+		exit(1)
+	}
+	if summary.notStarted > 0 {
+		log.Printf("⏰ Stopped at the run deadline, %d repo(s) left for the next run (run %s)", summary.notStarted, runID)
+		return
+	}
+	if config.DryRun {
+		log.Printf("🧪 DRY RUN — no changes made (run %s)", runID)
+		return
+	}
+	log.Printf("✅ All Done :), all repositories has been synced, please check the logs for details. (run %s)", runID)
+}
+
+// checkGitHubSecuritySettings records security settings of repo which won't carry over to the destination.
+func checkGitHubSecuritySettings(repo GitHubRepo) {
+	settings, err := getGitHubSecuritySettings(repo.FullName)
+	if err != nil {
+		log.Printf("⚠️ Failed to read security settings of %s: %v", repo.Name, err)
+		return
+	}
+	if enabled := settings.Enabled(); len(enabled) > 0 {
+		log.Printf("⚠️ %s has GitHub security settings enabled which are not mirrored: %s", repo.Name, strings.Join(enabled, ", "))
+		if summary.securityNotMirrored == nil {
+			summary.securityNotMirrored = make(map[string][]string)
+		}
+		summary.securityNotMirrored[repo.Name] = enabled
+	}
+	if config.DumpSecurity {
+		data, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			log.Printf("⚠️ Failed to encode security settings of %s: %v", repo.Name, err)
+			return
+		}
+		dumpPath := filepath.Join(config.BackupDir, fmt.Sprintf("%s.security.json", repo.Name))
+		if err := os.WriteFile(dumpPath, data, 0644); err != nil {
+			log.Printf("⚠️ Failed to save security settings of %s: %v", repo.Name, err)
+			return
+		}
+		log.Printf("Saved security settings of %s to %s", repo.Name, dumpPath)
+	}
+}
+
+// syncToTarget creates or updates the destination repo of repo on target and pushes the
+// local mirror at localPath to it. Failures are logged here, with the phase that failed.
+// Without pushRefs, the refs are left as they are, the rest is still synced.
+func syncToTarget(target string, repo GitHubRepo, localPath string, pushRefs bool) error {
+	repoName := repo.Name
+	// the name on target, repoName is kept for the logs, traces and manifest
+	destName := targetName(repoName)
+	repoVisibility := resolveVisibility(repo)
+	if target == "gitlab" {
+		repoVisibility = gitLabVisibility(repoVisibility)
+	}
+	// Gitea and Bitbucket only know public/private, so "internal" stays private
+	private := repoVisibility != "public"
+	repoLog := logFields{"repo": repoName, "target": target}
+	if target == "local" {
+		// the mirror in the backup dir is the destination
+		if !config.DryRun {
+			addToManifest(repo, target, localPath, repoVisibility, localPath)
+		}
+		return nil
+	}
+	topics := config.DestAddedTopics
+	if config.SyncTopics {
+		topics, _ = mergeTopics(repo.Topics, config.DestAddedTopics)
+	}
+	// pushPhase pushes the refs, unless they are known to be on target already
+	pushPhase := func(push func() error) error {
+		if !pushRefs {
+			return nil
+		}
+		return tracePhase(repoName, "push", push)
+	}
+	var err error
+	switch target {
+	case "gitlab":
+		err = tracePhase(repoName, "validate", func() error {
+			return checkAndValidateGitLabRepos(destName, repoVisibility, repo.Description, topics)
+		})
+		if err != nil {
+			logWith(repoLog, "🚫 Failed to validate GitLab repo %s: %v", repoName, err)
+			return err
+		}
+		err = pushPhase(func() error {
+			return syncRepos(config.GitLabToken, destName, localPath)
+		})
+		if err != nil {
+			logWith(repoLog, "🚫 Failed to sync %s: %v", repoName, err)
+			return err
+		}
+	case "gitea", "codeberg":
+		if err := tracePhase(repoName, "validate", func() error {
+			return checkAndValidateGiteaRepo(config.GiteaOwner, destName, private, repo.Description, topics)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to validate %s repo %s: %v", config.GiteaName, repoName, err)
+			return err
+		}
+		if err := pushPhase(func() error {
+			return syncToGitea(config.GiteaOwner, config.GiteaToken, destName, localPath)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to sync to %s %s: %v", config.GiteaName, repoName, err)
+			return err
+		}
+	case "bitbucket":
+		if err := tracePhase(repoName, "validate", func() error {
+			return checkAndValidateBitbucketRepo(config.BitbucketWs, destName, private, repo.Description)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to validate Bitbucket repo %s: %v", repoName, err)
+			return err
+		}
+		if err := pushPhase(func() error {
+			return syncToBitbucket(config.BitbucketEmail, config.BitbucketToken, config.BitbucketWs, destName, localPath)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to sync to Bitbucket %s: %v", repoName, err)
+			return err
+		}
+	case "azure":
+		// visibility and topics belong to the Azure DevOps project, not the repo
+		if err := tracePhase(repoName, "validate", func() error {
+			return checkAndValidateAzureRepo(config.AzureProject, destName)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to validate Azure DevOps repo %s: %v", repoName, err)
+			return err
+		}
+		if err := pushPhase(func() error {
+			return syncToAzure(config.AzureToken, config.AzureProject, destName, localPath)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to sync to Azure DevOps %s: %v", repoName, err)
+			return err
+		}
+	case "sourcehut":
+		if err := tracePhase(repoName, "validate", func() error {
+			return checkAndValidateSourceHutRepo(destName, repoVisibility, repo.Description)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to validate SourceHut repo %s: %v", repoName, err)
+			return err
+		}
+		if err := pushPhase(func() error {
+			return syncToSourceHut(config.SourceHutUser, destName, localPath)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to sync to SourceHut %s: %v", repoName, err)
+			return err
+		}
+	case "codecommit":
+		if err := tracePhase(repoName, "validate", func() error {
+			return checkAndValidateCodeCommitRepo(destName, repo.Description)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to validate CodeCommit repo %s: %v", repoName, err)
+			return err
+		}
+		if err := pushPhase(func() error {
+			return syncToCodeCommit(destName, localPath)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to sync to CodeCommit %s: %v", repoName, err)
+			return err
+		}
+	case "fs":
+		if err := tracePhase(repoName, "validate", func() error {
+			return checkAndValidateFSRepo(destName, repo.Description)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to prepare the bare repo of %s: %v", repoName, err)
+			return err
+		}
+		if err := pushPhase(func() error {
+			return syncToFS(destName, localPath)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to sync to %s %s: %v", config.FSTargetDir, repoName, err)
+			return err
+		}
+	}
+	if repo.WikiPath != "" {
+		if err := tracePhase(repoName, "push", func() error {
+			return syncWiki(target, destName, repo.WikiPath)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to sync the wiki of %s to %s: %v", repoName, target, err)
+			return err
+		}
+	}
+	if config.SyncReleases && len(repo.Releases) > 0 {
+		if err := tracePhase(repoName, "releases", func() error {
+			return syncReleases(target, repo, destName)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to sync releases of %s to %s: %v", repoName, target, err)
+			return err
+		}
+	}
+	// unread properties (nil) keep the labels, read but empty ones remove them
+	if config.SyncProperties && repo.CustomProperties != nil {
+		if err := tracePhase(repoName, "properties", func() error {
+			return syncProperties(target, repo, destName)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to sync custom properties of %s to %s: %v", repoName, target, err)
+			return err
+		}
+	}
+	if config.FixDefaultBranch && !config.DryRun && repo.DefaultBranch != "" && localBranchExists(localPath, repo.DefaultBranch) {
+		var previous string
+		switch target {
+		case "gitlab":
+			previous, err = fixGitLabDefaultBranch(destName, repo.DefaultBranch)
+		case "gitea", "codeberg":
+			previous, err = fixGiteaDefaultBranch(config.GiteaOwner, destName, repo.DefaultBranch)
+		case "bitbucket":
+			previous, err = fixBitbucketDefaultBranch(config.BitbucketWs, destName, repo.DefaultBranch)
+		case "azure":
+			previous, err = fixAzureDefaultBranch(config.AzureProject, destName, repo.DefaultBranch)
+		case "codecommit":
+			previous, err = fixCodeCommitDefaultBranch(destName, repo.DefaultBranch)
+		case "fs":
+			previous, err = fixFSDefaultBranch(destName, repo.DefaultBranch)
+		}
+		if err != nil {
+			logWith(repoLog, "⚠️ Failed to verify default branch of %s on %s: %v", repoName, target, err)
+		} else if previous != "" {
+			summary.defaultBranchFixes = append(summary.defaultBranchFixes, fmt.Sprintf("%s on %s: %s -> %s", repoName, target, previous, repo.DefaultBranch))
+		}
+	}
+	if !config.DryRun {
+		var destination string
+		switch target {
+		case "gitlab":
+			destination = gitLabRepoURL(destName)
+		case "gitea", "codeberg":
+			destination = giteaRepoURL(config.GiteaOwner, destName)
+		case "bitbucket":
+			destination = bitbucketRepoURL(config.BitbucketWs, destName)
+		case "azure":
+			destination = azureRepoURL(config.AzureProject, destName)
+		case "sourcehut":
+			destination = sourceHutRepoURL(config.SourceHutUser, destName)
+		case "codecommit":
+			destination = codeCommitRepoURL(destName)
+		case "fs":
+			destination = fsRepoPath(destName)
+		}
+		addToManifest(repo, target, destination, repoVisibility, localPath)
+	}
+	return nil
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runMaintenance repacks every mirror found in BackupDir and logs the space reclaimed per repo.
+// It is intentionally separate from the sync path since repacking large mirrors is expensive.
+func runMaintenance() error {
+	entries, err := os.ReadDir(config.BackupDir)
+	if err != nil {
+		return err
+	}
+	var totalReclaimed int64
+	for _, entry := range entries {
+		if stopping() {
+			log.Printf("🛑 Interrupted, maintenance stopped early")
+			break
+		}
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), ".git") {
+			continue
+		}
+		localPath := filepath.Join(config.BackupDir, entry.Name())
+		before, err := dirSize(localPath)
+		if err != nil {
+			log.Printf("🚫 Failed to measure %s: %v", entry.Name(), err)
+			continue
+		}
+		if config.DryRun {
+			log.Printf("[dry-run] Would repack %s (%s)", entry.Name(), formatBytes(before))
+			continue
+		}
+		log.Printf("🧹 Repacking %s ...", entry.Name())
+		if err := runCmd("git", "--git-dir", localPath, "repack", "-a", "-d", "--quiet"); err != nil {
+			log.Printf("🚫 Failed to repack %s: %v", entry.Name(), err)
+			continue
+		}
+		if err := runCmd("git", "--git-dir", localPath, "pack-refs", "--all", "--prune"); err != nil {
+			log.Printf("🚫 Failed to pack refs of %s: %v", entry.Name(), err)
+			continue
+		}
+		after, err := dirSize(localPath)
+		if err != nil {
+			log.Printf("🚫 Failed to measure %s: %v", entry.Name(), err)
+			continue
+		}
+		totalReclaimed += before - after
+		log.Printf("✅ %s: %s -> %s (reclaimed %s)", entry.Name(), formatBytes(before), formatBytes(after), formatBytes(before-after))
+	}
+	log.Printf("🧹 Maintenance done, reclaimed %s in total", formatBytes(totalReclaimed))
+	return nil
+}
+
+// formatBytes renders a byte count in a human readable unit, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for abs := n / unit; abs >= unit || abs <= -unit; abs /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifestEntry records what was backed up for one repo and where it was pushed to.
+type manifestEntry struct {
+	Repo        string `json:"repo"`
+	Target      string `json:"target"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Visibility  string `json:"visibility"`
+	// branch/tag ref -> tip commit SHA, as found in the local mirror
+	Refs map[string]string `json:"refs"`
+}
+
+var manifest []manifestEntry
+
+// addToManifest records the branch and tag tips of the mirror at localPath.
+func addToManifest(repo GitHubRepo, target, destination, visibility, localPath string) {
+	refs, err := listRefs(localPath)
+	if err != nil {
+		log.Printf("⚠️ Failed to list refs of %s for the manifest: %v", repo.Name, err)
+		return
+	}
+	tips := make(map[string]string)
+	for ref, sha := range refs {
+		if strings.HasPrefix(ref, "refs/heads/") || strings.HasPrefix(ref, "refs/tags/") {
+			tips[ref] = sha
+		}
+	}
+	manifest = append(manifest, manifestEntry{
+		Repo:        repo.Name,
+		Target:      target,
+		Source:      repo.CloneURL,
+		Destination: destination,
+		Visibility:  visibility,
+		Refs:        tips,
+	})
+}
+
+// writeManifest saves the manifest of this run as <backup-dir>/manifest_<run-id>.json.
+func writeManifest(target string) {
+	data, err := json.MarshalIndent(map[string]any{
+		"run_id":       runID,
+		"generated_at": time.Now().Format(time.RFC3339),
+		"target":       target,
+		"repos":        manifest,
+	}, "", "  ")
+	if err != nil {
+		log.Printf("⚠️ Failed to encode manifest: %v", err)
+		return
+	}
+	manifestPath := filepath.Join(config.BackupDir, fmt.Sprintf("manifest_%s.json", runID))
+	if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+		log.Printf("⚠️ Failed to write manifest: %v", err)
+		return
+	}
+	log.Printf("📜 Manifest of %d repo(s) written to %s", len(manifest), manifestPath)
+}
@@ -0,0 +1,164 @@
+// Prometheus metrics of the run, served on -metrics-addr and/or pushed to -metrics-push-url,
+// in the text exposition format: https://prometheus.io/docs/instrumenting/exposition_formats/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var metricsClient = newServiceClient(serviceClientOptions{Timeout: 30 * time.Second})
+
+// durationBuckets are the upper bounds of gitsync_sync_duration_seconds, from an
+// unchanged repo to a big first clone.
+var durationBuckets = []float64{1, 5, 15, 60, 300, 900, 3600}
+
+// metrics are updated by finishResult from the sync loop and read by the metrics server.
+var metrics = struct {
+	sync.Mutex
+	// repos counts the finished repos by target and action
+	repos     map[[2]string]int
+	durations map[string]*histogram
+	// lastRun is when the run finished, zero while it's still going
+	lastRun time.Time
+}{repos: map[[2]string]int{}, durations: map[string]*histogram{}}
+
+type histogram struct {
+	// counts[i] is the number of observations <= durationBuckets[i]
+	counts []int
+	sum    float64
+	count  int
+}
+
+// observeResult adds the outcome of a finished repo to the metrics.
+func observeResult(r *Result) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.repos[[2]string{r.Target, r.Action}]++
+	h := metrics.durations[r.Target]
+	if h == nil {
+		h = &histogram{counts: make([]int, len(durationBuckets))}
+		metrics.durations[r.Target] = h
+	}
+	seconds := float64(r.ElapsedMS) / 1000
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// finishMetrics sets gitsync_last_run_timestamp and pushes the metrics to -metrics-push-url.
+func finishMetrics() {
+	metrics.Lock()
+	metrics.lastRun = time.Now()
+	metrics.Unlock()
+	if config.MetricsPushURL != "" {
+		pushMetrics(config.MetricsPushURL)
+	}
+}
+
+// writeMetrics renders the metrics in the text exposition format.
+func writeMetrics(w io.Writer) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	keys := make([][2]string, 0, len(metrics.repos))
+	for key := range metrics.repos {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	failed := map[string]int{}
+	var targets []string
+	for target := range metrics.durations {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	fmt.Fprintln(w, "# HELP gitsync_repos_total Repos synced in this run, by target and action.")
+	fmt.Fprintln(w, "# TYPE gitsync_repos_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "gitsync_repos_total{target=%q,action=%q} %d\n", key[0], key[1], metrics.repos[key])
+		if key[1] == "failed" {
+			failed[key[0]] += metrics.repos[key]
+		}
+	}
+	fmt.Fprintln(w, "# HELP gitsync_repos_failed_total Repos that failed to sync in this run, by target.")
+	fmt.Fprintln(w, "# TYPE gitsync_repos_failed_total counter")
+	for _, target := range targets {
+		fmt.Fprintf(w, "gitsync_repos_failed_total{target=%q} %d\n", target, failed[target])
+	}
+	fmt.Fprintln(w, "# HELP gitsync_sync_duration_seconds Time spent syncing one repo to one target.")
+	fmt.Fprintln(w, "# TYPE gitsync_sync_duration_seconds histogram")
+	for _, target := range targets {
+		h := metrics.durations[target]
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(w, "gitsync_sync_duration_seconds_bucket{target=%q,le=\"%g\"} %d\n", target, bound, h.counts[i])
+		}
+		fmt.Fprintf(w, "gitsync_sync_duration_seconds_bucket{target=%q,le=\"+Inf\"} %d\n", target, h.count)
+		fmt.Fprintf(w, "gitsync_sync_duration_seconds_sum{target=%q} %g\n", target, h.sum)
+		fmt.Fprintf(w, "gitsync_sync_duration_seconds_count{target=%q} %d\n", target, h.count)
+	}
+	if !metrics.lastRun.IsZero() {
+		fmt.Fprintln(w, "# HELP gitsync_last_run_timestamp Unix time the last run finished.")
+		fmt.Fprintln(w, "# TYPE gitsync_last_run_timestamp gauge")
+		fmt.Fprintf(w, "gitsync_last_run_timestamp %d\n", metrics.lastRun.Unix())
+	}
+}
+
+// startMetricsServer serves /metrics on addr (e.g. :9090) for the duration of the run.
+func startMetricsServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Printf("⚠️ Metrics server stopped: %v", err)
+		}
+	}()
+	log.Printf("📈 Serving metrics on http://%s/metrics", listener.Addr())
+	return nil
+}
+
+// pushMetrics replaces the metrics of the gitsync job on a Prometheus Pushgateway, for
+// one-shot cron runs nothing could scrape. It only warns on failure, like notify.
+// Docs: https://github.com/prometheus/pushgateway#put-method
+func pushMetrics(pushURL string) {
+	var body bytes.Buffer
+	writeMetrics(&body)
+	req, err := http.NewRequest("PUT", strings.TrimSuffix(pushURL, "/")+"/metrics/job/gitsync", &body)
+	if err != nil {
+		log.Printf("⚠️ Failed to push metrics: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := doWithRetry(metricsClient, req)
+	if err != nil {
+		log.Printf("⚠️ Failed to push metrics: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Printf("⚠️ Failed to push metrics: %s: %s", resp.Status, msg)
+		return
+	}
+	log.Printf("📈 Pushed metrics to %s", redactText(pushURL))
+}
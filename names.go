@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// parseNameReplace parses -name-replace, a regular expression and its replacement
+// separated by the first "=", e.g. "^old-=new-" or "_=-".
+func parseNameReplace(s string) (*regexp.Regexp, string, error) {
+	from, to, ok := strings.Cut(s, "=")
+	if !ok || from == "" {
+		return nil, "", fmt.Errorf("expected from=to, got %q", s)
+	}
+	re, err := regexp.Compile(from)
+	if err != nil {
+		return nil, "", err
+	}
+	return re, to, nil
+}
+
+// targetName returns the name of the destination repo of the GitHub repo name, after
+// -name-replace, -name-prefix and -name-suffix. The local mirror keeps the GitHub name.
+func targetName(name string) string {
+	if config.NameReplace != nil {
+		name = config.NameReplace.ReplaceAllString(name, config.NameReplaceWith)
+	}
+	return config.NamePrefix + name + config.NameSuffix
+}
+
+// checkTargetNames fails if the renaming leaves a repo without a destination name.
+func checkTargetNames(repos []GitHubRepo) error {
+	for _, r := range repos {
+		if targetName(r.Name) == "" {
+			return fmt.Errorf("%s is renamed to an empty name", r.Name)
+		}
+	}
+	return nil
+}
+
+// dropNameCollisions returns repos without those whose destination name is already taken
+// by an earlier repo, e.g. acme/tools and octocat/tools, as they would be pushed over each
+// other (and share a mirror). Names are compared case-insensitively, since destinations
+// like Bitbucket lowercase slugs. The dropped repos are skipped with a warning.
+func dropNameCollisions(repos []GitHubRepo, targets []string) []GitHubRepo {
+	seen := make(map[string]GitHubRepo, len(repos))
+	var kept []GitHubRepo
+	for _, r := range repos {
+		name := targetName(r.Name)
+		other, ok := seen[strings.ToLower(name)]
+		if !ok {
+			seen[strings.ToLower(name)] = r
+			kept = append(kept, r)
+			continue
+		}
+		log.Printf("⚠️ Skipping %s: %s already syncs to %s, rename one of them with -name-replace (e.g. '^%s$=%s-%s')",
+			r.FullName, other.FullName, name, regexp.QuoteMeta(r.Name), r.Owner.Login, r.Name)
+		recordResults(r.Name, targets, "skipped", fmt.Errorf("destination name %s is taken by %s", name, other.FullName))
+		summary.nameCollisions = append(summary.nameCollisions, fmt.Sprintf("%s (taken by %s)", r.FullName, other.FullName))
+	}
+	return kept
+}
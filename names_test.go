@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDropNameCollisions(t *testing.T) {
+	withConfig(t)
+	oldSummary, oldResults := summary, results
+	t.Cleanup(func() { summary, results = oldSummary, oldResults })
+	config.NamePrefix = "gh-"
+
+	repo := func(owner, name string) GitHubRepo {
+		return GitHubRepo{Name: name, FullName: owner + "/" + name, Owner: GitHubOwner{Login: owner}}
+	}
+	repos := []GitHubRepo{repo("acme", "tools"), repo("octocat", "Tools"), repo("acme", "web")}
+	kept := dropNameCollisions(repos, []string{"gitlab"})
+
+	if got := strings.Join(Map(kept, func(r GitHubRepo) string { return r.FullName }), ","); got != "acme/tools,acme/web" {
+		t.Errorf("kept %s, want acme/tools,acme/web", got)
+	}
+	if len(results) != 1 || results[0].Repo != "Tools" || results[0].Action != "skipped" || !strings.Contains(results[0].Error, "acme/tools") {
+		t.Errorf("results = %+v, want Tools skipped as taken by acme/tools", results)
+	}
+	if len(summary.nameCollisions) != 1 {
+		t.Errorf("summary collisions = %v, want one", summary.nameCollisions)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var notifyClient = newServiceClient(serviceClientOptions{Timeout: 30 * time.Second})
+
+// notifyPayload is the generic -notify-format, built from the same results as summary.json.
+type notifyPayload struct {
+	RunID       string   `json:"run_id"`
+	DryRun      bool     `json:"dry_run"`
+	Stopped     bool     `json:"stopped"`
+	DurationSec float64  `json:"duration_sec"`
+	Total       int      `json:"total"`
+	OK          int      `json:"ok"`
+	Unchanged   int      `json:"unchanged"`
+	Skipped     int      `json:"skipped"`
+	Failed      int      `json:"failed"`
+	FailedRepos []string `json:"failed_repos"`
+}
+
+// notifyText is the one message of the slack and discord formats.
+func (p notifyPayload) notifyText() string {
+	status := "✅ git-sync finished"
+	if p.Failed > 0 {
+		status = "🚫 git-sync finished with failures"
+	} else if p.Stopped {
+		status = "🛑 git-sync was stopped"
+	}
+	if p.DryRun {
+		status += " (dry run)"
+	}
+	text := fmt.Sprintf("%s in %s (run %s): %d repo(s), %d ok, %d unchanged, %d skipped, %d failed",
+		status, time.Duration(p.DurationSec*float64(time.Second)).Round(time.Second), p.RunID,
+		p.Total, p.OK, p.Unchanged, p.Skipped, p.Failed)
+	if len(p.FailedRepos) > 0 {
+		text += "\nFailed: " + strings.Join(p.FailedRepos, ", ")
+	}
+	return text
+}
+
+// notify posts the outcome of the run to -notify-url. It only warns on failure,
+// a notification problem must not fail a sync that worked.
+func notify() {
+	if config.NotifyURL == "" {
+		return
+	}
+	counts, failed, total := repoOutcomes()
+	p := notifyPayload{
+		RunID:       runID,
+		DryRun:      config.DryRun,
+		Stopped:     stopping(),
+		DurationSec: time.Since(runStarted).Seconds(),
+		Total:       total,
+		OK:          counts["ok"],
+		Unchanged:   counts["unchanged"],
+		Skipped:     counts["skipped"],
+		Failed:      counts["failed"],
+		FailedRepos: failed,
+	}
+	if p.FailedRepos == nil {
+		p.FailedRepos = []string{}
+	}
+	var body any = p
+	switch config.NotifyFormat {
+	case "slack":
+		// https://api.slack.com/messaging/webhooks
+		body = map[string]string{"text": p.notifyText()}
+	case "discord":
+		// https://discord.com/developers/docs/resources/webhook#execute-webhook, at most 2000 characters
+		text := []rune(p.notifyText())
+		if len(text) > 2000 {
+			text = append(text[:1997], []rune("...")...)
+		}
+		body = map[string]string{"content": string(text)}
+	}
+	byts, _ := json.Marshal(body)
+	// the webhook URL is a secret of its own, only its host goes to the log
+	host := "the -notify-url"
+	if u, err := url.Parse(config.NotifyURL); err == nil {
+		host = u.Host
+	}
+	req, err := http.NewRequest("POST", config.NotifyURL, bytes.NewReader(byts))
+	if err != nil {
+		log.Printf("⚠️ Failed to notify %s: %v", host, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := doWithRetry(notifyClient, req)
+	if err != nil {
+		log.Printf("⚠️ Failed to notify %s: %v", host, redactText(strings.ReplaceAll(err.Error(), config.NotifyURL, host)))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("⚠️ Failed to notify %s: status %d", host, resp.StatusCode)
+		return
+	}
+	log.Printf("📣 Sent %s notification to %s", config.NotifyFormat, host)
+}
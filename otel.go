@@ -0,0 +1,261 @@
+// OpenTelemetry tracing of the run, enabled by OTEL_EXPORTER_OTLP_ENDPOINT: a root span for the
+// run, a child span per repo with the phases (mirror, validate, push, ...) below it, and a span
+// per git command. Spans are exported with OTLP/HTTP in the JSON encoding, so any collector
+// listening on the OTLP/HTTP port (4318) takes them without an SDK dependency.
+// Docs: https://opentelemetry.io/docs/specs/otlp/#otlphttp
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var otelClient = newServiceClient(serviceClientOptions{Timeout: 30 * time.Second})
+
+// otelBatchSize is the number of finished spans exported at once.
+const otelBatchSize = 512
+
+type otelSpan struct {
+	traceID, spanID, parentID string
+	name                      string
+	start, end                time.Time
+	attrs                     map[string]any
+	err                       error
+	parent                    *otelSpan
+}
+
+var otel struct {
+	sync.Mutex
+	// current is the innermost open span, the parent of the next one
+	current  *otelSpan
+	finished []*otelSpan
+	// runDone finishes the root span and exports the rest, also when the run exits early
+	runDone func()
+}
+
+// otelEnabled reports whether spans are recorded at all; without the endpoint every
+// span is nil and costs nothing.
+func otelEnabled() bool {
+	return config.OTLPEndpoint != ""
+}
+
+// startRunSpan opens the root span of the run, below the TRACEPARENT of a calling
+// pipeline if there is one (W3C Trace Context: 00-<trace id>-<span id>-<flags>).
+func startRunSpan() {
+	if !otelEnabled() {
+		return
+	}
+	span := startSpan("git-sync", map[string]any{"gitsync.run_id": runID, "gitsync.dry_run": config.DryRun})
+	if parts := strings.Split(os.Getenv("TRACEPARENT"), "-"); len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		span.traceID, span.parentID = parts[1], parts[2]
+	}
+	otel.runDone = registerCleanup(func() {
+		span.finish(nil)
+		flushSpans()
+	})
+}
+
+// finishRunSpan ends the root span and exports all remaining spans.
+func finishRunSpan() {
+	if otel.runDone != nil {
+		otel.runDone()
+	}
+}
+
+// startPhaseSpan opens the span of a phase of repoName, on the target being synced if any.
+func startPhaseSpan(repoName, phase string) *otelSpan {
+	if !otelEnabled() {
+		return nil
+	}
+	attrs := map[string]any{"gitsync.repo": repoName}
+	if currentResult != nil && currentResult.Repo == repoName {
+		attrs["gitsync.target"] = currentResult.Target
+	}
+	return startSpan(phase, attrs)
+}
+
+// startSpan opens a child span of the current one. It returns nil when tracing is off.
+func startSpan(name string, attrs map[string]any) *otelSpan {
+	if !otelEnabled() {
+		return nil
+	}
+	otel.Lock()
+	defer otel.Unlock()
+	span := &otelSpan{spanID: randomHex(8), name: name, start: time.Now(), attrs: attrs, parent: otel.current}
+	if span.parent != nil {
+		span.traceID, span.parentID = span.parent.traceID, span.parent.spanID
+	} else {
+		span.traceID = randomHex(16)
+	}
+	otel.current = span
+	return span
+}
+
+// setAttr adds an attribute to an open span.
+func (s *otelSpan) setAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	otel.Lock()
+	defer otel.Unlock()
+	if s.attrs == nil {
+		s.attrs = map[string]any{}
+	}
+	s.attrs[key] = value
+}
+
+// finish closes the span, with err as its error status, and any child still open.
+func (s *otelSpan) finish(err error) {
+	if s == nil {
+		return
+	}
+	otel.Lock()
+	if !s.end.IsZero() {
+		otel.Unlock()
+		return
+	}
+	s.end, s.err = time.Now(), err
+	otel.finished = append(otel.finished, s)
+	// spans are strictly nested, a child left open by an early return ends with its parent
+	for c := otel.current; c != nil; c = c.parent {
+		if c != s {
+			continue
+		}
+		for c = otel.current; c != s; c = c.parent {
+			if c.end.IsZero() {
+				c.end = s.end
+				otel.finished = append(otel.finished, c)
+			}
+		}
+		otel.current = s.parent
+		break
+	}
+	flush := len(otel.finished) >= otelBatchSize
+	otel.Unlock()
+	if flush {
+		flushSpans()
+	}
+}
+
+// flushSpans exports the finished spans. Failures only warn, tracing must not fail a sync.
+func flushSpans() {
+	otel.Lock()
+	spans := otel.finished
+	otel.finished = nil
+	otel.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	body, _ := json.Marshal(otlpTraces(spans))
+	endpoint := config.OTLPTracesEndpoint
+	if endpoint == "" {
+		endpoint = strings.TrimSuffix(config.OTLPEndpoint, "/") + "/v1/traces"
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("⚠️ Failed to export %d span(s): %v", len(spans), err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range config.OTLPHeaders {
+		req.Header.Set(key, value)
+	}
+	resp, err := doWithRetry(otelClient, req)
+	if err != nil {
+		log.Printf("⚠️ Failed to export %d span(s): %v", len(spans), err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Printf("⚠️ Failed to export %d span(s): %s: %s", len(spans), resp.Status, msg)
+	}
+}
+
+// otlpTraces builds an ExportTraceServiceRequest in the OTLP JSON encoding: ids in hex,
+// 64 bit integers as strings.
+// Docs: https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+func otlpTraces(spans []*otelSpan) map[string]any {
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		span := map[string]any{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parentID != "" {
+			span["parentSpanId"] = s.parentID
+		}
+		if s.err != nil {
+			span["status"] = map[string]any{"code": 2, "message": redactText(s.err.Error())} // STATUS_CODE_ERROR
+		}
+		encoded = append(encoded, span)
+	}
+	return map[string]any{"resourceSpans": []any{map[string]any{
+		"resource": map[string]any{"attributes": otlpAttributes(map[string]any{"service.name": config.OTelServiceName})},
+		"scopeSpans": []any{map[string]any{
+			"scope": map[string]any{"name": "git-sync"},
+			"spans": encoded,
+		}},
+	}}}
+}
+
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	encoded := make([]map[string]any, 0, len(attrs))
+	for key, value := range attrs {
+		var v map[string]any
+		switch value := value.(type) {
+		case bool:
+			v = map[string]any{"boolValue": value}
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(value, 10)}
+		default:
+			v = map[string]any{"stringValue": redactText(fmt.Sprint(value))}
+		}
+		encoded = append(encoded, map[string]any{"key": key, "value": v})
+	}
+	return encoded
+}
+
+// parseOTLPHeaders reads OTEL_EXPORTER_OTLP_HEADERS, e.g. "api-key=secret,x-tenant=acme".
+func parseOTLPHeaders(s string) map[string]string {
+	headers := map[string]string{}
+	for _, item := range splitList(s) {
+		if key, value, ok := strings.Cut(item, "="); ok {
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return headers
+}
+
+// gitSpanName names the span of a git command after its subcommand, skipping the
+// global options in front of it, e.g. git -c http.proxy=... --git-dir x push -> git push.
+func gitSpanName(args []string) string {
+	if i := gitSubcommand(args); i >= 0 {
+		return "git " + args[i]
+	}
+	return "git"
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,40 @@
+// Partial (blobless) mirrors for -partial-clone: the first clone skips the file contents,
+// which git fetches from the origin when a push, bundle or checkout needs them.
+// Docs: https://git-scm.com/docs/partial-clone
+package main
+
+import "strings"
+
+// partialCloneURLs are the origin URLs of the partial mirrors fetched in this run, plain and
+// authenticated, by mirror path. The origin stored in a mirror has no credentials, so the
+// missing blobs of a private repo can only be fetched with the URL given on the command line.
+var partialCloneURLs = map[string][2]string{}
+
+// partialCloneFilter is the filter of the first clone, all commits and trees but no blobs.
+const partialCloneFilter = "blob:none"
+
+// isPartialMirror reports whether the mirror at localPath was cloned with a filter, also by
+// an earlier run: its origin is then a promisor remote, which git lazily fetches from.
+func isPartialMirror(localPath string) bool {
+	out, err := runCmdOutput(nil, "git", "--git-dir", localPath, "config", "--get", "remote.origin.promisor")
+	return err == nil && strings.TrimSpace(out) == "true"
+}
+
+// rememberPartialClone keeps authCloneURL for the lazy fetches of the mirror at localPath,
+// whose origin is cloneURL.
+func rememberPartialClone(localPath, cloneURL, authCloneURL string) {
+	if isPartialMirror(localPath) {
+		partialCloneURLs[localPath] = [2]string{cloneURL, authCloneURL}
+	}
+}
+
+// partialCloneArgs returns the git options that point the lazy fetches of a partial mirror at
+// its authenticated URL for one command, without storing the URL in the mirror's config.
+// remote.origin.url can't be overridden by -c (it's a list, the first one wins), so the
+// origin is rewritten instead.
+func partialCloneArgs(localPath string) []string {
+	if urls, ok := partialCloneURLs[localPath]; ok {
+		return []string{"-c", "url." + urls[1] + ".insteadOf=" + urls[0]}
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// preflight checks every token with one cheap authenticated call before anything is
+// synced, so a wrong or under-scoped token fails the run right away with a clear message.
+func preflight(targets []string) error {
+	if err := checkGitHubToken(); err != nil {
+		return fmt.Errorf("GitHub token invalid: %w", err)
+	}
+	for _, target := range targets {
+		if err := checkTarget(target); err != nil {
+			if target == "fs" {
+				return fmt.Errorf("FS_TARGET_DIR unusable: %w", err)
+			}
+			return fmt.Errorf("%s token invalid: %w", target, err)
+		}
+	}
+	return nil
+}
+
+// checkTarget checks the token of target, or the directory of the fs target.
+func checkTarget(target string) error {
+	switch target {
+	case "gitlab":
+		return checkGitLabToken()
+	case "gitea", "codeberg":
+		return checkGiteaToken()
+	case "bitbucket":
+		return checkBitbucketToken()
+	case "azure":
+		return checkAzureToken()
+	case "sourcehut":
+		return checkSourceHutToken()
+	case "codecommit":
+		return checkCodeCommitCredentials()
+	case "fs":
+		return checkFSTargetDir()
+	}
+	return nil
+}
+
+// preflightError describes a failed preflight response.
+func preflightError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return fmt.Errorf("rejected (401), check that it is set correctly and not expired")
+	case http.StatusForbidden:
+		return fmt.Errorf("not permitted (403): %s", strings.TrimSpace(string(body)))
+	}
+	return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// missingScopes returns the scopes of want which are not in have.
+func missingScopes(have, want []string) []string {
+	var missing []string
+	for _, w := range want {
+		if !Contains(have, w) {
+			missing = append(missing, w)
+		}
+	}
+	return missing
+}
+
+// Get the authenticated user
+// Docs: https://docs.github.com/en/rest/users/users#get-the-authenticated-user
+func checkGitHubToken() error {
+	if githubApp() {
+		// installation tokens have no user, listing one repo checks the token and the installation
+		resp, err := doGitHubRequest("GET", "/installation/repositories", map[string]string{"per_page": "1"}, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return preflightError(resp)
+		}
+		return nil
+	}
+	resp, err := doGitHubRequest("GET", "/user", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return preflightError(resp)
+	}
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return err
+	}
+	if !strings.EqualFold(user.Login, config.GitHubUser) {
+		log.Printf("⚠️ GITHUB_TOKEN belongs to %s, not GITHUB_USER=%s", user.Login, config.GitHubUser)
+	}
+	// Only classic tokens report their scopes, fine-grained ones have no such header
+	if header, ok := resp.Header["X-Oauth-Scopes"]; ok {
+		scopes := splitList(strings.Join(header, ","))
+		if missing := missingScopes(scopes, []string{"repo"}); len(missing) > 0 {
+			return fmt.Errorf("missing scope %s", strings.Join(missing, ", "))
+		}
+	} else if strings.HasPrefix(config.GitHubToken, "github_pat_") {
+		checkFineGrainedGitHubToken()
+	}
+	return nil
+}
+
+// checkFineGrainedGitHubToken warns about the permissions a fine-grained token seems to lack.
+// They can't be listed, so the token tries what the run does with the first listed repo:
+// listing needs access to the repos (Metadata: read), cloning Contents: read. A 403 names
+// the missing permission in X-Accepted-GitHub-Permissions, e.g. contents=read.
+// Docs: https://docs.github.com/en/rest/authentication/permissions-required-for-fine-grained-personal-access-tokens
+func checkFineGrainedGitHubToken() {
+	listPath, owner := "/user/repos", "the token's owner"
+	if len(config.GitHubOrgs) > 0 {
+		org := config.GitHubOrgs[0]
+		listPath, owner = "/orgs/"+url.PathEscape(org)+"/repos", "organization "+org
+	}
+	resp, err := doGitHubRequest("GET", listPath, map[string]string{"per_page": "1"}, nil)
+	if err != nil {
+		log.Printf("⚠️ Failed to check the permissions of the fine-grained GITHUB_TOKEN: %v", err)
+		return
+	}
+	var repos []GitHubRepo
+	if err := handleGitHubResponse(resp, &repos); err != nil {
+		log.Printf("⚠️ The fine-grained GITHUB_TOKEN can't list the repos of %s (resource owner and repository access of the token): %v", owner, err)
+		return
+	}
+	if len(repos) == 0 {
+		log.Printf("⚠️ The fine-grained GITHUB_TOKEN sees no repos of %s: check its resource owner and repository access", owner)
+		return
+	}
+	repo := repos[0]
+	resp, err = doGitHubRequest("GET", "/repos/"+repo.FullName+"/commits", map[string]string{"per_page": "1"}, nil)
+	if err != nil {
+		log.Printf("⚠️ Failed to check the permissions of the fine-grained GITHUB_TOKEN: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusConflict: // 409 for an empty repo
+		log.Printf("The fine-grained GITHUB_TOKEN can read the contents of %s", repo.FullName)
+	default:
+		needed := resp.Header.Get("X-Accepted-GitHub-Permissions")
+		if needed == "" {
+			needed = "contents=read"
+		}
+		log.Printf("⚠️ The fine-grained GITHUB_TOKEN can't read the contents of %s (%d), cloning will fail: grant it the Contents: read permission (%s)", repo.FullName, resp.StatusCode, needed)
+	}
+	if config.Direction == "target-to-github" {
+		// creating and updating repos can't be tried without doing it
+		log.Printf("Note: -direction target-to-github with a fine-grained GITHUB_TOKEN needs Administration: write and Contents: write on %s", owner)
+	}
+}
+
+// Get the current personal access token
+// Docs: https://docs.gitlab.com/ee/api/personal_access_tokens.html#get-single-personal-access-token
+func checkGitLabToken() error {
+	if config.GitLabAuthMode == "job-token" {
+		// CI job tokens can't call these endpoints, and are only valid for the job anyway
+		return nil
+	}
+	resp, err := doGitLabRequest("GET", "/api/v4/personal_access_tokens/self", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// OAuth tokens aren't access tokens, so there is nothing to look up for them
+	oauth := config.GitLabAuthScheme == "bearer" && resp.StatusCode == http.StatusUnauthorized
+	if resp.StatusCode == http.StatusNotFound || oauth {
+		// older GitLab versions: at least check that it authenticates
+		if resp, err = doGitLabRequest("GET", "/api/v4/user", nil, nil); err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return preflightError(resp)
+		}
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return preflightError(resp)
+	}
+	var token struct {
+		Scopes []string `json:"scopes"`
+		Active bool     `json:"active"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	if !token.Active {
+		return fmt.Errorf("the token is revoked or expired")
+	}
+	if missing := missingScopes(token.Scopes, []string{"api", "write_repository"}); len(missing) > 0 {
+		return fmt.Errorf("missing scope %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Get the authenticated user
+// https://codeberg.org/api/swagger#/user/userGetCurrent
+func checkGiteaToken() error {
+	resp, err := doGiteaRequest("GET", "/api/v1/user", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return preflightError(resp)
+	}
+	if config.GiteaOrg != "" {
+		return checkGiteaOrgPermissions()
+	}
+	return nil
+}
+
+// Get user permissions in organization
+// https://codeberg.org/api/swagger#/user/orgGetUserPermissions
+func checkGiteaOrgPermissions() error {
+	path := fmt.Sprintf("/api/v1/users/%s/orgs/%s/permissions", url.PathEscape(config.GiteaUser), url.PathEscape(config.GiteaOrg))
+	resp, err := doGiteaRequest("GET", path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("organization %s not found, or %s isn't a member", config.GiteaOrg, config.GiteaUser)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return preflightError(resp)
+	}
+	var perms struct {
+		CanCreateRepository bool `json:"can_create_repository"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&perms); err != nil {
+		return err
+	}
+	if !perms.CanCreateRepository {
+		return fmt.Errorf("%s can't create repos in the organization %s", config.GiteaUser, config.GiteaOrg)
+	}
+	return nil
+}
+
+// List repositories in the workspace; unlike /user it only needs the repository
+// scope the sync needs anyway
+// Docs: https://developer.atlassian.com/cloud/bitbucket/rest/api-group-repositories/#api-repositories-workspace-get
+// Data Center: https://developer.atlassian.com/server/bitbucket/rest/v811/api-group-project/#api-api-latest-projects-projectkey-get
+func checkBitbucketToken() error {
+	if bitbucketServer() {
+		// also catches a wrong project key
+		resp, err := doBitbucketServerRequest("GET", "/projects/"+url.PathEscape(config.BitbucketWs), nil, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return preflightError(resp)
+		}
+		return nil
+	}
+	resp, err := doBitbucketRequest("GET", fmt.Sprintf("/repositories/%s", config.BitbucketWs), map[string]string{"pagelen": "1"}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return preflightError(resp)
+	}
+	if config.BitbucketProject != "" {
+		return checkBitbucketProject()
+	}
+	return nil
+}
+
+// Get a project of the workspace, a wrong key would fail every create
+// Docs: https://developer.atlassian.com/cloud/bitbucket/rest/api-group-projects/#api-workspaces-workspace-projects-project-key-get
+func checkBitbucketProject() error {
+	resp, err := doBitbucketRequest("GET", fmt.Sprintf("/workspaces/%s/projects/%s", config.BitbucketWs, url.PathEscape(config.BitbucketProject)), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("project %s not found in the workspace %s", config.BitbucketProject, config.BitbucketWs)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return preflightError(resp)
+	}
+	return nil
+}
+
+// Get the configured project, which also catches a wrong org or project name
+// Docs: https://learn.microsoft.com/en-us/rest/api/azure/devops/core/projects/get
+func checkAzureToken() error {
+	resp, err := doAzureRequest("GET", "/_apis/projects/"+url.PathEscape(config.AzureProject), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNonAuthoritativeInfo:
+		// a sign-in page instead of a 401
+		return fmt.Errorf("rejected (203), check that it is set correctly and not expired")
+	case http.StatusNotFound:
+		return fmt.Errorf("project %s not found in organization %s, or the token can't see it", config.AzureProject, config.AzureOrg)
+	}
+	return preflightError(resp)
+}
+
+// List the user's repos, the same call and scope the sync and -prune-remote use
+// Docs: https://man.sr.ht/git.sr.ht/api.md#get-apiusernamerepos
+func checkSourceHutToken() error {
+	resp, err := doSourceHutRequest("GET", "/repos", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return preflightError(resp)
+	}
+	return nil
+}
+
+// Resolve the AWS credentials and list one page of repos, which needs codecommit:ListRepositories
+// Docs: https://docs.aws.amazon.com/codecommit/latest/APIReference/API_ListRepositories.html
+func checkCodeCommitCredentials() error {
+	creds, err := getAWSCredentials()
+	if err != nil {
+		return err
+	}
+	log.Printf("Using AWS credentials from %s for CodeCommit in %s", creds.Source, config.CodeCommitRegion)
+	return doCodeCommitRequest("ListRepositories", map[string]string{}, nil)
+}
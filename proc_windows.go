@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "os/exec"
+
+// setProcessGroup is a no-op on Windows: cancelling cmd kills the git process,
+// and cmd.WaitDelay bounds the wait for helpers still holding its output.
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// The log goes to a file, so without this a long run shows nothing on the terminal.
+// Each repo gets one line on stderr: "[12/340] syncing repo-name... ok (3.2s)".
+var progress struct {
+	repo  string
+	line  string
+	start time.Time
+}
+
+// progressStart begins the line of repo i (1-based) out of n.
+func progressStart(i, n int, repoName string) {
+	progressFinish()
+	if config.Quiet {
+		return
+	}
+	progress.repo, progress.start = repoName, time.Now()
+	progress.line = fmt.Sprintf("[%d/%d] syncing %s...", i, n, repoName)
+	// with -verbose the log lines in between would tear the line apart, so it's printed when done
+	if !config.Verbose {
+		fmt.Fprint(os.Stderr, progress.line)
+	}
+}
+
+// progressFinish completes the line of the current repo with its outcome, if any.
+func progressFinish() {
+	if progress.repo == "" {
+		return
+	}
+	status := repoOutcome(progress.repo)
+	elapsed := time.Since(progress.start).Seconds()
+	if config.Verbose {
+		fmt.Fprintf(os.Stderr, "%s %s (%.1fs)\n", progress.line, status, elapsed)
+	} else {
+		fmt.Fprintf(os.Stderr, " %s (%.1fs)\n", status, elapsed)
+	}
+	progress.repo = ""
+}
+
+// repoOutcome sums up the results of repoName over all targets: failed wins over
+// skipped, which wins over ok (created or updated), which wins over unchanged.
+func repoOutcome(repoName string) string {
+	rank := map[string]int{"unchanged": 0, "created": 1, "updated": 1, "skipped": 2, "failed": 3}
+	outcome := "unchanged"
+	for _, r := range results {
+		if r.Repo == repoName && rank[r.Action] > rank[outcome] {
+			outcome = r.Action
+		}
+	}
+	if outcome == "created" || outcome == "updated" {
+		return "ok"
+	}
+	return outcome
+}
+
+// progressSummary prints the totals of the run and where to find the details.
+func progressSummary() {
+	progressFinish()
+	if config.Quiet {
+		return
+	}
+	counts, _, total := repoOutcomes()
+	fmt.Fprintf(os.Stderr, "%d repo(s): %d ok, %d unchanged, %d skipped, %d failed; log: %s\n",
+		total, counts["ok"], counts["unchanged"], counts["skipped"], counts["failed"], logFilePath)
+}
+
+// repoOutcomes counts the repos of the run by their repoOutcome, and lists the failed ones.
+func repoOutcomes() (counts map[string]int, failed []string, total int) {
+	counts = map[string]int{}
+	seen := map[string]bool{}
+	for _, r := range results {
+		if seen[r.Repo] {
+			continue
+		}
+		seen[r.Repo] = true
+		outcome := repoOutcome(r.Repo)
+		counts[outcome]++
+		if outcome == "failed" {
+			failed = append(failed, r.Repo)
+		}
+	}
+	return counts, failed, len(seen)
+}
+
+// printFatal shows the error that ends the run on the terminal too, unless -verbose already did.
+func printFatal(msg string) {
+	if config.Verbose || logFilePath == "" {
+		return
+	}
+	progressFinish()
+	fmt.Fprintln(os.Stderr, msg)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm asks question on the terminal and reports whether the user typed answer.
+// All logs go to the log file, so the prompt is written to stderr.
+func confirm(question, answer string) bool {
+	fmt.Fprintf(os.Stderr, "%s [type %q to confirm]: ", question, answer)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(line), answer)
+}
+
+// confirmChange asks before change (e.g. "change the visibility private -> public") is made
+// to the existing repoName on target, with -interactive, and reports whether to go ahead.
+// Without -interactive, as in CI, and with -yes, changes are made without asking.
+func confirmChange(target, repoName, change string) bool {
+	if !config.Interactive || config.AssumeYes || config.DryRun {
+		return true
+	}
+	// the prompt gets its own line, the progress line of the repo is continued afterwards
+	inProgress := progress.repo != "" && !config.Verbose
+	if inProgress {
+		fmt.Fprintln(os.Stderr)
+	}
+	fmt.Fprintf(os.Stderr, "%s on %s: %s? [y/N]: ", repoName, target, change)
+	line, _ := stdin.ReadString('\n')
+	if inProgress {
+		fmt.Fprint(os.Stderr, progress.line)
+	}
+	if answer := strings.ToLower(strings.TrimSpace(line)); answer == "y" || answer == "yes" {
+		return true
+	}
+	log.Printf("⏭️ Not confirmed, didn't %s %s on %s", change, repoName, target)
+	return false
+}
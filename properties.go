@@ -0,0 +1,172 @@
+// GitHub custom properties of org repos, copied to GitLab as project labels with -sync-properties
+// Docs: https://docs.github.com/en/rest/repos/custom-properties
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// propertyLabelColor is the color of the labels created for custom properties.
+const propertyLabelColor = "#6699cc"
+
+// propertyLabelDescription marks the labels git-sync created for a property, only those
+// are deleted when the property changes.
+const propertyLabelDescription = "GitHub custom property "
+
+type gitHubPropertyValue struct {
+	PropertyName string `json:"property_name"`
+	// Value is a string, a list of strings for multi_select, or null when not set
+	Value any `json:"value"`
+}
+
+type GitLabLabel struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// IsProjectLabel is false for the labels of the project's groups, which are listed too
+	IsProjectLabel bool `json:"is_project_label"`
+}
+
+// Get all custom property values for a repository
+// Docs: https://docs.github.com/en/rest/repos/custom-properties#get-all-custom-property-values-for-a-repository
+func getGitHubCustomProperties(fullName string) (map[string]any, error) {
+	resp, err := doGitHubRequest("GET", "/repos/"+fullName+"/properties/values", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var values []gitHubPropertyValue
+	if err := handleGitHubResponse(resp, &values); err != nil {
+		return nil, err
+	}
+	props := make(map[string]any, len(values))
+	for _, v := range values {
+		props[v.PropertyName] = v.Value
+	}
+	return props, nil
+}
+
+// propertyLabels returns the labels of the properties, sorted: <property>::<value>, one per
+// value of a multi_select property, which GitLab shows as scoped labels. Unset ones have none.
+func propertyLabels(props map[string]any) []string {
+	var labels []string
+	for name, value := range props {
+		switch value := value.(type) {
+		case string:
+			labels = append(labels, name+"::"+value)
+		case []any:
+			for _, v := range value {
+				if s, ok := v.(string); ok {
+					labels = append(labels, name+"::"+s)
+				}
+			}
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// syncProperties copies the custom properties of repo to destName on target. GitLab is the
+// only target with labels on the project itself, the others are skipped.
+func syncProperties(target string, repo GitHubRepo, destName string) error {
+	labels := propertyLabels(repo.CustomProperties)
+	switch target {
+	case "gitlab":
+		return syncGitLabPropertyLabels(destName, labels)
+	default:
+		if len(labels) > 0 {
+			log.Printf("Skipping the custom properties of %s: %s has no project labels", repo.Name, target)
+		}
+		return nil
+	}
+}
+
+// syncGitLabPropertyLabels creates the missing labels of the project, and deletes the labels
+// of earlier property values, recognized by their description.
+func syncGitLabPropertyLabels(repoName string, labels []string) error {
+	if config.DryRun {
+		if len(labels) > 0 {
+			log.Printf("[dry-run] Would set the GitLab labels of %s -> %s", repoName, strings.Join(labels, ", "))
+		}
+		return nil
+	}
+	_, namespace := resolveGitLabNamespace()
+	labelsPath := "/api/v4/projects/" + gitLabPathID(namespace+"/"+repoName) + "/labels"
+	existing, err := listGitLabLabels(labelsPath)
+	if err != nil {
+		return fmt.Errorf("listing labels: %w", err)
+	}
+	have := map[string]bool{}
+	for _, l := range existing {
+		have[l.Name] = true
+		if l.IsProjectLabel && strings.HasPrefix(l.Description, propertyLabelDescription) && !Contains(labels, l.Name) {
+			if err := deleteGitLabLabel(labelsPath, l.ID); err != nil {
+				return fmt.Errorf("deleting label %s: %w", l.Name, err)
+			}
+			log.Printf("Deleted GitLab label %s of %s", l.Name, repoName)
+			markAction("updated")
+		}
+	}
+	for _, label := range labels {
+		if have[label] {
+			continue
+		}
+		property, _, _ := strings.Cut(label, "::")
+		byts, _ := json.Marshal(map[string]string{
+			"name":        label,
+			"color":       propertyLabelColor,
+			"description": propertyLabelDescription + property,
+		})
+		resp, err := doGitLabRequest("POST", labelsPath, nil, bytes.NewReader(byts))
+		if err != nil {
+			return err
+		}
+		if _, err := handleGitLabResponse(resp, &GitLabLabel{}); err != nil {
+			return fmt.Errorf("creating label %s: %w", label, err)
+		}
+		log.Printf("Created GitLab label %s of %s", label, repoName)
+		markAction("updated")
+	}
+	return nil
+}
+
+// List labels
+// Docs: https://docs.gitlab.com/ee/api/labels.html#list-labels
+func listGitLabLabels(path string) ([]GitLabLabel, error) {
+	var labels []GitLabLabel
+	for page := 1; ; page++ {
+		resp, err := doGitLabRequest("GET", path, map[string]string{
+			"per_page": "100",
+			"page":     fmt.Sprint(page),
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		var batch []GitLabLabel
+		if _, err := handleGitLabResponse(resp, &batch); err != nil {
+			return nil, err
+		}
+		labels = append(labels, batch...)
+		if len(batch) == 0 || resp.Header.Get("X-Next-Page") == "" {
+			return labels, nil
+		}
+	}
+}
+
+// Delete a label
+// Docs: https://docs.gitlab.com/ee/api/labels.html#delete-a-label
+func deleteGitLabLabel(path string, id int) error {
+	resp, err := doGitLabRequest("DELETE", fmt.Sprintf("%s/%d", path, id), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return newAPIError("GitLab", resp)
+}
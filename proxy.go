@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// http.ProxyFromEnvironment only sees the real environment and reads it once, so the
+// proxy is resolved here from HTTPS_PROXY/HTTP_PROXY and NO_PROXY (env or -config file)
+// and set explicitly on the API transports and on every git command.
+
+// configureProxy applies config.Proxy and config.NoProxy to the transport of every
+// service client, or the proxy of its ProxyEnv setting if that is set.
+func configureProxy() error {
+	noProxy := splitList(config.NoProxy)
+	for _, sc := range serviceClients {
+		sc.proxy = config.Proxy
+		if sc.opts.ProxyEnv != "" {
+			sc.proxy = getEnv(sc.opts.ProxyEnv, config.Proxy)
+		}
+		if sc.proxy == "" {
+			sc.transport.Proxy = nil
+			continue
+		}
+		proxyURL, err := parseProxyURL(sc.proxy)
+		if err != nil {
+			return fmt.Errorf("%q: %w", sc.proxy, err)
+		}
+		sc.transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if bypassProxy(req.URL.Hostname(), noProxy) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+	}
+	return nil
+}
+
+// parseProxyURL parses a proxy setting; like curl, a bare host:port means an http:// proxy.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Host == "" {
+		if proxyURL, err = url.Parse("http://" + proxy); err != nil {
+			return nil, err
+		}
+		if proxyURL.Host == "" {
+			return nil, fmt.Errorf("no host")
+		}
+	}
+	return proxyURL, nil
+}
+
+// bypassProxy reports whether host matches NO_PROXY: "*" matches everything,
+// "example.com" and ".example.com" match the domain and its subdomains.
+func bypassProxy(host string, noProxy []string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range noProxy {
+		entry = strings.ToLower(entry)
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		if entry == "*" || host == strings.TrimPrefix(entry, ".") || strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitProxyArgs returns the git options passing the proxy to a git command.
+func gitProxyArgs() []string {
+	if config.Proxy == "" {
+		return nil
+	}
+	return []string{"-c", "http.proxy=" + config.Proxy}
+}
+
+// proxyPasswords are the passwords of the proxy URLs, if any, so they're masked in the log.
+func proxyPasswords() []string {
+	var passwords []string
+	for _, proxy := range append([]string{config.Proxy}, Map(serviceClients, func(sc *serviceClient) string { return sc.proxy })...) {
+		if u, err := url.Parse(proxy); err == nil && u.User != nil {
+			if p, ok := u.User.Password(); ok {
+				passwords = append(passwords, p)
+			}
+		}
+	}
+	return passwords
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// serviceClientOf returns the serviceClient entry of c.
+func serviceClientOf(t *testing.T, c *http.Client) *serviceClient {
+	for _, sc := range serviceClients {
+		if sc.client == c {
+			return sc
+		}
+	}
+	t.Fatal("not a service client")
+	return nil
+}
+
+func TestConfigureProxy(t *testing.T) {
+	withConfig(t)
+	for _, sc := range serviceClients {
+		sc := sc
+		oldProxy, oldFunc := sc.proxy, sc.transport.Proxy
+		t.Cleanup(func() { sc.proxy, sc.transport.Proxy = oldProxy, oldFunc })
+	}
+	config.Proxy = "http://corp-proxy:3128"
+	config.NoProxy = "internal.example.com, .corp:443"
+	t.Setenv("GITHUB_PROXY", "http://github-proxy:8080")
+	t.Setenv("GITLAB_PROXY", "")
+	if err := configureProxy(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		client *http.Client
+		url    string
+		want   string // "" for a direct connection
+	}{
+		{"GITHUB_PROXY wins over HTTPS_PROXY", ghClient, "https://api.github.com/user/repos", "http://github-proxy:8080"},
+		{"GITHUB_PROXY honors NO_PROXY", ghClient, "https://github.internal.example.com/api/v3", ""},
+		{"HTTPS_PROXY without a service proxy", glClient, "https://gitlab.com/api/v4/projects", "http://corp-proxy:3128"},
+		{"NO_PROXY domain", glClient, "https://internal.example.com/api/v4/projects", ""},
+		{"NO_PROXY subdomain", glClient, "https://gitlab.internal.example.com/api/v4/projects", ""},
+		{"NO_PROXY leading dot and port", glClient, "https://git.corp/api/v4/projects", ""},
+		{"NO_PROXY is not a substring match", glClient, "https://notinternal.example.com/api/v4/projects", "http://corp-proxy:3128"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			proxy := serviceClientOf(t, tt.client).transport.Proxy
+			if proxy == nil {
+				t.Fatal("transport has no proxy func")
+			}
+			got, err := proxy(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotURL := ""
+			if got != nil {
+				gotURL = got.String()
+			}
+			if gotURL != tt.want {
+				t.Errorf("proxy = %q, want %q", gotURL, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// pruneCandidate is a destination repo whose GitHub source is gone.
+type pruneCandidate struct {
+	Name   string
+	remove func(archive bool) error
+}
+
+// pruneRemote deletes (or archives) destination repos which no longer exist on GitHub.
+// githubNames must be the complete, unfiltered GitHub listing of this run, they are
+// compared to the destination repos after targetName.
+//
+// Since this is destructive, a destination repo is only pruned if it used to be
+// a GitHub repo and has been missing from PRUNE_MIN_MISSING_RUNS consecutive listings,
+// so repos created natively on the destination and transient GitHub hiccups are safe.
+func pruneRemote(target string, githubNames []string) error {
+	current := make(map[string]bool, len(githubNames))
+	for _, name := range githubNames {
+		current[strings.ToLower(targetName(name))] = true
+	}
+
+	var candidates []pruneCandidate
+	add := func(name string, remove func(archive bool) error) {
+		if current[strings.ToLower(name)] {
+			return
+		}
+		if !state.confirmedGone(name) {
+			log.Printf("Not pruning %s: not a known GitHub repo, or missing for less than %d run(s)", name, config.PruneMinMissingRuns)
+			return
+		}
+		candidates = append(candidates, pruneCandidate{Name: name, remove: remove})
+	}
+	switch target {
+	case "gitlab":
+		projects, err := listGitLabProjects()
+		if err != nil {
+			return err
+		}
+		for _, p := range projects {
+			p := p
+			if config.PruneArchive && p.Archived {
+				continue
+			}
+			add(p.Path, func(archive bool) error { return deleteGitLabProject(p, archive) })
+		}
+	case "gitea", "codeberg":
+		repos, err := listGiteaRepos(config.GiteaOwner)
+		if err != nil {
+			return err
+		}
+		for _, r := range repos {
+			r := r
+			if config.PruneArchive && r.Archived {
+				continue
+			}
+			add(r.Name, func(archive bool) error { return deleteGiteaRepo(config.GiteaOwner, r.Name, archive) })
+		}
+	case "bitbucket":
+		repos, err := listBitbucketRepos(config.BitbucketWs)
+		if err != nil {
+			return err
+		}
+		for _, r := range repos {
+			r := r
+			add(r.Slug, func(archive bool) error {
+				if archive {
+					return fmt.Errorf("Bitbucket does not support archiving repositories")
+				}
+				return deleteBitbucketRepo(config.BitbucketWs, r.Slug)
+			})
+		}
+	case "sourcehut":
+		repos, err := listSourceHutRepos()
+		if err != nil {
+			return err
+		}
+		for _, r := range repos {
+			r := r
+			add(r.Name, func(archive bool) error {
+				if archive {
+					return fmt.Errorf("SourceHut does not support archiving repositories")
+				}
+				return deleteSourceHutRepo(r.Name)
+			})
+		}
+	case "codecommit":
+		repos, err := listCodeCommitRepos()
+		if err != nil {
+			return err
+		}
+		for _, r := range repos {
+			r := r
+			add(r.RepositoryName, func(archive bool) error {
+				if archive {
+					return fmt.Errorf("CodeCommit does not support archiving repositories")
+				}
+				return deleteCodeCommitRepo(r.RepositoryName)
+			})
+		}
+	case "fs":
+		names, err := listFSRepos()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			name := name
+			add(name, func(archive bool) error {
+				if archive {
+					return fmt.Errorf("a bare repo can't be archived")
+				}
+				return deleteFSRepo(name)
+			})
+		}
+	case "azure":
+		return fmt.Errorf("pruning is not supported for Azure DevOps")
+	}
+
+	if len(candidates) == 0 {
+		log.Printf("🧹 Nothing to prune on %s", target)
+		return nil
+	}
+	action := "delete"
+	if config.PruneArchive {
+		action = "archive"
+	}
+	names := Map(candidates, func(c pruneCandidate) string { return c.Name })
+	log.Printf("🧹 %d repo(s) on %s no longer exist on GitHub: %s", len(candidates), target, strings.Join(names, ", "))
+	if config.DryRun {
+		log.Printf("[dry-run] Would %s %d repo(s) on %s", action, len(candidates), target)
+		return nil
+	}
+	// -interactive asks for each repo below instead
+	if !config.AssumeYes && !config.Interactive {
+		// on stderr like the prompt itself, so the list shows even when stdout is redirected
+		fmt.Fprintf(os.Stderr, "The following %d repo(s) on %s will be %sd:\n  %s\n", len(candidates), target, action, strings.Join(names, "\n  "))
+		if !confirm(fmt.Sprintf("%s them?", action), "yes") {
+			log.Printf("🧹 Pruning not confirmed, skipped")
+			return nil
+		}
+	}
+	for _, c := range candidates {
+		if !confirmChange(target, c.Name, action) {
+			continue
+		}
+		if err := c.remove(config.PruneArchive); err != nil {
+			log.Printf("🚫 Failed to %s %s on %s: %v", action, c.Name, target, err)
+			continue
+		}
+		log.Printf("🗑️ %sd %s on %s", action, c.Name, target)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// listRefs returns the refs of the mirror at localPath as a map from ref name to object id.
+func listRefs(localPath string) (map[string]string, error) {
+	out, err := runCmdOutput(nil, "git", "--git-dir", localPath, "for-each-ref", "--format=%(objectname) %(refname)")
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if sha, ref, ok := strings.Cut(line, " "); ok {
+			refs[ref] = sha
+		}
+	}
+	return refs, nil
+}
+
+// listRemoteRefs returns the refs of the remote repo at rawURL as a map from ref name to
+// object id, leaving out HEAD and the peeled ^{} lines of annotated tags.
+func listRemoteRefs(rawURL string) (map[string]string, error) {
+	out, err := runCmdOutput(nil, "git", "ls-remote", rawURL)
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		sha, ref, ok := strings.Cut(line, "\t")
+		if ok && strings.HasPrefix(ref, "refs/") && !strings.HasSuffix(ref, "^{}") {
+			refs[ref] = sha
+		}
+	}
+	return refs, nil
+}
+
+// verifyPush compares the refs of the mirror at localPath matching refspecs with the refs of
+// pushURL after a push: a push can succeed while the remote skipped some refs, e.g. protected
+// branches. Refs only the remote has (like GitLab's refs/merge-requests/*) don't count.
+func verifyPush(localPath, pushURL string, refspecs []string) error {
+	local, err := listRefs(localPath)
+	if err != nil {
+		return err
+	}
+	remote, err := listRemoteRefs(pushURL)
+	if err != nil {
+		return fmt.Errorf("verifying the push: %w", err)
+	}
+	var missing, differing []string
+	for ref, sha := range local {
+		for _, spec := range refspecs {
+			dst, ok := mapRefspec(spec, ref)
+			if !ok {
+				continue
+			}
+			if remoteSHA, found := remote[dst]; !found {
+				missing = append(missing, dst)
+			} else if remoteSHA != sha {
+				differing = append(differing, dst)
+			}
+			break
+		}
+	}
+	if len(missing) == 0 && len(differing) == 0 {
+		log.Printf("✅ Verified the refs pushed from %s", localPath)
+		return nil
+	}
+	sort.Strings(missing)
+	sort.Strings(differing)
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("%d ref(s) missing (%s)", len(missing), abbreviateList(missing, 5)))
+	}
+	if len(differing) > 0 {
+		problems = append(problems, fmt.Sprintf("%d ref(s) pointing elsewhere (%s)", len(differing), abbreviateList(differing, 5)))
+	}
+	return fmt.Errorf("push reported success, but the destination has %s", strings.Join(problems, " and "))
+}
+
+// mapRefspec returns the destination of ref under a normalized refspec like
+// +refs/heads/*:refs/heads/*, or false if the refspec doesn't match ref.
+func mapRefspec(spec, ref string) (string, bool) {
+	src, dst, _ := strings.Cut(strings.TrimPrefix(spec, "+"), ":")
+	prefix, suffix, wildcard := strings.Cut(src, "*")
+	if !wildcard {
+		return dst, ref == src
+	}
+	if len(ref) < len(prefix)+len(suffix) || !strings.HasPrefix(ref, prefix) || !strings.HasSuffix(ref, suffix) {
+		return "", false
+	}
+	match := ref[len(prefix) : len(ref)-len(suffix)]
+	return strings.Replace(dst, "*", match, 1), true
+}
+
+// abbreviateList joins the first n items, noting how many were left out.
+func abbreviateList(items []string, n int) string {
+	if len(items) <= n {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:n], ", "), len(items)-n)
+}
+
+// refsHash returns a digest of all refs of the mirror at localPath and where they point.
+func refsHash(localPath string) (string, error) {
+	out, err := runCmdOutput(nil, "git", "--git-dir", localPath, "for-each-ref", "--format=%(objectname) %(refname)")
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(out))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// localBranchExists reports whether the mirror at localPath has the branch.
+func localBranchExists(localPath, branch string) bool {
+	_, err := runCmdOutput(nil, "git", "--git-dir", localPath, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch)
+	return err == nil
+}
+
+// deleteRefs deletes every ref of refs that lives below one of the namespaces
+// (e.g. "refs/pull/") from the mirror at localPath, and returns how many were deleted.
+func deleteRefs(localPath string, refs map[string]string, namespaces []string) (int, error) {
+	var names []string
+	for ref := range refs {
+		for _, ns := range namespaces {
+			if strings.HasPrefix(ref, ns) {
+				names = append(names, ref)
+				break
+			}
+		}
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+	sort.Strings(names)
+	var stdin strings.Builder
+	for _, ref := range names {
+		fmt.Fprintf(&stdin, "delete %s\n", ref)
+	}
+	if _, err := runCmdOutput(strings.NewReader(stdin.String()), "git", "--git-dir", localPath, "update-ref", "--stdin"); err != nil {
+		return 0, err
+	}
+	return len(names), nil
+}
+
+// checkRefCount enforces MAX_REFS on the mirror at localPath.
+// It returns false if the repo should be skipped.
+func checkRefCount(repoName, localPath string) bool {
+	refs, err := listRefs(localPath)
+	if err != nil {
+		log.Printf("⚠️ Failed to count refs of %s: %v", repoName, err)
+		return true
+	}
+	log.Printf("%s has %d refs", repoName, len(refs))
+	if len(refs) <= config.MaxRefs {
+		return true
+	}
+	result := refCountResult{Refs: len(refs), Action: config.MaxRefsAction}
+	defer func() {
+		if summary.refCounts == nil {
+			summary.refCounts = make(map[string]refCountResult)
+		}
+		summary.refCounts[repoName] = result
+	}()
+	switch config.MaxRefsAction {
+	case "skip":
+		log.Printf("🚫 Skipping %s: %d refs exceed MAX_REFS=%d", repoName, len(refs), config.MaxRefs)
+		return false
+	case "filter":
+		deleted, err := deleteRefs(localPath, refs, config.MaxRefsFilter)
+		if err != nil {
+			log.Printf("⚠️ Failed to filter refs of %s: %v", repoName, err)
+			return true
+		}
+		result.Refs -= deleted
+		log.Printf("✂️ %s: %d refs exceed MAX_REFS=%d, removed %d refs under %s, %d left",
+			repoName, len(refs), config.MaxRefs, deleted, strings.Join(config.MaxRefsFilter, ", "), result.Refs)
+	default:
+		log.Printf("⚠️ %s: %d refs exceed MAX_REFS=%d, the push may be slow or rejected", repoName, len(refs), config.MaxRefs)
+	}
+	return true
+}
+
+// stripPullRefs deletes the refs/pull/* refs GitHub adds for every pull request from the
+// mirror at localPath, so they aren't pushed to the destinations. Branches and tags stay.
+func stripPullRefs(repoName, localPath string) {
+	refs, err := listRefs(localPath)
+	if err != nil {
+		log.Printf("⚠️ Failed to list refs of %s: %v", repoName, err)
+		return
+	}
+	deleted, err := deleteRefs(localPath, refs, []string{"refs/pull/"})
+	if err != nil {
+		log.Printf("⚠️ Failed to remove the pull request refs of %s: %v", repoName, err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("✂️ %s: removed %d pull request refs (use -strip-pr-refs=false to keep them)", repoName, deleted)
+	}
+}
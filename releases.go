@@ -0,0 +1,340 @@
+// GitHub releases and their assets, which live outside of git and aren't carried over by the mirror push
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+type GitHubRelease struct {
+	ID         int                  `json:"id"`
+	TagName    string               `json:"tag_name"`
+	Name       string               `json:"name"`
+	Body       string               `json:"body"`
+	Draft      bool                 `json:"draft"`
+	Prerelease bool                 `json:"prerelease"`
+	Assets     []GitHubReleaseAsset `json:"assets"`
+}
+
+type GitHubReleaseAsset struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// URL is the API URL, which serves the file with Accept: application/octet-stream
+	URL string `json:"url"`
+}
+
+// https://docs.github.com/en/rest/releases/releases#list-releases
+func getGitHubReleases(fullName string) ([]GitHubRelease, error) {
+	return listGitHubPages[GitHubRelease]("/repos/"+fullName+"/releases", map[string]string{"per_page": strconv.Itoa(config.PerPage)})
+}
+
+// Assets are downloaded once per repo into a temp dir and shared by all targets.
+var (
+	assetDir     string
+	assetDirDone func()
+)
+
+// downloadGitHubAsset returns the path of the downloaded asset.
+// https://docs.github.com/en/rest/releases/assets#get-a-release-asset
+func downloadGitHubAsset(asset GitHubReleaseAsset) (string, error) {
+	if assetDir == "" {
+		dir, err := os.MkdirTemp("", "git-sync-assets-")
+		if err != nil {
+			return "", err
+		}
+		assetDir = dir
+		assetDirDone = registerCleanup(func() { os.RemoveAll(dir) })
+	}
+	path := filepath.Join(assetDir, strconv.Itoa(asset.ID))
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	req, err := http.NewRequest("GET", asset.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	user, token, err := githubCredentials()
+	if err != nil {
+		return "", err
+	}
+	// the redirect to the storage host drops the credentials
+	req.SetBasicAuth(user, token)
+	setGitHubAPIHeaders(req)
+	req.Header.Set("Accept", "application/octet-stream")
+	resp, err := doWithRetry(transferClient, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading %s: status %d", asset.Name, resp.StatusCode)
+	}
+	f, err := os.Create(path + ".part")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".part")
+		return "", fmt.Errorf("downloading %s: %w", asset.Name, err)
+	}
+	return path, os.Rename(path+".part", path)
+}
+
+// clearReleaseAssets removes the assets downloaded for the current repo.
+func clearReleaseAssets() {
+	if assetDirDone != nil {
+		assetDirDone()
+	}
+	assetDir, assetDirDone = "", nil
+}
+
+// fileBody returns a replayable request body reading the file at path.
+func fileBody(req *http.Request, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	req.GetBody = func() (io.ReadCloser, error) { return os.Open(path) }
+	req.Body, err = req.GetBody()
+	req.ContentLength = info.Size()
+	return err
+}
+
+// syncReleases creates the published GitHub releases of repo that are missing on target,
+// where it is named destName, and uploads their missing assets. Drafts are skipped, their
+// tags may not exist yet.
+func syncReleases(target string, repo GitHubRepo, destName string) error {
+	var sync func(GitHubRelease) error
+	switch target {
+	case "gitlab":
+		_, namespace := resolveGitLabNamespace()
+		projID := gitLabPathID(namespace + "/" + destName)
+		sync = func(rel GitHubRelease) error { return syncGitLabRelease(projID, rel) }
+	case "gitea", "codeberg":
+		sync = func(rel GitHubRelease) error { return syncGiteaRelease(config.GiteaOwner, destName, rel) }
+	default:
+		log.Printf("Skipping %d release(s) of %s: %s has no releases", len(repo.Releases), repo.Name, target)
+		return nil
+	}
+	for _, rel := range repo.Releases {
+		if rel.Draft {
+			continue
+		}
+		if err := sync(rel); err != nil {
+			return fmt.Errorf("release %s: %w", rel.TagName, err)
+		}
+	}
+	return nil
+}
+
+type gitLabRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  struct {
+		Links []struct {
+			Name string `json:"name"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+// genericPackageInvalid matches what a generic package version or file name can't contain.
+var genericPackageInvalid = regexp.MustCompile(`[^A-Za-z0-9._+-]+`)
+
+// Releases: https://docs.gitlab.com/ee/api/releases/
+// Assets are uploaded as generic packages and linked to the release:
+// https://docs.gitlab.com/ee/user/packages/generic_packages/
+// https://docs.gitlab.com/ee/api/releases/links.html
+func syncGitLabRelease(projID string, rel GitHubRelease) error {
+	releasePath := "/api/v4/projects/" + projID + "/releases/" + gitLabPathID(rel.TagName)
+	resp, err := doGitLabRequest("GET", releasePath, nil, nil)
+	if err != nil {
+		return err
+	}
+	var existing gitLabRelease
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		if config.DryRun {
+			log.Printf("[dry-run] Would create GitLab release %s with %d asset(s)", rel.TagName, len(rel.Assets))
+			return nil
+		}
+		byts, _ := json.Marshal(map[string]any{"tag_name": rel.TagName, "name": rel.Name, "description": rel.Body})
+		resp, err := doGitLabRequest("POST", "/api/v4/projects/"+projID+"/releases", nil, bytes.NewReader(byts))
+		if err != nil {
+			return err
+		}
+		if _, err := handleGitLabResponse(resp, &existing); err != nil {
+			return err
+		}
+		log.Printf("Created GitLab release %s", rel.TagName)
+		markAction("updated")
+	} else if _, err := handleGitLabResponse(resp, &existing); err != nil {
+		return err
+	}
+
+	linked := map[string]bool{}
+	for _, l := range existing.Assets.Links {
+		linked[l.Name] = true
+	}
+	version := genericPackageInvalid.ReplaceAllString(rel.TagName, "-")
+	for _, asset := range rel.Assets {
+		if linked[asset.Name] {
+			continue
+		}
+		if config.DryRun {
+			log.Printf("[dry-run] Would upload %s to GitLab release %s", asset.Name, rel.TagName)
+			continue
+		}
+		path, err := downloadGitHubAsset(asset)
+		if err != nil {
+			return err
+		}
+		packagePath := "/api/v4/projects/" + projID + "/packages/generic/github-releases/" + version + "/" + genericPackageInvalid.ReplaceAllString(asset.Name, "-")
+		req, err := http.NewRequest("PUT", config.GitLabURL+packagePath, nil)
+		if err != nil {
+			return err
+		}
+		if err := fileBody(req, path); err != nil {
+			return err
+		}
+		setGitLabAuth(req)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp, err := doWithRetry(transferClient, req)
+		if err != nil {
+			return err
+		}
+		var uploaded map[string]any
+		if _, err := handleGitLabResponse(resp, &uploaded); err != nil {
+			return fmt.Errorf("uploading %s: %w", asset.Name, err)
+		}
+		byts, _ := json.Marshal(map[string]string{"name": asset.Name, "url": config.GitLabURL + packagePath, "link_type": "package"})
+		resp, err = doGitLabRequest("POST", releasePath+"/assets/links", nil, bytes.NewReader(byts))
+		if err != nil {
+			return err
+		}
+		if _, err := handleGitLabResponse(resp, &uploaded); err != nil {
+			return fmt.Errorf("linking %s: %w", asset.Name, err)
+		}
+		log.Printf("Uploaded %s to GitLab release %s", asset.Name, rel.TagName)
+		markAction("updated")
+	}
+	return nil
+}
+
+type giteaRelease struct {
+	ID     int `json:"id"`
+	Assets []struct {
+		Name string `json:"name"`
+	} `json:"assets"`
+}
+
+// https://codeberg.org/api/swagger#/repository/repoGetReleaseByTag
+// https://codeberg.org/api/swagger#/repository/repoCreateRelease
+// https://codeberg.org/api/swagger#/repository/repoCreateReleaseAttachment
+func syncGiteaRelease(owner, repoName string, rel GitHubRelease) error {
+	repoPath := "/api/v1/repos/" + owner + "/" + repoName
+	resp, err := doGiteaRequest("GET", repoPath+"/releases/tags/"+url.PathEscape(rel.TagName), nil, nil)
+	if err != nil {
+		return err
+	}
+	var existing giteaRelease
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		if config.DryRun {
+			log.Printf("[dry-run] Would create %s release %s with %d asset(s)", config.GiteaName, rel.TagName, len(rel.Assets))
+			return nil
+		}
+		byts, _ := json.Marshal(map[string]any{"tag_name": rel.TagName, "name": rel.Name, "body": rel.Body, "prerelease": rel.Prerelease})
+		resp, err := doGiteaRequest("POST", repoPath+"/releases", nil, bytes.NewReader(byts))
+		if err != nil {
+			return err
+		}
+		if _, err := handleGiteaResponse(resp, &existing); err != nil {
+			return err
+		}
+		log.Printf("Created %s release %s", config.GiteaName, rel.TagName)
+		markAction("updated")
+	} else if _, err := handleGiteaResponse(resp, &existing); err != nil {
+		return err
+	}
+
+	attached := map[string]bool{}
+	for _, a := range existing.Assets {
+		attached[a.Name] = true
+	}
+	for _, asset := range rel.Assets {
+		if attached[asset.Name] {
+			continue
+		}
+		if config.DryRun {
+			log.Printf("[dry-run] Would upload %s to %s release %s", asset.Name, config.GiteaName, rel.TagName)
+			continue
+		}
+		path, err := downloadGitHubAsset(asset)
+		if err != nil {
+			return err
+		}
+		u := fmt.Sprintf("%s%s/releases/%d/assets?name=%s", config.GiteaURL, repoPath, existing.ID, url.QueryEscape(asset.Name))
+		req, err := http.NewRequest("POST", u, nil)
+		if err != nil {
+			return err
+		}
+		if err := multipartFileBody(req, "attachment", asset.Name, path); err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "token "+config.GiteaToken)
+		resp, err := doWithRetry(transferClient, req)
+		if err != nil {
+			return err
+		}
+		var uploaded map[string]any
+		if _, err := handleGiteaResponse(resp, &uploaded); err != nil {
+			return fmt.Errorf("uploading %s: %w", asset.Name, err)
+		}
+		log.Printf("Uploaded %s to %s release %s", asset.Name, config.GiteaName, rel.TagName)
+		markAction("updated")
+	}
+	return nil
+}
+
+// multipartFileBody sets a replayable multipart/form-data body of the file at path as
+// the only field, streaming the file instead of buffering it.
+func multipartFileBody(req *http.Request, field, fileName, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if _, err := w.CreateFormFile(field, fileName); err != nil {
+		return err
+	}
+	head := append([]byte(nil), buf.Bytes()...)
+	buf.Reset()
+	w.Close()
+	tail := buf.Bytes()
+	req.GetBody = func() (io.ReadCloser, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{io.MultiReader(bytes.NewReader(head), f, bytes.NewReader(tail)), f}, nil
+	}
+	req.Body, err = req.GetBody()
+	req.ContentLength = int64(len(head)) + info.Size() + int64(len(tail))
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Result is the outcome of syncing one repo, reported in <logs>/summary.json.
+type Result struct {
+	Repo      string `json:"repo"`
+	Target    string `json:"target"`
+	Action    string `json:"action"` // created | updated | unchanged | skipped | failed
+	Error     string `json:"error,omitempty"`
+	ElapsedMS int64  `json:"elapsed_ms"`
+
+	start time.Time
+}
+
+var (
+	results []*Result
+	// result of the repo being synced, nil outside of the sync loop
+	currentResult *Result
+)
+
+// startResult finishes the previous repo's result and starts tracking repoName.
+func startResult(repoName, target string) *Result {
+	finishResult()
+	currentResult = &Result{Repo: repoName, Target: target, Action: "unchanged", start: time.Now()}
+	results = append(results, currentResult)
+	return currentResult
+}
+
+// finishResult records the elapsed time of the current result, if any.
+func finishResult() {
+	if currentResult != nil {
+		currentResult.ElapsedMS = time.Since(currentResult.start).Milliseconds()
+		observeResult(currentResult)
+		currentResult = nil
+	}
+}
+
+// fail marks the result as failed with err.
+func (r *Result) fail(err error) {
+	r.Action = "failed"
+	r.Error = err.Error()
+}
+
+// markAction is called by the API and push helpers when they changed the destination;
+// "created" wins over "updated", which wins over "unchanged".
+func markAction(action string) {
+	if currentResult == nil {
+		return
+	}
+	rank := map[string]int{"unchanged": 0, "updated": 1, "created": 2}
+	if cur, ok := rank[currentResult.Action]; ok && rank[action] > cur {
+		currentResult.Action = action
+	}
+}
+
+// recordResults records the same outcome for repoName on every target,
+// for repos which never got as far as a push.
+func recordResults(repoName string, targets []string, action string, err error) {
+	for _, target := range targets {
+		res := startResult(repoName, target)
+		res.Action = action
+		if err != nil {
+			res.Error = err.Error()
+		}
+	}
+}
+
+// failedResults returns how many repos failed so far.
+func failedResults() int {
+	failed := 0
+	for _, r := range results {
+		if r.Action == "failed" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// writeResults saves the per-repo results as JSON into the logs folder.
+func writeResults() {
+	finishResult()
+	data, err := json.MarshalIndent(map[string]any{
+		"run_id":      runID,
+		"dry_run":     config.DryRun,
+		"total":       len(results),
+		"failed":      failedResults(),
+		"not_started": summary.notStarted,
+		"repos":       results,
+	}, "", "  ")
+	if err != nil {
+		log.Printf("⚠️ Failed to encode summary: %v", err)
+		return
+	}
+	summaryPath := runFile("summary.json", "summary.json")
+	if err := os.WriteFile(summaryPath, data, 0644); err != nil {
+		log.Printf("⚠️ Failed to write summary: %v", err)
+		return
+	}
+	log.Printf("📋 Summary written to %s", summaryPath)
+	if config.RunDir != "" {
+		writeFailures(filepath.Join(config.RunDir, "failures.txt"))
+	}
+}
+
+// writeFailures writes one line per failed repo and target to path, for a quick look at
+// a run dir: <repo> <TAB> <target> <TAB> <error>. It is empty if nothing failed.
+func writeFailures(path string) {
+	var b strings.Builder
+	for _, r := range results {
+		if r.Action == "failed" {
+			fmt.Fprintf(&b, "%s\t%s\t%s\n", r.Repo, r.Target, redactText(r.Error))
+		}
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		log.Printf("⚠️ Failed to write failures: %v", err)
+	}
+}
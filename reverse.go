@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// -direction target-to-github swaps the roles: the repos of the one target are mirrored
+// into <backup-dir>/from-<target>/ and pushed to GitHub, creating the GitHub repos as needed.
+
+// listTargetRepos lists the repos of target as GitHubRepos, so the filters and
+// visibility rules work on them unchanged. CloneURL is the unauthenticated URL.
+func listTargetRepos(target string) ([]GitHubRepo, error) {
+	var repos []GitHubRepo
+	switch target {
+	case "gitlab":
+		projects, err := listGitLabProjects()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range projects {
+			repos = append(repos, GitHubRepo{
+				Name:        p.Path,
+				CloneURL:    gitLabRepoURL(p.Path),
+				Private:     p.Visibility != "public",
+				Archived:    p.Archived,
+				Description: p.Description,
+			})
+		}
+	case "gitea", "codeberg":
+		giteaRepos, err := listGiteaRepos(config.GiteaOwner)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range giteaRepos {
+			repos = append(repos, GitHubRepo{
+				Name:        r.Name,
+				CloneURL:    giteaRepoURL(config.GiteaOwner, r.Name),
+				Private:     r.Private,
+				Archived:    r.Archived,
+				Description: r.Description,
+			})
+		}
+	case "bitbucket":
+		bbRepos, err := listBitbucketRepos(config.BitbucketWs)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range bbRepos {
+			repos = append(repos, GitHubRepo{
+				Name:        r.Slug,
+				CloneURL:    bitbucketRepoURL(config.BitbucketWs, r.Slug),
+				Private:     r.IsPrivate,
+				Description: r.Description,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("-direction target-to-github isn't supported for %s", target)
+	}
+	return repos, nil
+}
+
+// targetCloneURL returns the URL with the credentials to clone repo from target.
+func targetCloneURL(target string, repo GitHubRepo) (string, error) {
+	switch target {
+	case "gitlab":
+		user := "oauth2"
+		if config.GitLabAuthMode == "job-token" {
+			user = "gitlab-ci-token"
+		}
+		return withCredentials(repo.CloneURL, user, config.GitLabToken)
+	case "gitea", "codeberg":
+		return withCredentials(repo.CloneURL, config.GiteaUser, config.GiteaToken)
+	case "bitbucket":
+		return bitbucketAuthURL(repo.CloneURL, config.BitbucketToken)
+	}
+	return "", fmt.Errorf("unsupported source %s", target)
+}
+
+// gitHubOwner is the owner of the repos created on GitHub: the (first) GITHUB_ORG, or the user.
+func gitHubOwner() string {
+	if len(config.GitHubOrgs) > 0 {
+		return config.GitHubOrgs[0]
+	}
+	return config.GitHubUser
+}
+
+// gitHubWebURL derives the web (and git) host from GITHUB_API_URL, e.g.
+// https://api.github.com -> https://github.com, https://ghe.example.com/api/v3 -> https://ghe.example.com
+func gitHubWebURL() string {
+	u, err := url.Parse(config.GitHubAPIURL)
+	if err != nil {
+		return "https://github.com"
+	}
+	if u.Host == "api.github.com" {
+		return "https://github.com"
+	}
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/api/v3")
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+// https://docs.github.com/en/rest/repos/repos#get-a-repository
+func getGitHubRepo(owner, repoName string) (*GitHubRepo, error) {
+	resp, err := doGitHubRequest("GET", "/repos/"+owner+"/"+url.PathEscape(repoName), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil
+	}
+	var repo GitHubRepo
+	if err := handleGitHubResponse(resp, &repo); err != nil {
+		return nil, err
+	}
+	return &repo, nil
+}
+
+// https://docs.github.com/en/rest/repos/repos#create-a-repository-for-the-authenticated-user
+// https://docs.github.com/en/rest/repos/repos#create-an-organization-repository
+func createGitHubRepo(owner, repoName string, private bool, description string) error {
+	if config.DryRun {
+		log.Printf("[dry-run] Would create GitHub repo %s/%s (private %v)", owner, repoName, private)
+		return nil
+	}
+	path := "/user/repos"
+	if owner != config.GitHubUser {
+		path = "/orgs/" + url.PathEscape(owner) + "/repos"
+	}
+	byts, _ := json.Marshal(map[string]any{"name": repoName, "private": private, "description": description})
+	resp, err := doGitHubRequest("POST", path, nil, bytes.NewReader(byts))
+	if err != nil {
+		return err
+	}
+	var repo GitHubRepo
+	if err := handleGitHubResponse(resp, &repo); err != nil {
+		return err
+	}
+	log.Printf("Created GitHub repo %s/%s", owner, repoName)
+	markAction("created")
+	return nil
+}
+
+// https://docs.github.com/en/rest/repos/repos#update-a-repository
+func editGitHubRepo(owner, repoName string, fields map[string]any) error {
+	byts, _ := json.Marshal(fields)
+	if config.DryRun {
+		log.Printf("[dry-run] Would update GitHub repo %s/%s: %s", owner, repoName, byts)
+		return nil
+	}
+	resp, err := doGitHubRequest("PATCH", "/repos/"+owner+"/"+url.PathEscape(repoName), nil, bytes.NewReader(byts))
+	if err != nil {
+		return err
+	}
+	var repo GitHubRepo
+	if err := handleGitHubResponse(resp, &repo); err != nil {
+		return err
+	}
+	markAction("updated")
+	return nil
+}
+
+// Ensure the GitHub repository exists and matches desired privacy; create or update as needed.
+func checkAndValidateGitHubRepo(owner, repoName string, private bool, description string) error {
+	repo, err := getGitHubRepo(owner, repoName)
+	if err != nil {
+		return err
+	}
+	if repo == nil {
+		return createGitHubRepo(owner, repoName, private, description)
+	}
+	fields := map[string]any{}
+	if repo.Private != private && confirmChange("github", repoName, fmt.Sprintf("change private %v -> %v", repo.Private, private)) {
+		fields["private"] = private
+	}
+	if repo.Description != description {
+		fields["description"] = description
+	}
+	if len(fields) > 0 {
+		return editGitHubRepo(owner, repoName, fields)
+	}
+	log.Printf("GitHub repo %s/%s exists with desired privacy %v and description", owner, repoName, private)
+	return nil
+}
+
+// syncToGitHub pushes the branches and tags of the mirror at localPath to GitHub;
+// a full --mirror would be rejected for the read-only refs/pull/* GitHub manages.
+func syncToGitHub(owner, repoName, localPath string) error {
+	user, token, err := githubCredentials()
+	if err != nil {
+		return err
+	}
+	pushURL, err := withCredentials(fmt.Sprintf("%s/%s/%s.git", gitHubWebURL(), owner, repoName), user, token)
+	if err != nil {
+		return err
+	}
+	log.Printf("Pushing %s -> GitHub (%s) ...", repoName, owner)
+	return pushRefs(localPath, pushURL, "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+}
+
+// runReverse mirrors the repos of target to GitHub and exits like the normal run.
+func runReverse(target, repoFilter string) {
+	if target == "gitlab" {
+		if err := loadGitLabNamespace(); err != nil {
+			fatal(err)
+		}
+	}
+	repos, err := listTargetRepos(target)
+	if err != nil {
+		fatalf("🚫 Failed to list %s repos: %v", target, err)
+	}
+	log.Printf("Found %d %s repos", len(repos), target)
+	repos = filterByPatterns(repos, config.IncludePatterns, config.ExcludePatterns)
+	if repoFilter != "" {
+		log.Printf("Test mode: filtering to repository %s", repoFilter)
+		var filtered []GitHubRepo
+		for _, r := range repos {
+			if r.Name == repoFilter {
+				filtered = append(filtered, r)
+			}
+		}
+		repos = filtered
+	}
+	if len(repos) == 0 {
+		log.Printf("🚫 No repos found; exiting.")
+		return
+	}
+
+	owner := gitHubOwner()
+	dir := filepath.Join(config.BackupDir, "from-"+target)
+	os.MkdirAll(dir, 0755)
+	for i, repo := range repos {
+		if stopping() {
+			log.Printf("🛑 Interrupted, %d repo(s) not synced", len(repos)-i)
+			break
+		}
+		if pastRunDeadline() {
+			log.Printf("⏰ Run deadline of %v passed, %d repo(s) not synced", config.RunDeadline, len(repos)-i)
+			summary.notStarted = len(repos) - i
+			break
+		}
+		if config.FailFast && failedResults() > 0 {
+			log.Printf("🚫 Stopping after the first failure (-fail-fast), %d repo(s) not synced", len(repos)-i)
+			break
+		}
+		repoName := repo.Name
+		progressStart(i+1, len(repos), repoName)
+		repoLog := logFields{"repo": repoName, "target": "github"}
+		res := startResult(repoName, "github")
+		localPath := filepath.Join(dir, repoName+".git")
+		logWith(repoLog, "🌐 Syncing %s from %s to GitHub", repoName, target)
+
+		authURL, err := targetCloneURL(target, repo)
+		if err == nil {
+			err = tracePhase(repoName, "mirror", func() error {
+				return mirrorRepo(repoName, repo.CloneURL, authURL, localPath, 0)
+			})
+		}
+		if err != nil {
+			logWith(repoLog, "🚫 Failed to mirror %s from %s: %v", repoName, target, err)
+			res.fail(err)
+			continue
+		}
+		private := resolveVisibility(repo) != "public"
+		if err := tracePhase(repoName, "validate", func() error {
+			return checkAndValidateGitHubRepo(owner, repoName, private, repo.Description)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to validate GitHub repo %s: %v", repoName, err)
+			res.fail(err)
+			continue
+		}
+		if err := tracePhase(repoName, "push", func() error {
+			return syncToGitHub(owner, repoName, localPath)
+		}); err != nil {
+			logWith(repoLog, "🚫 Failed to sync %s to GitHub: %v", repoName, err)
+			res.fail(err)
+			continue
+		}
+		logWith(repoLog, "✅ Synced %s from %s to GitHub", repoName, target)
+	}
+	finishResult()
+	progressFinish()
+	writeResults()
+	if config.Trace {
+		writeTrace()
+	}
+	logSummary()
+	progressSummary()
+	notify()
+	finishMetrics()
+	finishRunSpan()
+	if stopping() {
+		log.Printf("🛑 Stopped early (run %s)", runID)
+		exit(130)
+	}
+	if failed := failedResults(); failed > 0 {
+		log.Printf("🚫 %d of %d repo(s) failed, see %s (run %s)", failed, len(results), runFile("summary.json", "summary.json"), runID)
+		exit(1)
+	}
+	if summary.notStarted > 0 {
+		log.Printf("⏰ Stopped at the run deadline, %d repo(s) left for the next run (run %s)", summary.notStarted, runID)
+		return
+	}
+	log.Printf("✅ All Done :), all %s repositories have been synced to GitHub. (run %s)", target, runID)
+}
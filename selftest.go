@@ -0,0 +1,265 @@
+// -self-test: checks the auth and basic permissions of GitHub and of every -target without
+// touching a real repo, by listing one page of GitHub repos and creating, pushing to and
+// deleting a throwaway git-sync-selftest-<random> repo on each target.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const selfTestDescription = "Temporary repo of the git-sync self-test, safe to delete"
+
+// selfTestCheck is the outcome of one capability of one service.
+type selfTestCheck struct {
+	Service    string
+	Capability string
+	Err        error
+	// Skipped is why the capability wasn't tried, e.g. "auth failed"
+	Skipped string
+	// Detail is shown next to a passed check
+	Detail string
+}
+
+// selfTestSteps are the calls of a sync on target, run on the throwaway repo.
+type selfTestSteps struct {
+	create func(name string) error
+	push   func(name, localPath string) error
+	remove func(name string) error
+}
+
+// runSelfTest runs the checks, prints their outcome to w and fails if any check failed.
+func runSelfTest(w io.Writer, targets []string) error {
+	var checks []selfTestCheck
+	run := func(service, capability string, f func() (string, error)) bool {
+		detail, err := f()
+		checks = append(checks, selfTestCheck{Service: service, Capability: capability, Err: err, Detail: detail})
+		if err != nil {
+			log.Printf("🚫 Self-test: %s %s failed: %v", service, capability, err)
+			return false
+		}
+		log.Printf("✅ Self-test: %s %s", service, capability)
+		return true
+	}
+	skip := func(service, reason string, capabilities ...string) {
+		for _, capability := range capabilities {
+			checks = append(checks, selfTestCheck{Service: service, Capability: capability, Skipped: reason})
+		}
+	}
+	noDetail := func(f func() error) func() (string, error) {
+		return func() (string, error) { return "", f() }
+	}
+
+	if run("github", "auth", noDetail(checkGitHubToken)) {
+		run("github", "list", listGitHubSelfTestPage)
+	} else {
+		skip("github", "auth failed", "list")
+	}
+
+	localPath, err := newSelfTestRepo()
+	if err != nil {
+		return fmt.Errorf("creating the self-test repo: %w", err)
+	}
+	defer os.RemoveAll(filepath.Dir(localPath))
+	for _, target := range targets {
+		if target == "local" {
+			// the backup dir is checked when the mirrors are written
+			continue
+		}
+		if !run(target, "auth", noDetail(func() error { return checkTarget(target) })) {
+			skip(target, "auth failed", "create", "push", "delete")
+			continue
+		}
+		steps := selfTestTargetSteps(target)
+		name := "git-sync-selftest-" + randomHex(4)
+		// the repo is deleted even if the run is interrupted between create and delete
+		var removeErr error
+		remove := registerCleanup(func() { removeErr = steps.remove(name) })
+		if !run(target, "create", noDetail(func() error { return steps.create(name) })) {
+			// the create may have got through partway, clean up what's there
+			remove()
+			skip(target, "create failed", "push", "delete")
+			continue
+		}
+		run(target, "push", noDetail(func() error { return steps.push(name, localPath) }))
+		if !run(target, "delete", func() (string, error) { remove(); return name, removeErr }) {
+			log.Printf("⚠️ Delete the self-test repo %s on %s by hand", name, target)
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tCAPABILITY\tRESULT")
+	failed := 0
+	for _, c := range checks {
+		result := "ok"
+		switch {
+		case c.Err != nil:
+			result = "FAILED: " + redactText(c.Err.Error())
+			failed++
+		case c.Skipped != "":
+			result = "skipped (" + c.Skipped + ")"
+		case c.Detail != "":
+			result = "ok (" + c.Detail + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Service, c.Capability, result)
+	}
+	tw.Flush()
+	if failed > 0 {
+		return fmt.Errorf("self-test: %d check(s) failed, see %s", failed, logFilePath)
+	}
+	log.Printf("✅ Self-test passed")
+	return nil
+}
+
+// listGitHubSelfTestPage lists the first page of the repos a sync would list.
+func listGitHubSelfTestPage() (string, error) {
+	path := "/user/repos"
+	switch {
+	case len(config.GitHubOrgs) > 0:
+		path = "/orgs/" + url.PathEscape(config.GitHubOrgs[0]) + "/repos"
+	case githubApp():
+		path = "/installation/repositories"
+	}
+	resp, err := doGitHubRequest("GET", path, map[string]string{"per_page": strconv.Itoa(config.PerPage)}, nil)
+	if err != nil {
+		return "", err
+	}
+	var repos []GitHubRepo
+	if githubApp() && len(config.GitHubOrgs) == 0 {
+		var batch struct {
+			Repositories []GitHubRepo `json:"repositories"`
+		}
+		err = handleGitHubResponse(resp, &batch)
+		repos = batch.Repositories
+	} else {
+		err = handleGitHubResponse(resp, &repos)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d repo(s) on the first page", len(repos)), nil
+}
+
+// newSelfTestRepo creates a bare repo in a temporary dir with one empty commit on main,
+// which is what gets pushed to the targets.
+func newSelfTestRepo() (string, error) {
+	dir, err := os.MkdirTemp("", "git-sync-selftest-")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "selftest.git")
+	if err := runCmd("git", "init", "--bare", "--quiet", path); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	tree, err := runCmdOutput(strings.NewReader(""), "git", "--git-dir", path, "mktree")
+	if err == nil {
+		var commit string
+		commit, err = runCmdOutput(nil, "git", "--git-dir", path, "-c", "user.name=git-sync", "-c", "user.email=git-sync@localhost",
+			"commit-tree", strings.TrimSpace(tree), "-m", "git-sync self-test")
+		if err == nil {
+			err = runCmd("git", "--git-dir", path, "update-ref", "refs/heads/main", strings.TrimSpace(commit))
+		}
+	}
+	if err == nil {
+		err = runCmd("git", "--git-dir", path, "symbolic-ref", "HEAD", "refs/heads/main")
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return path, nil
+}
+
+// selfTestTargetSteps returns the calls syncToTarget and pruneRemote make on target, for a
+// private repo without topics.
+func selfTestTargetSteps(target string) selfTestSteps {
+	switch target {
+	case "gitlab":
+		return selfTestSteps{
+			create: func(name string) error {
+				if err := loadGitLabNamespace(); err != nil {
+					return err
+				}
+				return checkAndValidateGitLabRepos(name, gitLabVisibility("private"), selfTestDescription, nil)
+			},
+			push: func(name, localPath string) error { return syncRepos(config.GitLabToken, name, localPath) },
+			remove: func(name string) error {
+				proj, err := getGitLabProject(name)
+				if err != nil || proj == nil {
+					return notFoundError(err, name)
+				}
+				return deleteGitLabProject(*proj, false)
+			},
+		}
+	case "gitea", "codeberg":
+		return selfTestSteps{
+			create: func(name string) error {
+				return checkAndValidateGiteaRepo(config.GiteaOwner, name, true, selfTestDescription, nil)
+			},
+			push: func(name, localPath string) error {
+				return syncToGitea(config.GiteaOwner, config.GiteaToken, name, localPath)
+			},
+			remove: func(name string) error { return deleteGiteaRepo(config.GiteaOwner, name, false) },
+		}
+	case "bitbucket":
+		return selfTestSteps{
+			create: func(name string) error {
+				return checkAndValidateBitbucketRepo(config.BitbucketWs, name, true, selfTestDescription)
+			},
+			push: func(name, localPath string) error {
+				return syncToBitbucket(config.BitbucketEmail, config.BitbucketToken, config.BitbucketWs, name, localPath)
+			},
+			remove: func(name string) error { return deleteBitbucketRepo(config.BitbucketWs, name) },
+		}
+	case "azure":
+		return selfTestSteps{
+			create: func(name string) error { return checkAndValidateAzureRepo(config.AzureProject, name) },
+			push: func(name, localPath string) error {
+				return syncToAzure(config.AzureToken, config.AzureProject, name, localPath)
+			},
+			remove: func(name string) error {
+				repo, err := getAzureRepo(config.AzureProject, name)
+				if err != nil || repo == nil {
+					return notFoundError(err, name)
+				}
+				return deleteAzureRepo(config.AzureProject, repo.ID)
+			},
+		}
+	case "sourcehut":
+		return selfTestSteps{
+			create: func(name string) error { return checkAndValidateSourceHutRepo(name, "private", selfTestDescription) },
+			push:   func(name, localPath string) error { return syncToSourceHut(config.SourceHutUser, name, localPath) },
+			remove: deleteSourceHutRepo,
+		}
+	case "codecommit":
+		return selfTestSteps{
+			create: func(name string) error { return checkAndValidateCodeCommitRepo(name, selfTestDescription) },
+			push:   syncToCodeCommit,
+			remove: deleteCodeCommitRepo,
+		}
+	case "fs":
+		return selfTestSteps{
+			create: func(name string) error { return checkAndValidateFSRepo(name, selfTestDescription) },
+			push:   syncToFS,
+			remove: deleteFSRepo,
+		}
+	}
+	unsupported := func(string) error { return fmt.Errorf("not supported for %s", target) }
+	return selfTestSteps{create: unsupported, push: func(string, string) error { return nil }, remove: unsupported}
+}
+
+// notFoundError is the error of the lookup before a delete: err, or that name doesn't exist.
+func notFoundError(err error, name string) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%s not found", name)
+}
@@ -0,0 +1,213 @@
+// SourceHut git.sr.ht legacy REST API
+// Overview: https://man.sr.ht/git.sr.ht/api.md
+// Authentication: https://man.sr.ht/api-conventions.md#authentication
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type SourceHutRepo struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Description is null when not set
+	Description string `json:"description"`
+	// Visibility is public, unlisted or private
+	Visibility string `json:"visibility"`
+}
+
+// doSourceHutRequest builds a request against https://git.sr.ht/api and authenticates
+// with a personal access token as Bearer token.
+// Tokens: https://meta.sr.ht/oauth2
+func doSourceHutRequest(method, path string, queryParams map[string]string, body io.Reader) (*http.Response, error) {
+	u, err := url.Parse("https://git.sr.ht/api" + path)
+	if err != nil {
+		return nil, err
+	}
+	if len(queryParams) > 0 {
+		q := u.Query()
+		for k, v := range queryParams {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+config.SourceHutToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doWithRetry(srhtClient, req)
+}
+
+func handleSourceHutResponse(resp *http.Response, target any) (any, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if target == nil {
+			return nil, nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+			return nil, err
+		}
+		return target, nil
+	}
+	return nil, newAPIError("SourceHut", resp)
+}
+
+// sourceHutVisibility maps a destination visibility to SourceHut's; "internal"
+// has no equivalent there and becomes unlisted, reachable only by its URL.
+func sourceHutVisibility(visibility string) string {
+	switch visibility {
+	case "public":
+		return "public"
+	case "internal":
+		return "unlisted"
+	}
+	return "private"
+}
+
+// GET repository of the authenticated user
+// Docs: https://man.sr.ht/git.sr.ht/api.md#get-apiusernamereposname
+func getSourceHutRepo(repoName string) (*SourceHutRepo, error) {
+	resp, err := doSourceHutRequest("GET", "/repos/"+url.PathEscape(repoName), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil
+	}
+	var repo SourceHutRepo
+	if _, err := handleSourceHutResponse(resp, &repo); err != nil {
+		return nil, err
+	}
+	return &repo, nil
+}
+
+// CREATE repository
+// Docs: https://man.sr.ht/git.sr.ht/api.md#post-apirepos
+func createSourceHutRepo(repoName, visibility, description string) (*SourceHutRepo, error) {
+	if config.DryRun {
+		log.Printf("[dry-run] Would create SourceHut repo ~%s/%s (%s)", config.SourceHutUser, repoName, visibility)
+		return &SourceHutRepo{Name: repoName, Visibility: visibility}, nil
+	}
+	byts, _ := json.Marshal(map[string]any{
+		"name":        repoName,
+		"description": description,
+		"visibility":  visibility,
+	})
+	resp, err := doSourceHutRequest("POST", "/repos", nil, bytes.NewReader(byts))
+	if err != nil {
+		return nil, err
+	}
+	var repo SourceHutRepo
+	if _, err := handleSourceHutResponse(resp, &repo); err != nil {
+		return nil, err
+	}
+	log.Printf("Created SourceHut repo ~%s/%s", config.SourceHutUser, repoName)
+	markAction("created")
+	return &repo, nil
+}
+
+// UPDATE repository
+// Docs: https://man.sr.ht/git.sr.ht/api.md#put-apiusernamereposname
+func updateSourceHutRepo(repoName string, fields map[string]any) error {
+	byts, _ := json.Marshal(fields)
+	if config.DryRun {
+		log.Printf("[dry-run] Would update SourceHut repo ~%s/%s: %s", config.SourceHutUser, repoName, byts)
+		return nil
+	}
+	resp, err := doSourceHutRequest("PUT", "/repos/"+url.PathEscape(repoName), nil, bytes.NewReader(byts))
+	if err != nil {
+		return err
+	}
+	if _, err := handleSourceHutResponse(resp, nil); err != nil {
+		return err
+	}
+	markAction("updated")
+	return nil
+}
+
+// Ensure repository exists and matches desired visibility; create or update as needed.
+func checkAndValidateSourceHutRepo(repoName, visibility, description string) error {
+	visibility = sourceHutVisibility(visibility)
+	repo, err := getSourceHutRepo(repoName)
+	if err != nil {
+		return err
+	}
+	if repo == nil {
+		_, err := createSourceHutRepo(repoName, visibility, description)
+		return err
+	}
+	fields := map[string]any{}
+	if repo.Visibility != visibility && confirmChange("sourcehut", repoName, fmt.Sprintf("change the visibility %s -> %s", repo.Visibility, visibility)) {
+		fields["visibility"] = visibility
+	}
+	if repo.Description != description {
+		fields["description"] = description
+	}
+	if len(fields) > 0 {
+		return updateSourceHutRepo(repoName, fields)
+	}
+	log.Printf("SourceHut repo ~%s/%s exists with desired visibility %s and description", config.SourceHutUser, repoName, visibility)
+	return nil
+}
+
+// sourceHutRepoURL returns the SSH git URL of the repo; git.sr.ht only accepts pushes over SSH.
+func sourceHutRepoURL(user, repoName string) string {
+	return fmt.Sprintf("git@git.sr.ht:~%s/%s", strings.TrimPrefix(user, "~"), repoName)
+}
+
+// Push a mirrored repository to SourceHut over SSH, with the key of the running user.
+// SSH keys: https://meta.sr.ht/keys
+func syncToSourceHut(user, repoName, localPath string) error {
+	log.Printf("Pushing %s -> SourceHut (~%s) ...", repoName, strings.TrimPrefix(user, "~"))
+	return pushMirror(localPath, sourceHutRepoURL(user, repoName))
+}
+
+// LIST repositories of the authenticated user
+// Docs: https://man.sr.ht/git.sr.ht/api.md#get-apiusernamerepos
+func listSourceHutRepos() ([]SourceHutRepo, error) {
+	var repos []SourceHutRepo
+	params := map[string]string{}
+	for {
+		resp, err := doSourceHutRequest("GET", "/repos", params, nil)
+		if err != nil {
+			return nil, err
+		}
+		// paginated by cursor: https://man.sr.ht/api-conventions.md#pagination
+		var page struct {
+			Results []SourceHutRepo `json:"results"`
+			Next    *int            `json:"next"`
+		}
+		if _, err := handleSourceHutResponse(resp, &page); err != nil {
+			return nil, err
+		}
+		repos = append(repos, page.Results...)
+		if page.Next == nil {
+			return repos, nil
+		}
+		params["start"] = fmt.Sprint(*page.Next)
+	}
+}
+
+// DELETE repository
+// Docs: https://man.sr.ht/git.sr.ht/api.md#delete-apiusernamereposname
+func deleteSourceHutRepo(repoName string) error {
+	resp, err := doSourceHutRequest("DELETE", "/repos/"+url.PathEscape(repoName), nil, nil)
+	if err != nil {
+		return err
+	}
+	_, err = handleSourceHutResponse(resp, nil)
+	return err
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// syncState is persisted between runs in <logs>/state.json.
+type syncState struct {
+	Repos map[string]*repoState `json:"repos"`
+	// start time of the last run in which every repo synced, for -since-last-run
+	LastSuccessfulRun time.Time `json:"last_successful_run,omitempty"`
+}
+
+type repoState struct {
+	LastSeen time.Time `json:"last_seen"`
+	// Consecutive runs whose GitHub listing succeeded but did not contain the repo.
+	// A destination repo is only considered gone after several of those, so a single
+	// transient GitHub hiccup can never get a good backup deleted.
+	MissingRuns int `json:"missing_runs,omitempty"`
+	// Source is the listing the repo was last seen in, see listingScope. Only the listings
+	// of a run count towards MissingRuns, so e.g. a run without -include-gists can't
+	// get the gists pruned. Empty in state files written before it was recorded.
+	Source string `json:"source,omitempty"`
+	// target -> GitHub pushed_at as of the last successful push there
+	Synced map[string]time.Time `json:"synced,omitempty"`
+	// hash of the mirror's refs after the last successful push
+	RefsHash string `json:"refs_hash,omitempty"`
+	// failed reclones in a row, see QUARANTINE_AFTER
+	RecloneFailures int `json:"reclone_failures,omitempty"`
+	// a quarantined repo is skipped until -reset-quarantine
+	Quarantined bool `json:"quarantined,omitempty"`
+}
+
+var state = &syncState{Repos: make(map[string]*repoState)}
+
+func statePath() string {
+	return filepath.Join(config.LogsFolder, "state.json")
+}
+
+// loadState reads the state of previous runs; a missing file is an empty state.
+func loadState() error {
+	data, err := os.ReadFile(statePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return err
+	}
+	if state.Repos == nil {
+		state.Repos = make(map[string]*repoState)
+	}
+	return nil
+}
+
+func saveState() error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write then rename, so an interrupted run can't leave a truncated state behind
+	tmp := statePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath())
+}
+
+// listingScope returns the listing r comes from with the current settings: "gists",
+// "org:<org>" for a GITHUB_ORG, "installation" for a GitHub App, or the user's repos with
+// the affiliation, visibility or type asked for.
+func listingScope(r GitHubRepo) string {
+	switch {
+	case r.Gist:
+		return "gists"
+	case len(config.GitHubOrgs) > 0:
+		return "org:" + strings.ToLower(r.Owner.Login)
+	case githubApp():
+		return "installation"
+	case config.GitHubType != "":
+		return "user:type=" + config.GitHubType
+	default:
+		return "user:affiliation=" + strings.Join(splitList(config.GitHubAffiliation), ",") + ",visibility=" + config.GitHubVisibility
+	}
+}
+
+// listedScopes returns the scopes the listing of this run covers, see listingScope.
+func listedScopes() map[string]bool {
+	scopes := map[string]bool{}
+	if len(config.GitHubOrgs) > 0 {
+		for _, org := range config.GitHubOrgs {
+			scopes["org:"+strings.ToLower(org)] = true
+		}
+	} else {
+		scopes[listingScope(GitHubRepo{})] = true
+	}
+	if config.IncludeGists {
+		scopes["gists"] = true
+	}
+	return scopes
+}
+
+// recordListing updates the state with a complete and successful GitHub listing.
+// It must not be called with a partial or filtered listing. Repos of other listings than
+// this run's, e.g. gists without -include-gists, are left alone.
+func (s *syncState) recordListing(repos []GitHubRepo) {
+	seen := make(map[string]bool, len(repos))
+	now := time.Now()
+	for _, r := range repos {
+		seen[r.Name] = true
+		if rs, ok := s.Repos[r.Name]; ok {
+			rs.LastSeen, rs.MissingRuns, rs.Source = now, 0, listingScope(r)
+		} else {
+			s.Repos[r.Name] = &repoState{LastSeen: now, Source: listingScope(r)}
+		}
+	}
+	listed := listedScopes()
+	for name, rs := range s.Repos {
+		source := rs.Source
+		if source == "" && strings.HasPrefix(name, "gist-") {
+			// older state files: tell the gists by their name, and count the rest as before
+			source = "gists"
+		}
+		if !seen[name] && (source == "" || listed[source]) {
+			rs.MissingRuns++
+			log.Printf("⚠️ %s is missing from the GitHub listing (%d run(s) in a row, last seen %s)",
+				name, rs.MissingRuns, rs.LastSeen.Format("2006-01-02 15:04:05"))
+		}
+	}
+}
+
+// upToDate reports whether repo was pushed to every target after its last push on
+// GitHub, and its mirror at localPath still has the refs it had back then.
+func (s *syncState) upToDate(repo GitHubRepo, targets []string, localPath string) bool {
+	rs, ok := s.Repos[repo.Name]
+	if !ok || rs.RefsHash == "" || repo.PushedAt.IsZero() {
+		return false
+	}
+	for _, target := range targets {
+		if synced, ok := rs.Synced[target]; !ok || !synced.Equal(repo.PushedAt) {
+			return false
+		}
+	}
+	if _, err := os.Stat(localPath); err != nil {
+		return false
+	}
+	hash, err := refsHash(localPath)
+	return err == nil && hash == rs.RefsHash
+}
+
+// markSynced records that repo was successfully pushed to target from the mirror at localPath.
+func (s *syncState) markSynced(repo GitHubRepo, target, localPath string) {
+	rs, ok := s.Repos[repo.Name]
+	if !ok || repo.PushedAt.IsZero() {
+		return
+	}
+	hash, err := refsHash(localPath)
+	if err != nil {
+		log.Printf("⚠️ Failed to hash the refs of %s: %v", repo.Name, err)
+		return
+	}
+	if rs.RefsHash != hash {
+		// pushes to the other targets were of different refs
+		rs.Synced = nil
+	}
+	if rs.Synced == nil {
+		rs.Synced = make(map[string]time.Time)
+	}
+	rs.RefsHash = hash
+	rs.Synced[target] = repo.PushedAt
+}
+
+// confirmedGone reports whether the destination repo name used to be a GitHub repo and
+// has been missing from at least PRUNE_MIN_MISSING_RUNS consecutive successful listings.
+// The GitHub names go through targetName and are matched case-insensitively, since
+// destinations like Bitbucket lowercase slugs.
+func (s *syncState) confirmedGone(name string) bool {
+	var folded *repoState
+	for n, rs := range s.Repos {
+		if dest := targetName(n); dest == name {
+			return rs.MissingRuns >= config.PruneMinMissingRuns
+		} else if strings.EqualFold(dest, name) {
+			folded = rs
+		}
+	}
+	return folded != nil && folded.MissingRuns >= config.PruneMinMissingRuns
+}
+
+// recordMirror counts the failed reclones of repoName in a row after mirroring it with err,
+// and reports whether that quarantined the repo, after QUARANTINE_AFTER of them. A failed
+// reclone deletes the mirror, so from then on the failed clones count too, until one
+// succeeds. Other errors, e.g. network errors which keep the existing mirror, and
+// interrupts don't count either way.
+func (s *syncState) recordMirror(repoName string, err error) bool {
+	rs, ok := s.Repos[repoName]
+	if !ok {
+		return false
+	}
+	switch {
+	case err == nil:
+		rs.RecloneFailures = 0
+	case errors.Is(err, errCmdInterrupted):
+	case errors.Is(err, errRecloneFailed), errors.Is(err, errCloneFailed) && rs.RecloneFailures > 0:
+		rs.RecloneFailures++
+		if config.QuarantineAfter > 0 && rs.RecloneFailures >= config.QuarantineAfter {
+			rs.Quarantined = true
+			return true
+		}
+	}
+	return false
+}
+
+// quarantined reports whether repoName is skipped because of its failed reclones.
+func (s *syncState) quarantined(repoName string) bool {
+	rs, ok := s.Repos[repoName]
+	return ok && rs.Quarantined
+}
+
+// resetQuarantine lifts the quarantine of the repos in names, or of every repo for "all",
+// and returns the names of the repos it was lifted from.
+func (s *syncState) resetQuarantine(names []string) []string {
+	var reset []string
+	for name, rs := range s.Repos {
+		if rs.Quarantined && (Contains(names, "all") || Contains(names, name)) {
+			rs.Quarantined, rs.RecloneFailures = false, 0
+			reset = append(reset, name)
+		}
+	}
+	sort.Strings(reset)
+	return reset
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runSummary collects notable per-repo outcomes which are reported once at the end of the run,
+// so they don't get lost among the per-repo log lines.
+type runSummary struct {
+	// repos whose organization enforces SAML SSO for a token that is not authorized
+	ssoBlocked []string
+	// repo name -> GitHub security settings which are enabled but not carried over
+	securityNotMirrored map[string][]string
+	// repo name -> ref count, for repos exceeding MAX_REFS
+	refCounts map[string]refCountResult
+	// "repo: old -> new" for every destination default branch that was corrected
+	defaultBranchFixes []string
+	// repos skipped because of -max-repo-size-mb
+	tooLarge []string
+	// repos without any ref, skipped with -skip-empty
+	empty []string
+	// "owner/repo (taken by other/repo)" for the repos skipped for a taken destination name
+	nameCollisions []string
+	// repos skipped, or quarantined in this run, after failed reclones
+	quarantined []string
+	// notStarted is the number of repos left when the -run-deadline passed
+	notStarted int
+	// size of the backup dir, for -target local
+	backupBytes int64
+	// repo name -> size of its mirror clone after the fetch
+	mirrorBytes map[string]int64
+}
+
+type refCountResult struct {
+	Refs   int    // refs left after the MAX_REFS_ACTION
+	Action string // warn | filter | skip
+}
+
+var summary runSummary
+
+func logSummary() {
+	log.Printf("📋 Summary (run %s)", runID)
+	if len(summary.ssoBlocked) > 0 {
+		log.Printf("🔐 %d repo(s) skipped because GITHUB_TOKEN is not authorized for SAML SSO: %s",
+			len(summary.ssoBlocked), strings.Join(summary.ssoBlocked, ", "))
+		log.Printf("   Authorize the token for the organization(s) at https://github.com/settings/tokens (Configure SSO), then run again.")
+	}
+	if summary.notStarted > 0 {
+		log.Printf("⏰ %d repo(s) not synced, the run deadline of %v passed; they are synced by the next run", summary.notStarted, config.RunDeadline)
+	}
+	if len(summary.nameCollisions) > 0 {
+		log.Printf("⚠️ %d repo(s) skipped because another repo syncs to the same destination name: %s",
+			len(summary.nameCollisions), strings.Join(summary.nameCollisions, ", "))
+		log.Printf("   Give them distinct names with -name-replace, -name-prefix or -name-suffix.")
+	}
+	if len(summary.quarantined) > 0 {
+		log.Printf("🚧 %d repo(s) quarantined after %d failed reclones in a row, and skipped: %s",
+			len(summary.quarantined), config.QuarantineAfter, strings.Join(summary.quarantined, ", "))
+		log.Printf("   Fix the cause (see the reclone errors of earlier runs), then run with -reset-quarantine <names> or all.")
+	}
+	if len(summary.securityNotMirrored) > 0 {
+		log.Printf("⚠️ %d repo(s) have GitHub security settings enabled which are NOT mirrored, reconfigure them on the destination manually:",
+			len(summary.securityNotMirrored))
+		names := make([]string, 0, len(summary.securityNotMirrored))
+		for name := range summary.securityNotMirrored {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			log.Printf("   - %s: %s", name, strings.Join(summary.securityNotMirrored[name], ", "))
+		}
+	}
+	if len(summary.refCounts) > 0 {
+		log.Printf("🏷️ %d repo(s) exceed MAX_REFS=%d:", len(summary.refCounts), config.MaxRefs)
+		names := make([]string, 0, len(summary.refCounts))
+		for name := range summary.refCounts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			result := summary.refCounts[name]
+			log.Printf("   - %s: %d refs (%s)", name, result.Refs, result.Action)
+		}
+	}
+	if len(summary.tooLarge) > 0 {
+		log.Printf("🐘 %d repo(s) skipped for exceeding -max-repo-size-mb=%d: %s",
+			len(summary.tooLarge), config.MaxRepoSizeMB, strings.Join(summary.tooLarge, ", "))
+	}
+	if len(summary.empty) > 0 {
+		what := "created on the targets without content"
+		if config.SkipEmpty {
+			what = "skipped (-skip-empty)"
+		}
+		log.Printf("🫙 %d empty repo(s) %s: %s", len(summary.empty), what, strings.Join(summary.empty, ", "))
+	}
+	if len(summary.mirrorBytes) > 0 {
+		names := make([]string, 0, len(summary.mirrorBytes))
+		var total int64
+		for name, size := range summary.mirrorBytes {
+			names = append(names, name)
+			total += size
+		}
+		// biggest first, those are the candidates for -exclude or -max-repo-size-mb
+		sort.Slice(names, func(i, j int) bool {
+			if summary.mirrorBytes[names[i]] != summary.mirrorBytes[names[j]] {
+				return summary.mirrorBytes[names[i]] > summary.mirrorBytes[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		log.Printf("💾 %d mirror(s) synced this run take %s on disk:", len(names), formatBytes(total))
+		for _, name := range names {
+			log.Printf("   - %s: %s", name, formatBytes(summary.mirrorBytes[name]))
+		}
+	}
+	if summary.backupBytes > 0 {
+		log.Printf("💾 %s of mirrors on disk in %s", formatBytes(summary.backupBytes), config.BackupDir)
+	}
+	if len(summary.defaultBranchFixes) > 0 {
+		log.Printf("🌿 Corrected the destination default branch of %d repo(s):", len(summary.defaultBranchFixes))
+		for _, fix := range summary.defaultBranchFixes {
+			log.Printf("   - %s", fix)
+		}
+	}
+	if config.Trace {
+		log.Printf("⏱️ Listing GitHub repos took %v, slowest repos:", listingTrace.Round(time.Millisecond))
+		for _, t := range slowestRepos(5) {
+			log.Printf("   - %s: %v (mirror %dms, validate %dms, push %dms)", t.Repo,
+				time.Duration(t.TotalMS)*time.Millisecond, t.PhasesMS["mirror"], t.PhasesMS["validate"], t.PhasesMS["push"])
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// repoTrace holds the wall-clock time spent per phase (mirror, validate, push) of one repo.
+type repoTrace struct {
+	Repo     string           `json:"repo"`
+	PhasesMS map[string]int64 `json:"phases_ms"`
+	TotalMS  int64            `json:"total_ms"`
+}
+
+var (
+	listingTrace time.Duration
+	repoTraces   []*repoTrace
+)
+
+// tracePhase runs f and, in -trace mode, records its duration as phase of repoName.
+// With OTEL_EXPORTER_OTLP_ENDPOINT set, the phase is also recorded as a span, see otel.go.
+func tracePhase(repoName, phase string, f func() error) error {
+	span := startPhaseSpan(repoName, phase)
+	start := time.Now()
+	err := f()
+	span.finish(err)
+	if !config.Trace {
+		return err
+	}
+	elapsed := time.Since(start).Milliseconds()
+
+	var t *repoTrace
+	if n := len(repoTraces); n > 0 && repoTraces[n-1].Repo == repoName {
+		t = repoTraces[n-1]
+	} else {
+		t = &repoTrace{Repo: repoName, PhasesMS: make(map[string]int64)}
+		repoTraces = append(repoTraces, t)
+	}
+	t.PhasesMS[phase] += elapsed
+	t.TotalMS += elapsed
+	return err
+}
+
+// slowestRepos returns up to n traces ordered by descending total time.
+func slowestRepos(n int) []*repoTrace {
+	sorted := append([]*repoTrace{}, repoTraces...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].TotalMS > sorted[j].TotalMS })
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// writeTrace saves the collected timings as JSON into the logs folder.
+func writeTrace() {
+	data, err := json.MarshalIndent(map[string]any{
+		"run_id":     runID,
+		"listing_ms": listingTrace.Milliseconds(),
+		"repos":      repoTraces,
+	}, "", "  ")
+	if err != nil {
+		log.Printf("⚠️ Failed to encode trace: %v", err)
+		return
+	}
+	tracePath := runFile(fmt.Sprintf("trace_%s.json", runID), "trace.json")
+	if err := os.WriteFile(tracePath, data, 0644); err != nil {
+		log.Printf("⚠️ Failed to write trace: %v", err)
+		return
+	}
+	log.Printf("⏱️ Trace written to %s", tracePath)
+}
@@ -1,8 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 func Map[T any, R any](input []T, f func(T) R) []R {
@@ -13,11 +26,336 @@ func Map[T any, R any](input []T, f func(T) R) []R {
 	return result
 }
 
+func Contains[T comparable](input []T, v T) bool {
+	for _, item := range input {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	errCmdTimeout     = errors.New("timed out")
+	errCmdInterrupted = errors.New("interrupted")
+)
+
+// newCmd returns a command which is killed, together with its process group, when it
+// runs longer than -git-timeout or when the run is interrupted. Pass the result of
+// cmd.Run() through done, which releases the command and describes a timeout.
+func newCmd(name string, args ...string) (cmd *exec.Cmd, done func(error) error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if config.GitTimeout > 0 {
+		ctx, cancel = context.WithTimeout(runCtx, config.GitTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(runCtx)
+	}
+	if name == "git" {
+		// -git-config comes after the proxy, so it can override http.proxy too
+		args = append(append(gitProxyArgs(), gitConfigArgs()...), gitVerbosityArgs(args)...)
+	}
+	cmd = exec.CommandContext(ctx, name, args...)
+	if name == "git" && config.NoProxy != "" {
+		// git reads NO_PROXY from the environment only, it may come from the -config file
+		cmd.Env = append(os.Environ(), "NO_PROXY="+config.NoProxy, "no_proxy="+config.NoProxy)
+	}
+	setProcessGroup(cmd)
+	// don't hang on helpers which inherited the output pipes and outlived the kill
+	cmd.WaitDelay = 10 * time.Second
+	var span *otelSpan
+	if name == "git" {
+		span = startSpan(gitSpanName(args), nil)
+	}
+	runningCmds.Add(1)
+	return cmd, func(err error) error {
+		defer runningCmds.Done()
+		defer cancel()
+		if err != nil {
+			switch ctx.Err() {
+			case context.DeadlineExceeded:
+				err = fmt.Errorf("%w after %v (-git-timeout)", errCmdTimeout, config.GitTimeout)
+			case context.Canceled:
+				err = errCmdInterrupted
+			}
+		}
+		span.finish(err)
+		return err
+	}
+}
+
+// gitSubcommand returns the index of the git subcommand in args, skipping the global
+// options in front of it (e.g. -c http.proxy=... --git-dir x push), or -1.
+func gitSubcommand(args []string) int {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-c" || args[i] == "--git-dir" || args[i] == "-C":
+			i++
+		case !strings.HasPrefix(args[i], "-"):
+			return i
+		}
+	}
+	return -1
+}
+
+// gitConfigArgs returns the -c options of -git-config.
+func gitConfigArgs() []string {
+	var args []string
+	for _, kv := range config.GitConfig {
+		args = append(args, "-c", kv)
+	}
+	return args
+}
+
+// checkGitConfigOption checks that a -git-config is a key=value with a section.name key,
+// as git would otherwise fail every command with it.
+func checkGitConfigOption(kv string) error {
+	key, _, ok := strings.Cut(kv, "=")
+	if !ok {
+		return fmt.Errorf("%q is not of the form key=value", kv)
+	}
+	if i := strings.Index(key, "."); i <= 0 || strings.HasSuffix(key, ".") || strings.ContainsAny(key, " \t") {
+		return fmt.Errorf("%q is not a git config key like http.postBuffer", key)
+	}
+	return nil
+}
+
+// gitVerbosityArgs adds the options of -verbose-git or -quiet-git right after the clone,
+// fetch or push subcommand in args.
+func gitVerbosityArgs(args []string) []string {
+	i := gitSubcommand(args)
+	if config.GitVerbosity == "" || i < 0 {
+		return args
+	}
+	var extra []string
+	switch sub := args[i]; {
+	case sub != "clone" && sub != "fetch" && sub != "push":
+		return args
+	case config.GitVerbosity == "verbose":
+		extra = []string{"--verbose", "--progress"}
+	case sub == "push":
+		// --quiet would also drop the "Everything up-to-date" pushMirror tells unchanged repos by
+		extra = []string{"--no-progress"}
+	default:
+		extra = []string{"--quiet"}
+	}
+	return append(append(append([]string{}, args[:i+1]...), extra...), args[i+1:]...)
+}
+
+// cmdError describes a failed command with the last lines of its stderr,
+// e.g. "git push --mirror https://***@gitlab.com/a/b.git failed: remote: ...: exit status 1".
+func cmdError(name string, args []string, stderr string, err error) error {
+	if err == nil {
+		return nil
+	}
+	command := redactText(name + " " + strings.Join(args, " "))
+	var lines []string
+	for _, line := range strings.Split(stderr, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > 3 {
+		lines = lines[len(lines)-3:]
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("%s failed: %w", command, err)
+	}
+	return fmt.Errorf("%s failed: %s: %w", command, redactText(strings.Join(lines, " | ")), err)
+}
+
 func runCmd(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
+	_, err := runCmdCapture(name, args...)
+	return err
+}
+
+// withCredentials returns rawURL with user and password set as its userinfo,
+// whatever its scheme and host are.
+func withCredentials(rawURL, user, password string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	u.User = url.UserPassword(user, password)
+	return u.String(), nil
+}
+
+// pushMirror pushes all refs of the mirror at localPath to pushURL, which carries the credentials.
+func pushMirror(localPath, pushURL string) error {
+	// -refspec narrows the push of the repos, wikis are always mirrored whole
+	narrow := len(config.Refspecs) > 0 && !strings.HasSuffix(localPath, ".wiki.git")
+	if config.DryRun {
+		if narrow {
+			log.Printf("[dry-run] Would run git push %s %s", redactText(pushURL), strings.Join(config.Refspecs, " "))
+		} else {
+			log.Printf("[dry-run] Would run git push --mirror %s", redactText(pushURL))
+		}
+		return nil
+	}
+	if refs, err := listRefs(localPath); err == nil && len(refs) == 0 {
+		log.Printf("Nothing to push from %s, it has no refs", localPath)
+		return nil
+	}
+	args := append(partialCloneArgs(localPath), "--git-dir", localPath, "push", "--mirror", pushURL)
+	if narrow {
+		// unlike --mirror, refs deleted on GitHub are left on the destination
+		args = append(append(partialCloneArgs(localPath), "--git-dir", localPath, "push", pushURL), config.Refspecs...)
+	}
+	stderr, err := runCmdCapture("git", args...)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(stderr, "Everything up-to-date") {
+		markAction("updated")
+	}
+	if config.VerifyPush {
+		refspecs := []string{"+refs/*:refs/*"}
+		if narrow {
+			refspecs = config.Refspecs
+		}
+		return verifyPush(localPath, pushURL, refspecs)
+	}
+	return nil
+}
+
+// pushRefs pushes only the refs matching refspecs, removing the ones deleted locally.
+func pushRefs(localPath, pushURL string, refspecs ...string) error {
+	if config.DryRun {
+		log.Printf("[dry-run] Would run git push --prune %s %s", redactText(pushURL), strings.Join(refspecs, " "))
+		return nil
+	}
+	args := append(append(partialCloneArgs(localPath), "--git-dir", localPath, "push", "--prune", pushURL), refspecs...)
+	stderr, err := runCmdCapture("git", args...)
+	if err == nil && !strings.Contains(stderr, "Everything up-to-date") {
+		markAction("updated")
+	}
+	return err
+}
+
+// runCmdCapture is like runCmd but also returns the child's stderr,
+// so callers can inspect error messages printed by git. Tests replace it with a fake.
+var runCmdCapture = execCmdCapture
+
+func execCmdCapture(name string, args ...string) (string, error) {
+	cmd, done := newCmd(name, args...)
+	var stderr bytes.Buffer
 	// Send child process output to the same log file
-	writer := log.Writer()
+	writer := redactingWriter{log.Writer()}
 	cmd.Stdout = writer
-	cmd.Stderr = writer
-	return cmd.Run()
+	cmd.Stderr = io.MultiWriter(writer, &stderr)
+	err := done(cmd.Run())
+	return stderr.String(), cmdError(name, args, stderr.String(), err)
+}
+
+// splitList splits a comma-separated value, trimming spaces and dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// mergeTopics returns have followed by every topic of want that is not already
+// present (compared case-insensitively), and whether anything was added.
+func mergeTopics(have, want []string) ([]string, bool) {
+	seen := make(map[string]bool, len(have))
+	merged := append([]string{}, have...)
+	for _, t := range have {
+		seen[strings.ToLower(t)] = true
+	}
+	added := false
+	for _, t := range want {
+		if !seen[strings.ToLower(t)] {
+			seen[strings.ToLower(t)] = true
+			merged = append(merged, t)
+			added = true
+		}
+	}
+	return merged, added
+}
+
+// runCmdOutput runs the command and returns its stdout, which is not logged
+// since it's meant to be parsed (e.g. thousands of refs). stderr still goes to the log.
+func runCmdOutput(stdin io.Reader, name string, args ...string) (string, error) {
+	cmd, done := newCmd(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = io.MultiWriter(redactingWriter{log.Writer()}, &stderr)
+	err := done(cmd.Run())
+	return stdout.String(), cmdError(name, args, stderr.String(), err)
+}
+
+// ensureWritableDir creates dir if needed and checks that files can be created in it.
+func ensureWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// dirSize returns the total size in bytes of all regular files below path.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// newRunID returns an ID like "20060102_150405-1a2b3c": sortable by start time,
+// and unique even when two runs start within the same second.
+func newRunID(started time.Time) string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return started.Format("20060102_150405") + "-" + hex.EncodeToString(b)
+}
+
+// listFlag is a flag which can be repeated, collecting every value.
+type listFlag []string
+
+func (f *listFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// normalizeRefspec turns a -refspec into a forced push refspec like --mirror's: a bare
+// branch name or pattern (main, release/*) means the branch of the same name on the
+// destination, full refspecs (refs/tags/*, refs/heads/a:refs/heads/b) are kept as they are.
+func normalizeRefspec(refspec string) (string, error) {
+	spec := strings.TrimPrefix(refspec, "+")
+	if spec == "" || strings.HasPrefix(spec, ":") {
+		return "", fmt.Errorf("invalid refspec %q (deleting refs isn't supported)", refspec)
+	}
+	if !strings.Contains(spec, ":") {
+		if !strings.HasPrefix(spec, "refs/") {
+			spec = "refs/heads/" + spec
+		}
+		spec += ":" + spec
+	}
+	src, dst, _ := strings.Cut(spec, ":")
+	if strings.Count(src, "*") != strings.Count(dst, "*") || strings.Count(src, "*") > 1 {
+		return "", fmt.Errorf("invalid refspec %q: both sides need the same single *", refspec)
+	}
+	return "+" + spec, nil
 }
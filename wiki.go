@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// GitHub wikis are separate git repos next to their repo, at <repo>.wiki.git, and so are
+// the GitLab and Gitea ones. The wiki mirror lives next to the repo's in the backup dir.
+
+// wikiPath returns the path of the wiki mirror of the repo mirrored at localPath.
+func wikiPath(localPath string) string {
+	return strings.TrimSuffix(localPath, ".git") + ".wiki.git"
+}
+
+// mirrorWiki mirrors the wiki of repo, and returns the path of the mirror or "" when the
+// repo has no wiki. GitHub only creates the wiki repo with the first page, so an enabled
+// but empty wiki answers not found, like a disabled one.
+func mirrorWiki(repo GitHubRepo, localPath string) (string, error) {
+	if !repo.HasWiki {
+		return "", nil
+	}
+	url := strings.TrimSuffix(repo.CloneURL, ".git") + ".wiki.git"
+	path := wikiPath(localPath)
+	err := mirrorReposFromGitHub(repo.Name+".wiki", url, path, 0)
+	if err != nil && isMissingRepo(err) {
+		log.Printf("%s has no wiki pages, skipping its wiki", repo.Name)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// isMissingRepo reports whether git failed because the remote repo doesn't exist.
+func isMissingRepo(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "not found") || strings.Contains(msg, "repository not exported")
+}
+
+// syncWiki pushes the wiki mirror at path to the wiki of repoName on target.
+func syncWiki(target, repoName, path string) error {
+	switch target {
+	case "gitlab":
+		// the project's wiki must be enabled, which it is by default
+		return syncRepos(config.GitLabToken, repoName+".wiki", path)
+	case "gitea", "codeberg":
+		return syncToGitea(config.GiteaOwner, config.GiteaToken, repoName+".wiki", path)
+	case "fs":
+		if err := checkAndValidateFSRepo(repoName+".wiki", ""); err != nil {
+			return err
+		}
+		return syncToFS(repoName+".wiki", path)
+	}
+	log.Printf("Skipping the wiki of %s: -sync-wiki isn't supported for %s", repoName, target)
+	return nil
+}